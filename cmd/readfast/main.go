@@ -79,7 +79,7 @@ func run(server, session string, maxRows int, parse, format bool, allocs bool) {
 	}
 	defer safeClose(info.DB, &err)
 	log.Info(fmt.Sprintf("server:  %s (%s)", info.Server, info.Version))
-	xeSession, err := xe.GetSession(info.DB, session)
+	xeSession, err := xe.GetSession(info.DB, info.Scope(), session)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -34,6 +35,8 @@ func main() {
 	filelog := flag.Bool("log", false, "Force logging to JSON file")
 	loop := flag.Bool("loop", false, "continue polling until canceleld (command-line only)")
 	versionOnly := flag.Bool("version", false, "print version and exit")
+	deploy := flag.Bool("deploy", false, "create, alter and start the sessions in [[sessions.deploy]] and exit")
+	dryRun := flag.Bool("dryrun", false, "with -deploy, log the changes without making them")
 	flag.Parse()
 
 	appdir, err := filepath.Abs(filepath.Dir(os.Args[0]))
@@ -148,6 +151,14 @@ func main() {
 		return
 	}
 
+	if *deploy {
+		err = prg.Deploy(context.Background(), *dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Set GOMAXPROCS if we are running in a container
 	fn := log.Infof
 	undo, err := maxprocs.Set(maxprocs.Logger(fn))
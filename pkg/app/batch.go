@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/metric"
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/billgraziano/xelogstash/pkg/sink"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// batchSize is how many events we hold before writing them to the sinks.
+// We only write when a file offset is finished so a batch can be larger.
+const batchSize = 1000
+
+// checkpoint is a file offset that can be saved once every event
+// read before it is confirmed by the sinks
+type checkpoint struct {
+	fileName string
+	offset   int64
+	end      int // number of events in the batch through this offset
+}
+
+// checkpointer saves the last offset where every event was delivered
+type checkpointer interface {
+	Save(fileName string, offset int64, xestatus string) error
+}
+
+// batch holds events that have been read but not confirmed by the sinks
+type batch struct {
+	events      []sink.Event
+	checkpoints []checkpoint
+	blocked     map[int]xe.Event // blocked process reports by position in events
+}
+
+func (b *batch) add(ev sink.Event) {
+	b.events = append(b.events, ev)
+}
+
+// addBlocked adds a blocked process report.  The parsed event is kept so
+// the head blocker can be added once the rest of the chain has been read.
+func (b *batch) addBlocked(ev sink.Event, event xe.Event) {
+	if b.blocked == nil {
+		b.blocked = make(map[int]xe.Event)
+	}
+	b.blocked[len(b.events)] = event
+	b.add(ev)
+}
+
+// mark records that every event at this file offset has been read
+func (b *batch) mark(fileName string, offset int64) {
+	if n := len(b.checkpoints); n > 0 {
+		last := b.checkpoints[n-1]
+		if last.fileName == fileName && last.offset == offset {
+			b.checkpoints[n-1].end = len(b.events)
+			return
+		}
+	}
+	b.checkpoints = append(b.checkpoints, checkpoint{fileName: fileName, offset: offset, end: len(b.events)})
+}
+
+// full is true once the batch has batchSize events.  The deadlock monitor
+// writes the blocked process reports from a pass together so a batch isn't
+// written in the middle of them.  That way each report's chain is complete
+// when its head blocker is set.
+func (b *batch) full(es *eventSource) bool {
+	return len(b.events) >= batchSize && !es.chains.reading
+}
+
+func (b *batch) reset() {
+	b.events = b.events[:0]
+	b.checkpoints = b.checkpoints[:0]
+	b.blocked = nil
+}
+
+// commit writes the batch to every sink and saves the last offset where
+// every sink confirmed every event before it.  Events after that offset
+// are read again on the next poll so delivery is at-least-once.
+func (p *Program) commit(ctx context.Context, es *eventSource, b *batch, sf checkpointer) error {
+	defer b.reset()
+	if len(b.checkpoints) == 0 {
+		return nil
+	}
+
+	es.setHeadBlockers(b)
+
+	delivered := len(b.events)
+	var err error
+	if len(b.events) > 0 {
+		for i := range p.Sinks {
+			snk := *p.Sinks[i]
+			n, writeErr := sink.Delivered(len(b.events), snk.WriteBatch(ctx, b.events))
+			if writeErr != nil {
+				writeErr = errors.Wrap(writeErr, fmt.Sprintf("sink.writebatch: %s", snk.Name()))
+				log.Error(writeErr)
+				if err == nil {
+					err = writeErr
+				}
+			}
+			if n < delivered {
+				delivered = n
+			}
+		}
+	}
+	for _, ev := range b.events[:delivered] {
+		es.written(ev)
+	}
+
+	var cp *checkpoint
+	for i := range b.checkpoints {
+		if b.checkpoints[i].end > delivered {
+			break
+		}
+		cp = &b.checkpoints[i]
+	}
+	if cp != nil {
+		saveErr := sf.Save(cp.fileName, cp.offset, status.StateSuccess)
+		if saveErr != nil && err == nil {
+			err = errors.Wrap(saveErr, "status.save")
+		}
+	}
+	return err
+}
+
+// setHeadBlockers adds the head blocker to the blocked process reports in
+// the batch using every report read so far in this poll
+func (es *eventSource) setHeadBlockers(b *batch) {
+	for i, event := range b.blocked {
+		if !es.chains.setHeadBlocker(event) {
+			continue
+		}
+		rs, err := es.render(event)
+		if err != nil {
+			log.Error(errors.Wrap(err, "blocked_process_report: render"))
+			continue
+		}
+		b.events[i].Payload = rs
+	}
+}
+
+// written updates the counters for an event confirmed by the sinks
+func (es *eventSource) written(ev sink.Event) {
+	totalCount.Add(1)
+	expvar.Get("app:eventsWritten").(metric.Metric).Add(1)
+	prom.EventsWritten.With(prometheus.Labels{"event": ev.Name, "domain": strings.ToLower(es.info.Domain), "server": es.promServerLabel}).Inc()
+	prom.BytesWritten.With(prometheus.Labels{"event": ev.Name, "domain": strings.ToLower(es.info.Domain), "server": es.promServerLabel}).Add(float64(len(ev.Payload)))
+	observeLag(es.info.Domain, es.promServerLabel, es.session, ev.Time)
+
+	eventCount.Add(ev.Name, 1)
+	serverKey := fmt.Sprintf("%s-%s-%s", es.info.Domain, strings.Replace(es.info.Server, "\\", "-", -1), es.session)
+	serverCount.Add(serverKey, 1)
+}
+
+// observeLag records how long ago an event happened when the sinks
+// confirmed it and the timestamp of the last event written for the session
+func observeLag(domain, server, session string, ts time.Time) {
+	if ts.IsZero() {
+		return
+	}
+	domain = strings.ToLower(domain)
+	prom.EventLag.With(prometheus.Labels{"domain": domain, "server": server}).Observe(time.Since(ts).Seconds())
+	prom.EventLastTimestamp.With(prometheus.Labels{"domain": domain, "server": server, "session": session}).Set(float64(ts.UnixNano()) / 1e9)
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/sink"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var expvarOnce sync.Once
+
+// nackSink confirms events until it reaches failAt
+type nackSink struct {
+	failAt int
+	got    []sink.Event
+}
+
+func (n *nackSink) Open(context.Context, string) error                 { return nil }
+func (n *nackSink) Write(context.Context, string, string) (int, error) { return 0, nil }
+func (n *nackSink) WriteBatch(_ context.Context, events []sink.Event) []error {
+	n.got = append(n.got, events...)
+	if n.failAt < 0 || n.failAt >= len(events) {
+		return nil
+	}
+	return sink.Reject(events, n.failAt, errors.New("nack"))
+}
+func (n *nackSink) Flush() error         { return nil }
+func (n *nackSink) Close() error         { return nil }
+func (n *nackSink) Name() string         { return "nack" }
+func (n *nackSink) Clean() error         { return nil }
+func (n *nackSink) Reopen() error        { return nil }
+func (n *nackSink) SetLogger(*log.Entry) {}
+
+// testCommit commits a batch of five events over four offsets
+// and returns the last offset saved to the state file
+func testCommit(t *testing.T, failAt int) (string, int64, error) {
+	expvarOnce.Do(ConfigureExpvar)
+	var snk sink.Sinker = &nackSink{failAt: failAt}
+	p := &Program{Sinks: []*sink.Sinker{&snk}}
+	es := &eventSource{info: &xe.SQLInfo{Server: "test"}}
+
+	sf, err := status.NewFile("test", "test", status.ClassXE, fmt.Sprintf("commit_%d", failAt))
+	assert.NoError(t, err)
+	_ = os.Remove(sf.Name)
+	_, _, _, err = sf.GetOffset()
+	assert.NoError(t, err)
+
+	var b batch
+	b.add(sink.Event{Name: "login", Payload: "{}"})
+	b.add(sink.Event{Name: "login", Payload: "{}"})
+	b.mark("a.xel", 100)
+	b.mark("a.xel", 200) // nothing written from this offset
+	b.add(sink.Event{Name: "login", Payload: "{}"})
+	b.add(sink.Event{Name: "login", Payload: "{}"})
+	b.mark("a.xel", 300)
+	b.add(sink.Event{Name: "login", Payload: "{}"})
+	b.mark("b.xel", 100)
+	commitErr := p.commit(context.Background(), es, &b, &sf)
+	assert.Equal(t, 0, len(b.events))
+
+	check, err := status.NewFile("test", "test", status.ClassXE, fmt.Sprintf("commit_%d", failAt))
+	assert.NoError(t, err)
+	fileName, offset, _, err := check.GetOffset()
+	assert.NoError(t, err)
+	return fileName, offset, commitErr
+}
+
+func TestCommitAll(t *testing.T) {
+	assert := assert.New(t)
+	fileName, offset, err := testCommit(t, -1)
+	assert.NoError(err)
+	assert.Equal("b.xel", fileName)
+	assert.Equal(int64(100), offset)
+}
+
+func TestCommitPartial(t *testing.T) {
+	assert := assert.New(t)
+	// the fourth event fails so a.xel 300 isn't finished
+	fileName, offset, err := testCommit(t, 3)
+	assert.Error(err)
+	assert.Equal("a.xel", fileName)
+	assert.Equal(int64(200), offset)
+}
+
+func TestCommitNone(t *testing.T) {
+	assert := assert.New(t)
+	fileName, _, err := testCommit(t, 0)
+	assert.Error(err)
+	assert.Equal("", fileName)
+}
+
+func TestCommitRingBuffer(t *testing.T) {
+	assert := assert.New(t)
+	expvarOnce.Do(ConfigureExpvar)
+	var snk sink.Sinker = &nackSink{failAt: 2}
+	p := &Program{Sinks: []*sink.Sinker{&snk}}
+	es := &eventSource{info: &xe.SQLInfo{Server: "test"}}
+
+	ring, err := status.NewRing("test", "test", "commit_ring")
+	assert.NoError(err)
+	_ = os.Remove(ring.Name)
+	t0 := time.Date(2024, 4, 8, 16, 0, 0, 0, time.UTC)
+	rc := ringCheckpoints{ring: &ring}
+	for i := 0; i < 3; i++ {
+		ev := xe.RingEvent{Timestamp: t0.Add(time.Duration(i) * time.Second), XML: fmt.Sprintf("<event %d/>", i)}
+		rc.events = append(rc.events, ev)
+		rc.hashes = append(rc.hashes, ringHash(ev.XML))
+	}
+
+	var b batch
+	for i := range rc.events {
+		b.add(sink.Event{Name: "login", Payload: "{}"})
+		b.mark(ringTarget, int64(i+1))
+	}
+	err = p.commit(context.Background(), es, &b, &rc)
+	assert.Error(err)
+
+	// only the first two events were delivered
+	check, err := status.NewRing("test", "test", "commit_ring")
+	assert.NoError(err)
+	assert.True(check.Seen(rc.events[0].Timestamp, rc.hashes[0]))
+	assert.True(check.Seen(rc.events[1].Timestamp, rc.hashes[1]))
+	assert.False(check.Seen(rc.events[2].Timestamp, rc.hashes[2]))
+	_ = os.Remove(ring.Name)
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"github.com/billgraziano/xelogstash/pkg/xe"
+)
+
+// blockingChains links the blocked process reports read in a poll.
+// Reports from the same pass of the deadlock monitor share a monitor
+// loop.  Each one says which session is blocked by which.  Following
+// those links finds the head blocker: the session at the front of the
+// chain that isn't blocked itself.  Every report is added, even ones a
+// filter drops, so the chains are complete.
+type blockingChains struct {
+	loops   map[int64]*blockingLoop
+	reading bool // the last event read was a blocked process report
+}
+
+// blockingLoop holds the links from one pass of the deadlock monitor
+type blockingLoop struct {
+	blockedBy map[int64]int64    // blocked spid -> blocking spid
+	blockers  map[int64]xe.Event // blocking spid -> report that has its details
+}
+
+// add records the blocked and blocking sessions from a blocked process report
+func (bc *blockingChains) add(event xe.Event) {
+	blocked, ok1 := event.GetInt64("xe_blocked_spid")
+	blocking, ok2 := event.GetInt64("xe_blocking_spid")
+	if !ok1 || !ok2 || blocking == 0 {
+		return
+	}
+	loopID, _ := event.GetInt64("xe_monitor_loop")
+	if bc.loops == nil {
+		bc.loops = make(map[int64]*blockingLoop)
+	}
+	loop, ok := bc.loops[loopID]
+	if !ok {
+		loop = &blockingLoop{
+			blockedBy: make(map[int64]int64),
+			blockers:  make(map[int64]xe.Event),
+		}
+		bc.loops[loopID] = loop
+	}
+	if blocked != blocking { // parallel queries can block themselves
+		loop.blockedBy[blocked] = blocking
+	}
+	loop.blockers[blocking] = event
+}
+
+// headBlocker returns the spid at the front of the chain for a blocked
+// process report, the report with its details, and how many sessions
+// are in the chain between the blocked session and the head.  A depth
+// of one means the blocking session is the head blocker.
+func (bc *blockingChains) headBlocker(event xe.Event) (int64, xe.Event, int, bool) {
+	head, ok := event.GetInt64("xe_blocking_spid")
+	if !ok || head == 0 {
+		return 0, nil, 0, false
+	}
+	loopID, _ := event.GetInt64("xe_monitor_loop")
+	loop, ok := bc.loops[loopID]
+	if !ok {
+		return 0, nil, 0, false
+	}
+
+	blocked, _ := event.GetInt64("xe_blocked_spid")
+	seen := map[int64]bool{blocked: true, head: true}
+	depth := 1
+	for {
+		next, ok := loop.blockedBy[head]
+		if !ok || seen[next] { // the head isn't blocked or we found a cycle
+			break
+		}
+		seen[next] = true
+		head = next
+		depth++
+	}
+	return head, loop.blockers[head], depth, true
+}
+
+// setHeadBlocker adds the xe_head_blocker_* fields to a blocked process report
+func (bc *blockingChains) setHeadBlocker(event xe.Event) bool {
+	head, report, depth, ok := bc.headBlocker(event)
+	if !ok {
+		return false
+	}
+	event.Set("xe_head_blocker_spid", head)
+	event.Set("xe_head_blocker_depth", depth)
+	for _, f := range []string{"login", "host", "app", "database", "status", "input_buffer"} {
+		if v := report.GetString("xe_blocking_" + f); v != "" {
+			event.Set("xe_head_blocker_"+f, v)
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/filter"
+	"github.com/billgraziano/xelogstash/pkg/sink"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func blockedReport(loop, blocked, blocking int64, login string) xe.Event {
+	return xe.Event{
+		"name":              "blocked_process_report",
+		"xe_monitor_loop":   loop,
+		"xe_blocked_spid":   blocked,
+		"xe_blocking_spid":  blocking,
+		"xe_blocking_login": login,
+		"xe_blocking_app":   login + "-app",
+	}
+}
+
+func TestHeadBlocker(t *testing.T) {
+	assert := assert.New(t)
+	es := &eventSource{source: config.Source{TimestampField: "timestamp"}}
+	var b batch
+
+	// 30 is blocked by 20 which is blocked by 10.  The report for 20 comes second.
+	reports := []xe.Event{
+		blockedReport(7, 30, 20, "twenty"),
+		blockedReport(7, 20, 10, "ten"),
+		blockedReport(7, 40, 20, "twenty"),
+		blockedReport(8, 30, 20, "twenty"), // a later pass after 10 finished
+	}
+	b.add(sink.Event{Name: "login", Payload: "{}"})
+	for _, r := range reports {
+		es.chains.add(r)
+		b.addBlocked(sink.Event{Name: "blocked_process_report", Payload: "{}"}, r)
+	}
+	b.mark("a.xel", 100)
+	es.setHeadBlockers(&b)
+
+	assert.Equal("{}", b.events[0].Payload)
+	expected := []struct {
+		head  int64
+		depth int
+		login string
+	}{
+		{10, 2, "ten"},
+		{10, 1, "ten"},
+		{10, 2, "ten"},
+		{20, 1, "twenty"},
+	}
+	for i, x := range expected {
+		payload := b.events[i+1].Payload
+		assert.Equal(x.head, gjson.Get(payload, "xe_head_blocker_spid").Int(), "report %d", i)
+		assert.Equal(int64(x.depth), gjson.Get(payload, "xe_head_blocker_depth").Int(), "report %d", i)
+		assert.Equal(x.login, gjson.Get(payload, "xe_head_blocker_login").String(), "report %d", i)
+		assert.Equal(x.login+"-app", gjson.Get(payload, "xe_head_blocker_app").String(), "report %d", i)
+	}
+}
+
+func TestHeadBlockerFiltered(t *testing.T) {
+	assert := assert.New(t)
+	rule, err := filter.New(filter.Exclude, "", map[string]interface{}{"xe_blocked_spid": 20})
+	if !assert.NoError(err) {
+		return
+	}
+	p := &Program{Filters: []filter.Rule{rule}}
+	es := &eventSource{info: &xe.SQLInfo{}, source: config.Source{TimestampField: "timestamp"}}
+	var b batch
+	ctx := context.Background()
+
+	// the filter drops the report for 20 but it still links 30 to 10
+	for _, r := range []xe.Event{blockedReport(7, 30, 20, "twenty"), blockedReport(7, 20, 10, "ten")} {
+		_, _, err = p.addEvent(ctx, es, &b, r, "a.xel", 100)
+		assert.NoError(err)
+	}
+	assert.Equal(1, len(b.events))
+	assert.True(es.chains.reading, "the batch waits for the rest of the reports")
+	_, _, err = p.addEvent(ctx, es, &b, xe.Event{"name": "login"}, "a.xel", 200)
+	assert.NoError(err)
+	assert.False(es.chains.reading)
+
+	es.setHeadBlockers(&b)
+	assert.Equal(int64(10), gjson.Get(b.events[0].Payload, "xe_head_blocker_spid").Int())
+	assert.Equal(int64(2), gjson.Get(b.events[0].Payload, "xe_head_blocker_depth").Int())
+	assert.Equal("ten", gjson.Get(b.events[0].Payload, "xe_head_blocker_login").String())
+}
+
+func TestHeadBlockerCycle(t *testing.T) {
+	assert := assert.New(t)
+	var bc blockingChains
+	bc.add(blockedReport(1, 10, 20, "twenty"))
+	bc.add(blockedReport(1, 20, 30, "thirty"))
+	bc.add(blockedReport(1, 30, 10, "ten"))
+	head, report, depth, ok := bc.headBlocker(blockedReport(1, 10, 20, "twenty"))
+	assert.True(ok)
+	assert.Equal(int64(30), head)
+	assert.Equal(2, depth)
+	assert.Equal("thirty", report.GetString("xe_blocking_login"))
+
+	_, _, _, ok = bc.headBlocker(blockedReport(2, 10, 20, "twenty"))
+	assert.False(ok, "no reports for this monitor loop")
+}
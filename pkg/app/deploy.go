@@ -0,0 +1,105 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Deploy creates, alters and starts the sessions in [[sessions.deploy]]
+// on each source that reads them.  If dryRun is true, it logs the changes
+// without making them.
+func (p *Program) Deploy(ctx context.Context, dryRun bool) error {
+	settings, err := p.getConfig()
+	if err != nil {
+		return errors.Wrap(err, "p.getconfig")
+	}
+	defer p.infos.closeAll()
+
+	if len(settings.Sessions.Deploy) == 0 {
+		log.Warn("deploy: no sessions in [[sessions.deploy]]")
+		return nil
+	}
+
+	failed := 0
+	for i, src := range settings.Sources {
+		if ctx.Err() != nil {
+			break
+		}
+		defs := deployFor(src, settings.Sessions.Deploy)
+		if len(defs) == 0 {
+			continue
+		}
+		contextLogger := log.WithFields(log.Fields{
+			"source": src.FQDN,
+		})
+		info, err := p.infos.get(i, src)
+		if err != nil {
+			contextLogger.Error(errors.Wrap(err, fmt.Sprintf("deploy: fqdn: %s", src.FQDN)))
+			failed++
+			continue
+		}
+		for _, d := range defs {
+			sessionLogger := contextLogger.WithFields(log.Fields{
+				"session": d.Name,
+			})
+			changes, err := deploySession(ctx, info, d, dryRun)
+			if err != nil {
+				sessionLogger.Error(fmt.Sprintf("deploy: %s: session: %s: %s", info.Server, d.Name, err))
+				failed++
+				continue
+			}
+			if changes == 0 {
+				sessionLogger.Infof("deploy: %s: session: %s: no changes", info.Server, d.Name)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("deploy: failures: %d", failed)
+	}
+	return nil
+}
+
+// deployFor returns the definitions for the sessions a source reads
+func deployFor(src config.Source, defs []xe.Definition) []xe.Definition {
+	found := make([]xe.Definition, 0)
+	for _, d := range defs {
+		for _, s := range src.Sessions {
+			if strings.EqualFold(s, d.Name) {
+				found = append(found, d)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// deploySession runs the statements to make a session on a source match
+// its definition.  It returns the number of statements.
+func deploySession(ctx context.Context, info *xe.SQLInfo, d xe.Definition, dryRun bool) (int, error) {
+	have, err := xe.GetDeployedSession(info.DB, info.Scope(), d.Name)
+	if err != nil {
+		return 0, errors.Wrap(err, "xe.getdeployedsession")
+	}
+	ddl, err := d.Plan(info.Scope(), have)
+	if err != nil {
+		return 0, errors.Wrap(err, "plan")
+	}
+	for _, stmt := range ddl {
+		if dryRun {
+			log.Infof("deploy: %s: session: %s: (dry run) %s", info.Server, d.Name, stmt)
+			continue
+		}
+		log.Infof("deploy: %s: session: %s: %s", info.Server, d.Name, stmt)
+		_, err = info.DB.ExecContext(ctx, stmt)
+		if err != nil {
+			return 0, errors.Wrap(err, "db.exec")
+		}
+	}
+	return len(ddl), nil
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/sink"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// health tracks each poll of the sources and sessions for /status
+type health struct {
+	sync.Mutex
+	ready   bool
+	sources map[string]*sourceHealth
+}
+
+type sourceHealth struct {
+	Name          string           `json:"name"`
+	Instance      string           `json:"instance,omitempty"`
+	LastPoll      *time.Time       `json:"last_poll,omitempty"`
+	LastSuccess   *time.Time       `json:"last_success,omitempty"`
+	LastError     string           `json:"last_error,omitempty"`
+	LastErrorTime *time.Time       `json:"last_error_time,omitempty"`
+	Rows          int64            `json:"rows"`
+	Sessions      []*sessionHealth `json:"sessions"`
+}
+
+type sessionHealth struct {
+	Class         string     `json:"class"`
+	Name          string     `json:"name"`
+	LastPoll      *time.Time `json:"last_poll,omitempty"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	Rows          int64      `json:"rows"`
+	LastRows      int        `json:"last_rows"`
+	File          string     `json:"file,omitempty"`
+	Offset        int64      `json:"offset,omitempty"`
+	Saved         *time.Time `json:"saved,omitempty"`
+
+	domain   string
+	instance string
+}
+
+// statusReport is the body of /status
+type statusReport struct {
+	Version string          `json:"version,omitempty"`
+	Started time.Time       `json:"started"`
+	Ready   bool            `json:"ready"`
+	Sources []*sourceHealth `json:"sources"`
+	Sinks   []sink.State    `json:"sinks"`
+}
+
+func (h *health) setReady(ready bool) {
+	h.Lock()
+	defer h.Unlock()
+	h.ready = ready
+}
+
+func (h *health) isReady() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.ready
+}
+
+// reset clears the sources so a reload starts over
+func (h *health) reset() {
+	h.Lock()
+	defer h.Unlock()
+	h.sources = nil
+}
+
+// source returns the health of a source.  The caller holds the lock.
+func (h *health) source(name string) *sourceHealth {
+	if h.sources == nil {
+		h.sources = make(map[string]*sourceHealth)
+	}
+	src, ok := h.sources[name]
+	if !ok {
+		src = &sourceHealth{Name: name, Sessions: make([]*sessionHealth, 0)}
+		h.sources[name] = src
+	}
+	return src
+}
+
+// poll records a poll of a source that started at start
+func (h *health) poll(name string, start time.Time, result Result, err error) {
+	h.Lock()
+	defer h.Unlock()
+	src := h.source(name)
+	src.LastPoll = &start
+	if result.Instance != "" {
+		src.Instance = result.Instance
+	}
+	src.Rows += int64(result.Rows)
+	if err != nil {
+		now := time.Now()
+		src.LastError = err.Error()
+		src.LastErrorTime = &now
+		return
+	}
+	src.LastSuccess = &start
+}
+
+// session records reading one session, audit, or other class of events
+// from a source.  The domain and instance find the state file.
+func (h *health) session(name, class, session, domain, instance string, start time.Time, rows int, err error) {
+	h.Lock()
+	defer h.Unlock()
+	src := h.source(name)
+	var ss *sessionHealth
+	for _, s := range src.Sessions {
+		if s.Class == class && s.Name == session {
+			ss = s
+			break
+		}
+	}
+	if ss == nil {
+		ss = &sessionHealth{Class: class, Name: session}
+		src.Sessions = append(src.Sessions, ss)
+	}
+	ss.domain = domain
+	if instance != "" {
+		ss.instance = instance
+	}
+	ss.LastPoll = &start
+	ss.LastRows = rows
+	ss.Rows += int64(rows)
+	if err != nil {
+		now := time.Now()
+		ss.LastError = err.Error()
+		ss.LastErrorTime = &now
+		return
+	}
+	ss.LastSuccess = &start
+}
+
+// report copies the sources sorted by name with the position of each
+// session from its state file
+func (h *health) report() []*sourceHealth {
+	h.Lock()
+	defer h.Unlock()
+	list := make([]*sourceHealth, 0, len(h.sources))
+	for _, src := range h.sources {
+		cp := *src
+		cp.Sessions = make([]*sessionHealth, 0, len(src.Sessions))
+		for _, s := range src.Sessions {
+			ss := *s
+			pos, ok := status.GetPosition(ss.domain, ss.instance, ss.Class, ss.Name)
+			if ok {
+				ss.File = pos.FileName
+				ss.Offset = pos.Offset
+				if !pos.Saved.IsZero() {
+					saved := pos.Saved
+					ss.Saved = &saved
+				}
+			}
+			cp.Sessions = append(cp.Sessions, &ss)
+		}
+		list = append(list, &cp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// healthz returns 200 while the process is running
+func (p *Program) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz returns 200 once the sinks are open and polling has started.
+// It returns 503 while the configuration is loading or stopping.
+func (p *Program) readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !p.health.isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("not ready\n"))
+		return
+	}
+	_, _ = w.Write([]byte("ready\n"))
+}
+
+// statusz returns the state of each source, session and sink as JSON
+func (p *Program) statusz(w http.ResponseWriter, r *http.Request) {
+	rpt := statusReport{
+		Version: p.Version,
+		Started: p.StartTime,
+		Ready:   p.health.isReady(),
+		Sources: p.health.report(),
+		Sinks:   sink.States(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(rpt)
+	if err != nil {
+		log.Error(errors.Wrap(err, "status.encode"))
+	}
+}
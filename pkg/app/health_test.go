@@ -0,0 +1,95 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	p := &Program{Version: "test"}
+
+	w := httptest.NewRecorder()
+	p.healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	p.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+
+	p.health.setReady(true)
+	w = httptest.NewRecorder()
+	p.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(http.StatusOK, w.Code)
+}
+
+func TestStatus(t *testing.T) {
+	assert := assert.New(t)
+	p := &Program{Version: "test"}
+	p.health.setReady(true)
+
+	sf, err := status.NewFile("health", "D40", status.ClassXE, "health_test")
+	assert.NoError(err)
+	_ = os.Remove(sf.Name)
+	defer func() {
+		_ = os.Remove(sf.Name)
+		_ = os.Remove(sf.Name + ".0")
+	}()
+	_, _, _, err = sf.GetOffset()
+	assert.NoError(err)
+	assert.NoError(sf.Done("health_0_1.xel", 4096, status.StateSuccess))
+
+	start := time.Now()
+	p.health.session("D40", status.ClassXE, "health_test", "health", "D40", start, 10, nil)
+	p.health.session("D40", status.ClassXE, "health_test", "health", "D40", start, 5, nil)
+	p.health.session("D40", status.ClassAgentJobs, "agent_jobs", "health", "D40", start, 0, errors.New("timeout"))
+	p.health.poll("D40", start, Result{Instance: "D40", Rows: 15}, errors.New("errors occurred - see previous"))
+	p.health.poll("B52", start, Result{Instance: "B52"}, nil)
+
+	w := httptest.NewRecorder()
+	p.statusz(w, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var rpt statusReport
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &rpt))
+	assert.True(rpt.Ready)
+	assert.Equal("test", rpt.Version)
+	if !assert.Equal(2, len(rpt.Sources)) {
+		return
+	}
+	assert.Equal("B52", rpt.Sources[0].Name)
+	assert.NotNil(rpt.Sources[0].LastSuccess)
+	assert.Empty(rpt.Sources[0].LastError)
+
+	src := rpt.Sources[1]
+	assert.Equal("D40", src.Name)
+	assert.Equal(int64(15), src.Rows)
+	assert.Nil(src.LastSuccess)
+	assert.NotNil(src.LastErrorTime)
+	if !assert.Equal(2, len(src.Sessions)) {
+		return
+	}
+	xe := src.Sessions[0]
+	assert.Equal("health_test", xe.Name)
+	assert.Equal(int64(15), xe.Rows)
+	assert.Equal(5, xe.LastRows)
+	assert.Equal("health_0_1.xel", xe.File)
+	assert.Equal(int64(4096), xe.Offset)
+	assert.NotNil(xe.Saved)
+	jobs := src.Sessions[1]
+	assert.Equal("timeout", jobs.LastError)
+	assert.Nil(jobs.LastSuccess)
+	assert.Empty(jobs.File)
+
+	p.health.reset()
+	assert.Equal(0, len(p.health.report()))
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/billgraziano/mssqlh"
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMetadataTTL is how long the SQL Server metadata for a source is
+// used before it is read again
+const DefaultMetadataTTL = time.Hour
+
+// infoCache keeps a connection and the metadata for each source between
+// polls.  A source is only polled by one worker at a time and the cache is
+// keyed by the position of the source so the SQLInfo for a source isn't
+// shared, even with an identical source.
+type infoCache struct {
+	sync.Mutex
+	ttl   time.Duration
+	infos map[string]*xe.SQLInfo // by source id and connection
+	open  func(src config.Source) (*xe.SQLInfo, error)
+}
+
+// infoKey identifies the connection for the source at position id
+func infoKey(id int, src config.Source) string {
+	return strconv.Itoa(id) + "\x00" + src.FQDN + "\x00" + connection(src).String() + "\x00" + src.ServerNameOverride + "\x00" + src.DomainNameOverride
+}
+
+// openInfo connects to the source and reads the metadata
+func openInfo(src config.Source) (*xe.SQLInfo, error) {
+	cxn := connection(src)
+	info, err := xe.NewSQLInfo(cxn.Driver, cxn.String(), src.ServerNameOverride, src.DomainNameOverride)
+	if err != nil {
+		if info.DB != nil {
+			closeErr := info.DB.Close()
+			if closeErr != nil {
+				log.Error(errors.Wrap(closeErr, "info.db.close"))
+			}
+		}
+		return nil, err
+	}
+	return &info, nil
+}
+
+// get returns the cached SQLInfo for the source at position id.  The metadata is read again
+// if it is older than the TTL or Parse found a database or field that
+// wasn't there.  A connection that fails a ping is replaced.
+func (c *infoCache) get(id int, src config.Source) (*xe.SQLInfo, error) {
+	key := infoKey(id, src)
+	c.Lock()
+	info, ok := c.infos[key]
+	c.Unlock()
+
+	if ok {
+		err := info.DB.Ping()
+		if err != nil {
+			log.Debug(errors.Wrapf(err, "source: %s: cached connection", src.FQDN))
+			c.drop(key, info)
+			ok = false
+		}
+	}
+
+	if !ok {
+		open := c.open
+		if open == nil {
+			open = openInfo
+		}
+		var err error
+		info, err = open(src)
+		if err != nil {
+			return nil, err
+		}
+		c.Lock()
+		if c.infos == nil {
+			c.infos = make(map[string]*xe.SQLInfo)
+		}
+		c.infos[key] = info
+		c.Unlock()
+		return info, nil
+	}
+
+	ttl := c.ttl
+	if ttl <= 0 {
+		ttl = DefaultMetadataTTL
+	}
+	if time.Since(info.LoadedAt) > ttl || info.Stale() {
+		log.Tracef("source: %s: reloading metadata (stale: %t)", src.FQDN, info.Stale())
+		err := info.Load()
+		if err != nil {
+			c.drop(key, info)
+			return nil, errors.Wrap(err, "info.load")
+		}
+	}
+	return info, nil
+}
+
+// drop closes a connection and removes it from the cache
+func (c *infoCache) drop(key string, info *xe.SQLInfo) {
+	c.Lock()
+	if c.infos[key] == info {
+		delete(c.infos, key)
+	}
+	c.Unlock()
+	if info.DB != nil {
+		err := info.DB.Close()
+		if err != nil {
+			log.Error(errors.Wrap(err, "infocache: db.close"))
+		}
+	}
+}
+
+// closeAll closes every cached connection
+func (c *infoCache) closeAll() {
+	c.Lock()
+	infos := c.infos
+	c.infos = nil
+	c.Unlock()
+	for _, info := range infos {
+		if info.DB != nil {
+			err := info.DB.Close()
+			if err != nil {
+				log.Error(errors.Wrap(err, "infocache: db.close"))
+			}
+		}
+	}
+}
+
+// connection returns the connection for a source.  This is master
+// unless a database is set for Azure SQL Database.
+func connection(src config.Source) mssqlh.Connection {
+	database := src.Database
+	if database == "" {
+		database = "master"
+	}
+	cxn := mssqlh.NewConnection(src.FQDN, src.User, src.Password, database, "sqlxewriter.exe")
+	if src.Driver != "" {
+		cxn.Driver = src.Driver
+	}
+	if src.ODBCDriver != "" {
+		cxn.ODBCDriver = src.ODBCDriver
+	}
+	return cxn
+}
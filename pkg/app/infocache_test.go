@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/stretchr/testify/assert"
+)
+
+// pingDriver opens connections that only answer a ping
+type pingDriver struct{ down atomic.Bool }
+
+type pingConn struct{ d *pingDriver }
+
+func (d *pingDriver) Open(string) (driver.Conn, error) { return &pingConn{d}, nil }
+func (c *pingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *pingConn) Close() error              { return nil }
+func (c *pingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+func (c *pingConn) Ping(context.Context) error {
+	if c.d.down.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+var testPingDriver = &pingDriver{}
+
+func init() {
+	sql.Register("infocache_test", testPingDriver)
+}
+
+func TestInfoCache(t *testing.T) {
+	assert := assert.New(t)
+	opened := 0
+	c := infoCache{
+		ttl: time.Hour,
+		open: func(src config.Source) (*xe.SQLInfo, error) {
+			opened++
+			db, err := sql.Open("infocache_test", src.FQDN)
+			if err != nil {
+				return nil, err
+			}
+			return &xe.SQLInfo{Server: src.FQDN, DB: db, LoadedAt: time.Now()}, nil
+		},
+	}
+	defer c.closeAll()
+
+	a := config.Source{FQDN: "a"}
+	b := config.Source{FQDN: "b"}
+	info1, err := c.get(0, a)
+	assert.NoError(err)
+	info2, err := c.get(0, a)
+	assert.NoError(err)
+	assert.Same(info1, info2)
+	assert.Equal(1, opened)
+
+	infoB, err := c.get(1, b)
+	assert.NoError(err)
+	assert.Equal("b", infoB.Server)
+	assert.Equal(2, opened)
+
+	// a connection that fails a ping is replaced
+	testPingDriver.down.Store(true)
+	_ = info1.DB.Close() // so the next ping opens a new driver connection
+	_, err = c.get(0, a)
+	testPingDriver.down.Store(false)
+	assert.NoError(err)
+	assert.Equal(3, opened)
+
+	c.closeAll()
+	assert.Equal(0, len(c.infos))
+}
+
+func TestAzureDatabasesOnOneServer(t *testing.T) {
+	assert := assert.New(t)
+	status.Reset()
+	defer status.Reset()
+	p := &Program{}
+	p.infos.open = func(src config.Source) (*xe.SQLInfo, error) {
+		db, err := sql.Open("infocache_test", src.FQDN)
+		if err != nil {
+			return nil, err
+		}
+		// every database on the logical server has the same @@SERVERNAME
+		return &xe.SQLInfo{Server: "azsql01", DB: db, LoadedAt: time.Now(), EngineEdition: xe.EngineAzureSQLDatabase}, nil
+	}
+	defer p.infos.closeAll()
+
+	sales := config.Source{FQDN: "azsql01.database.windows.net", Database: "Sales"}
+	hr := config.Source{FQDN: "azsql01.database.windows.net", Database: "HR"}
+	dupe, err := p.checkdupe(0, sales)
+	assert.NoError(err)
+	assert.False(dupe)
+	dupe, err = p.checkdupe(1, hr)
+	assert.NoError(err)
+	assert.False(dupe, "a second database on the server isn't a duplicate")
+	dupe, err = p.checkdupe(2, sales)
+	assert.NoError(err)
+	assert.True(dupe)
+
+	info, err := p.infos.get(0, sales)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("Sales_logins", stateID(info, sales, "logins"))
+	assert.Equal("HR_logins", stateID(info, hr, "logins"))
+
+	// a server keeps the session name
+	info.EngineEdition = 3
+	assert.Equal("logins", stateID(info, config.Source{FQDN: "d40", Database: "Sales"}, "logins"))
+	assert.Equal("azsql01", sourceInstance(info, sales))
+}
+
+func TestDuplicateSources(t *testing.T) {
+	assert := assert.New(t)
+	status.Reset()
+	defer status.Reset()
+	opened := 0
+	p := &Program{}
+	p.infos.open = func(src config.Source) (*xe.SQLInfo, error) {
+		opened++
+		db, err := sql.Open("infocache_test", src.FQDN)
+		if err != nil {
+			return nil, err
+		}
+		return &xe.SQLInfo{Server: "d40", DB: db, LoadedAt: time.Now()}, nil
+	}
+	defer p.infos.closeAll()
+
+	src := config.Source{FQDN: "d40.example.com"}
+	dupe, err := p.checkdupe(0, src)
+	assert.NoError(err)
+	assert.False(dupe)
+	first, err := p.infos.get(0, src)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// the identical source gets its own connection that is closed
+	dupe, err = p.checkdupe(1, src)
+	assert.NoError(err)
+	assert.True(dupe)
+	assert.Equal(2, opened)
+
+	// the first source keeps polling with its connection
+	assert.NoError(first.DB.Ping())
+	info, err := p.infos.get(0, src)
+	assert.NoError(err)
+	assert.Same(first, info)
+	assert.Equal(2, opened)
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollMetrics(t *testing.T) {
+	assert := assert.New(t)
+	p := &Program{}
+	labels := prometheus.Labels{"domain": "metrics", "server": "d40__sql2019", "session": "logins"}
+	start := time.Now()
+
+	p.polled("D40", status.ClassXE, "logins", "METRICS", "D40\\SQL2019", start, 25, nil)
+	assert.Equal(float64(start.UnixNano())/1e9, testutil.ToFloat64(prom.PollLastSuccess.With(labels)))
+	assert.Equal(0.0, testutil.ToFloat64(prom.PollErrors.With(labels)))
+
+	p.polled("D40", status.ClassXE, "logins", "METRICS", "D40\\SQL2019", time.Now(), 0, errors.New("timeout"))
+	assert.Equal(1.0, testutil.ToFloat64(prom.PollErrors.With(labels)))
+	assert.Equal(float64(start.UnixNano())/1e9, testutil.ToFloat64(prom.PollLastSuccess.With(labels)))
+
+	// a missing session isn't an error
+	p.polled("D40", status.ClassXE, "logins", "METRICS", "D40\\SQL2019", time.Now(), 0, errors.Wrap(xe.ErrNotFound, "session"))
+	assert.Equal(1.0, testutil.ToFloat64(prom.PollErrors.With(labels)))
+	assert.Equal(1, testutil.CollectAndCount(prom.PollDuration, "sqlxewriter_poll_duration_seconds"))
+}
+
+func TestObserveLag(t *testing.T) {
+	assert := assert.New(t)
+	ts := time.Now().Add(-90 * time.Second)
+	observeLag("LAG", "d40", "system_health", ts)
+	observeLag("LAG", "d40", "system_health", time.Time{})
+	assert.Equal(float64(ts.UnixNano())/1e9, testutil.ToFloat64(prom.EventLastTimestamp.With(prometheus.Labels{"domain": "lag", "server": "d40", "session": "system_health"})))
+
+	assert.Equal(1, testutil.CollectAndCount(prom.EventLag, "sqlxewriter_event_lag_seconds"))
+}
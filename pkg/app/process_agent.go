@@ -7,15 +7,15 @@ import (
 	"strings"
 	"time"
 
-	"github.com/billgraziano/xelogstash/pkg/dbx"
 	"github.com/billgraziano/xelogstash/pkg/prom"
 	"github.com/prometheus/client_golang/prometheus"
 
-	"github.com/billgraziano/mssqlh"
 	"github.com/billgraziano/xelogstash/pkg/config"
 	"github.com/billgraziano/xelogstash/pkg/logstash"
 	"github.com/billgraziano/xelogstash/pkg/metric"
+	"github.com/billgraziano/xelogstash/pkg/sink"
 	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -40,36 +40,15 @@ type jobResult struct {
 	Domain         string `json:"mssql_domain"`
 }
 
-func (p *Program) processAgentJobs(ctx context.Context, wid int, source config.Source) (result Result, err error) {
+func (p *Program) processAgentJobs(ctx context.Context, wid int, sqlinfo *xe.SQLInfo, source config.Source) (result Result, err error) {
 
 	result.Session = "agent_jobs"
 	result.Instance = source.FQDN // this will be reset later
 	result.Source = source
 	dummyFileName := "_dummy_"
 
-	cxn := mssqlh.NewConnection(source.FQDN, source.User, source.Password, "msdb", "sqlxewriter.exe")
-	if source.Driver != "" {
-		cxn.Driver = source.Driver
-	}
-	if source.ODBCDriver != "" {
-		cxn.ODBCDriver = source.ODBCDriver
-	}
-	db, err := dbx.Open(cxn.Driver, cxn.String())
-	if err != nil {
-		return result, errors.Wrap(err, "dbx.open")
-	}
-
-	err = db.Ping()
-	if err != nil {
-		return result, errors.Wrap(err, "db.ping")
-	}
-	defer safeClose(db, &err)
-
-	err = db.Ping()
-	if err != nil {
-		return result, errors.Wrap(err, "db.ping")
-	}
-	defer safeClose(db, &err)
+	// the msdb queries use three part names so the cached connection to master works
+	db := sqlinfo.DB
 
 	info, err := GetInstance(db, source.FQDN, source.ServerNameOverride, source.DomainNameOverride)
 	if err != nil {
@@ -309,12 +288,14 @@ func (p *Program) processAgentJobs(ctx context.Context, wid int, source config.S
 				return result, errors.Wrap(err, "logstash.processupperlower")
 			}
 
-			// Process all the destinations
+			// Process all the destinations and wait for them to confirm
+			// before the status is saved
+			events := []sink.Event{{Name: j.Name, Payload: rs, Time: j.TimestampUTC, Fields: base}}
 			for i := range p.Sinks {
 				snk := *p.Sinks[i]
-				_, err = snk.Write(ctx, j.Name, rs)
+				_, err = sink.Delivered(len(events), snk.WriteBatch(ctx, events))
 				if err != nil {
-					newError := errors.Wrap(err, fmt.Sprintf("sink.write: %s", snk.Name()))
+					newError := errors.Wrap(err, fmt.Sprintf("sink.writebatch: %s", snk.Name()))
 					log.Error(newError)
 					return result, newError
 				}
@@ -328,6 +309,7 @@ func (p *Program) processAgentJobs(ctx context.Context, wid int, source config.S
 			expvar.Get("app:eventsWritten").(metric.Metric).Add(1)
 			prom.EventsWritten.With(prometheus.Labels{"event": j.Name, "domain": strings.ToLower(info.Domain), "server": promServerLabel}).Inc()
 			prom.BytesWritten.With(prometheus.Labels{"event": j.Name, "domain": strings.ToLower(info.Domain), "server": promServerLabel}).Add(float64(len(rs)))
+			observeLag(info.Domain, promServerLabel, result.Session, events[0].Time)
 			eventCount.Add(j.Name, 1)
 			serverKey := fmt.Sprintf("%s-%s-%s", info.Domain, strings.Replace(info.Server, "\\", "-", -1), "agent_jobs")
 			serverCount.Add(serverKey, 1)
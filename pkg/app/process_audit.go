@@ -0,0 +1,315 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/logstash"
+	"github.com/billgraziano/xelogstash/pkg/metric"
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// auditEventName is the event name for every audit record
+const auditEventName = "audit"
+
+// auditRecord is one row from sys.fn_get_audit_file
+type auditRecord struct {
+	EventTime                   time.Time
+	SequenceNumber              int64
+	ActionID                    string
+	Succeeded                   bool
+	SessionID                   int64
+	ClassType                   string
+	SessionServerPrincipalName  string
+	ServerPrincipalName         string
+	DatabasePrincipalName       string
+	TargetServerPrincipalName   string
+	TargetDatabasePrincipalName string
+	ServerInstanceName          string
+	DatabaseName                string
+	SchemaName                  string
+	ObjectName                  string
+	Statement                   string
+	AdditionalInformation       string
+	FileName                    string
+	FileOffset                  int64
+}
+
+// getAuditWildCard returns the file pattern for a server audit with a file target
+func getAuditWildCard(db *sql.DB, audit string) (string, error) {
+	query := `
+		SELECT	[log_file_path]
+		FROM	[sys].[server_file_audits]
+		WHERE	[name] = ?`
+	var filePath string
+	err := db.QueryRow(query, audit).Scan(&filePath)
+	if err == sql.ErrNoRows {
+		return "", xe.ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "db.queryrow.scan")
+	}
+	// files are named <audit>_<guid>_<partition>_<timestamp>.sqlaudit
+	sep := "\\"
+	if strings.HasPrefix(filePath, "/") {
+		sep = "/"
+	}
+	if !strings.HasSuffix(filePath, sep) {
+		filePath += sep
+	}
+	return filePath + audit + "_*.sqlaudit", nil
+}
+
+// getAuditActions maps each action_id to its name
+func getAuditActions(db *sql.DB) (map[string]string, error) {
+	actions := make(map[string]string)
+	rows, err := db.Query("SELECT [action_id], MIN([name]) FROM [sys].[dm_audit_actions] GROUP BY [action_id];")
+	if err != nil {
+		return actions, errors.Wrap(err, "db.query")
+	}
+	defer rows.Close()
+	var id, name string
+	for rows.Next() {
+		err = rows.Scan(&id, &name)
+		if err != nil {
+			return actions, errors.Wrap(err, "rows.scan")
+		}
+		actions[strings.TrimSpace(id)] = name
+	}
+	return actions, errors.Wrap(rows.Err(), "rows.err")
+}
+
+// event converts an audit record to the same format as an XE event
+func (r auditRecord) event(info *xe.SQLInfo, audit string, actions map[string]string) xe.Event {
+	event := make(xe.Event)
+	actionID := strings.TrimSpace(r.ActionID)
+	actionName, ok := actions[actionID]
+	if !ok {
+		actionName = actionID
+	}
+	event.Set("name", auditEventName)
+	event.Set("timestamp", r.EventTime)
+	event.Set("audit_name", audit)
+	event.Set("sequence_number", r.SequenceNumber)
+	event.Set("action_id", actionID)
+	event.Set("action_name", actionName)
+	event.Set("succeeded", r.Succeeded)
+	event.Set("session_id", r.SessionID)
+	event.Set("class_type", strings.TrimSpace(r.ClassType))
+	event.Set("session_server_principal_name", r.SessionServerPrincipalName)
+	event.Set("server_principal_name", r.ServerPrincipalName)
+	event.Set("database_principal_name", r.DatabasePrincipalName)
+	event.Set("target_server_principal_name", r.TargetServerPrincipalName)
+	event.Set("target_database_principal_name", r.TargetDatabasePrincipalName)
+	event.Set("database_name", r.DatabaseName)
+	event.Set("schema_name", r.SchemaName)
+	event.Set("object_name", r.ObjectName)
+	event.Set("statement", r.Statement)
+	event.Set("additional_information", r.AdditionalInformation)
+	event.Set("file_name", r.FileName)
+	event.Set("audit_file_offset", r.FileOffset)
+
+	severity := logstash.Info
+	if !r.Succeeded {
+		severity = logstash.Warning
+	}
+	event.Set("xe_severity_value", severity)
+	event.Set("xe_severity_keyword", severity.String())
+	event.Set("xe_category", "audit")
+
+	desc := fmt.Sprintf("%s: %s", actionName, r.ServerPrincipalName)
+	object := strings.Trim(strings.Join([]string{r.DatabaseName, r.SchemaName, r.ObjectName}, "."), ".")
+	if object != "" {
+		desc += " " + object
+	}
+	if !r.Succeeded {
+		desc += " (failed)"
+	}
+	event.Set("xe_description", desc)
+
+	if info.Domain != "" {
+		event.Set("mssql_domain", info.Domain)
+	}
+	event.Set("mssql_computer", info.Computer)
+	event.Set("mssql_server_name", info.Server)
+	event.Set("mssql_version", info.Version)
+	event.Set("mssql_product_version", info.ProductVersion)
+	if r.ServerInstanceName != "" {
+		event.Set("server_instance_name", r.ServerInstanceName)
+	}
+	event.SetIfEmpty("server_instance_name", info.Server)
+	return event
+}
+
+// processAudit reads the files for a server audit.  The file name and
+// offset are saved the same way as an XE session.
+func (p *Program) processAudit(ctx context.Context, wid int, info *xe.SQLInfo, source config.Source, audit string) (result Result, err error) {
+	result.Session = audit
+	result.Source = source
+	result.Instance = info.Server
+
+	wildCard, err := getAuditWildCard(info.DB, audit)
+	if err != nil {
+		return result, errors.Wrap(err, "getauditwildcard")
+	}
+	actions, err := getAuditActions(info.DB)
+	if err != nil {
+		return result, errors.Wrap(err, "getauditactions")
+	}
+
+	sf, err := status.NewFile(info.Domain, result.Instance, status.ClassAudit, audit)
+	if err != nil {
+		return result, errors.Wrap(err, "status.newfile")
+	}
+	lastFileName, lastFileOffset, xestatus, err := sf.GetOffset()
+	if err != nil {
+		return result, errors.Wrap(err, "status.getoffset")
+	}
+	if xestatus == status.StateReset {
+		log.Error(fmt.Sprintf("[%d] *** Missing audit records in previous run from: [%s-%s-%s] starting at [%s-%d]", wid, info.Domain, result.Instance, audit, lastFileName, lastFileOffset))
+	}
+
+	query := `
+		SELECT	[event_time], [sequence_number], [action_id], [succeeded], [session_id], [class_type]
+			,COALESCE([session_server_principal_name], '')
+			,COALESCE([server_principal_name], '')
+			,COALESCE([database_principal_name], '')
+			,COALESCE([target_server_principal_name], '')
+			,COALESCE([target_database_principal_name], '')
+			,COALESCE([server_instance_name], '')
+			,COALESCE([database_name], '')
+			,COALESCE([schema_name], '')
+			,COALESCE([object_name], '')
+			,COALESCE([statement], '')
+			,COALESCE([additional_information], '')
+			,[file_name], [audit_file_offset]
+		FROM	sys.fn_get_audit_file(?, %s, %s) OPTION (RECOMPILE);`
+	var rows *sql.Rows
+	if (lastFileName == "" && lastFileOffset == 0) || xestatus == status.StateReset {
+		rows, err = info.DB.Query(fmt.Sprintf(query, "DEFAULT", "DEFAULT"), wildCard)
+	} else {
+		rows, err = info.DB.Query(fmt.Sprintf(query, "?", "?"), wildCard, lastFileName, lastFileOffset)
+	}
+	if err != nil {
+		if len(lastFileName) > 0 {
+			saveErr := sf.Done(lastFileName, lastFileOffset, status.StateReset)
+			if saveErr != nil {
+				log.Error(fmt.Sprintf("[%d] Error saving the status file: %v", wid, saveErr))
+			}
+		}
+		return result, errors.Wrap(err, "query")
+	}
+	defer safeClose(rows, &err)
+
+	es := eventSource{
+		wid:             wid,
+		info:            info,
+		source:          source,
+		session:         audit,
+		promServerLabel: prom.ServerLabel(info.Server),
+	}
+	var b batch
+	first := true
+	gotRows := false
+
+	for rows.Next() {
+		readCount.Add(1)
+		expvar.Get("app:eventsRead").(metric.Metric).Add(1)
+
+		var r auditRecord
+		err = rows.Scan(&r.EventTime, &r.SequenceNumber, &r.ActionID, &r.Succeeded, &r.SessionID, &r.ClassType,
+			&r.SessionServerPrincipalName, &r.ServerPrincipalName, &r.DatabasePrincipalName,
+			&r.TargetServerPrincipalName, &r.TargetDatabasePrincipalName, &r.ServerInstanceName,
+			&r.DatabaseName, &r.SchemaName, &r.ObjectName, &r.Statement, &r.AdditionalInformation,
+			&r.FileName, &r.FileOffset)
+		if err != nil {
+			return result, errors.Wrap(err, "rows.scan")
+		}
+		r.EventTime = r.EventTime.UTC()
+
+		// read past what we had after an error
+		if xestatus == status.StateReset {
+			if r.FileName < lastFileName {
+				continue
+			}
+			if r.FileName == lastFileName && r.FileOffset <= lastFileOffset {
+				continue
+			}
+		}
+
+		if first {
+			lastFileName = r.FileName
+			lastFileOffset = r.FileOffset
+			first = false
+		}
+		gotRows = true
+
+		// Did we just finish a file offset
+		if r.FileName != lastFileName || r.FileOffset != lastFileOffset {
+			b.mark(lastFileName, lastFileOffset)
+			done := (source.Rows > 0 && result.Rows >= source.Rows) || ctx.Err() != nil
+			if b.full(&es) || done {
+				err = p.commit(ctx, &es, &b, &sf)
+				if err != nil {
+					return result, err
+				}
+			}
+			if done {
+				break
+			}
+		}
+		lastFileName = r.FileName
+		lastFileOffset = r.FileOffset
+
+		var added, stop bool
+		added, stop, err = p.addEvent(ctx, &es, &b, r.event(info, audit, actions), r.FileName, r.FileOffset)
+		if err != nil {
+			return result, err
+		}
+		if stop {
+			break
+		}
+		if added {
+			result.Rows++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.Wrap(err, "rows.err")
+	}
+
+	if gotRows {
+		b.mark(lastFileName, lastFileOffset)
+		err = p.commit(ctx, &es, &b, &sf)
+		if err != nil {
+			return result, err
+		}
+
+		var lastError error
+		for i := range p.Sinks {
+			snk := *p.Sinks[i]
+			err = snk.Clean()
+			if err != nil {
+				lastError = errors.Wrapf(err, "sink.clean: %s", snk.Name())
+				log.Error(lastError)
+			}
+		}
+		if lastError != nil {
+			return result, lastError
+		}
+
+		err = sf.Done(lastFileName, lastFileOffset, status.StateSuccess)
+		if err != nil {
+			return result, errors.Wrap(err, "status.done")
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/filter"
+	"github.com/billgraziano/xelogstash/pkg/logstash"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditEvent(t *testing.T) {
+	assert := assert.New(t)
+	info := &xe.SQLInfo{Server: "D40\\SQL2019", Domain: "WORKGROUP", Computer: "D40"}
+	actions := map[string]string{"LGIF": "LOGIN FAILED", "SL": "SELECT"}
+	r := auditRecord{
+		EventTime:           time.Date(2024, 5, 1, 14, 2, 11, 0, time.UTC),
+		ActionID:            "SL  ",
+		Succeeded:           true,
+		ClassType:           "U ",
+		ServerPrincipalName: "DOMAIN\\user",
+		DatabaseName:        "Sales",
+		SchemaName:          "dbo",
+		ObjectName:          "Customer",
+		Statement:           "SELECT * FROM dbo.Customer",
+		FileName:            "C:\\Audit\\Sec_1.sqlaudit",
+		FileOffset:          5632,
+	}
+	event := r.event(info, "Sec", actions)
+	assert.Equal("audit", event.Name())
+	assert.Equal("SL", event["action_id"])
+	assert.Equal("SELECT", event["action_name"])
+	assert.Equal("U", event["class_type"])
+	assert.Equal("Sec", event["audit_name"])
+	assert.Equal(logstash.Info, event["xe_severity_value"])
+	assert.Equal("SELECT: DOMAIN\\user Sales.dbo.Customer", event["xe_description"])
+	assert.Equal("D40\\SQL2019", event["server_instance_name"])
+	assert.Equal(int64(5632), event["audit_file_offset"])
+
+	r = auditRecord{EventTime: r.EventTime, ActionID: "LGIF", ServerPrincipalName: "sa", ServerInstanceName: "D40\\SQL2019"}
+	event = r.event(info, "Sec", actions)
+	assert.Equal("LOGIN FAILED", event["action_name"])
+	assert.Equal(logstash.Warning, event["xe_severity_value"])
+	assert.Equal("LOGIN FAILED: sa (failed)", event["xe_description"])
+
+	// unknown actions keep the ID
+	r.ActionID = "ZZZZ"
+	event = r.event(info, "Sec", actions)
+	assert.Equal("ZZZZ", event["action_name"])
+
+	// audit records get the same adds and moves as XE events
+	es := eventSource{info: info, source: config.Source{
+		TimestampField: "@timestamp",
+		Adds:           map[string]string{"global.env": "prod"},
+		Moves:          map[string]string{"statement": "sql.statement"},
+	}}
+	rs, err := es.render(event)
+	assert.NoError(err)
+	var doc map[string]any
+	assert.NoError(json.Unmarshal([]byte(rs), &doc))
+	assert.Equal("2024-05-01T14:02:11Z", doc["@timestamp"])
+	assert.Equal(map[string]any{"env": "prod"}, doc["global"])
+	assert.Equal(map[string]any{"statement": ""}, doc["sql"])
+}
+
+func TestAuditFiltered(t *testing.T) {
+	assert := assert.New(t)
+	rule, err := filter.New(filter.Exclude, "", map[string]interface{}{"action_name": "SELECT"})
+	if !assert.NoError(err) {
+		return
+	}
+	p := &Program{Filters: []filter.Rule{rule}}
+	info := &xe.SQLInfo{Server: "D40\\SQL2019", Domain: "WORKGROUP"}
+	actions := map[string]string{"LGIF": "LOGIN FAILED", "SL": "SELECT"}
+	es := &eventSource{info: info, session: "Sec", source: config.Source{TimestampField: "timestamp", StopAt: config.DefaultStopAt}}
+	var b batch
+	ctx := context.Background()
+
+	eventTime := time.Date(2024, 5, 1, 14, 2, 11, 0, time.UTC)
+	selected := auditRecord{EventTime: eventTime, ActionID: "SL", Succeeded: true, FileName: "Sec_1.sqlaudit", FileOffset: 5632}
+	added, stop, err := p.addEvent(ctx, es, &b, selected.event(info, "Sec", actions), selected.FileName, selected.FileOffset)
+	assert.NoError(err)
+	assert.False(added, "the filter drops the audit record")
+	assert.False(stop)
+	assert.Equal(0, len(b.events))
+
+	failed := auditRecord{EventTime: eventTime, ActionID: "LGIF", ServerPrincipalName: "sa", FileName: "Sec_1.sqlaudit", FileOffset: 6144}
+	added, _, err = p.addEvent(ctx, es, &b, failed.event(info, "Sec", actions), failed.FileName, failed.FileOffset)
+	assert.NoError(err)
+	assert.True(added)
+	if !assert.Equal(1, len(b.events)) {
+		return
+	}
+	assert.Equal("audit", b.events[0].Name)
+	var doc map[string]any
+	assert.NoError(json.Unmarshal([]byte(b.events[0].Payload), &doc))
+	assert.Equal("Sec", doc["xe_session_name"])
+	assert.Equal("Sec_1.sqlaudit", doc["xe_file_name"])
+
+	// excluded_events applies to audit records too
+	es.source.ExcludedEvents = []string{"audit"}
+	added, _, err = p.addEvent(ctx, es, &b, failed.event(info, "Sec", actions), failed.FileName, failed.FileOffset)
+	assert.NoError(err)
+	assert.False(added)
+}
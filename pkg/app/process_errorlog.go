@@ -0,0 +1,250 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/metric"
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// errorLogSession names the error log in the state file and the events
+	errorLogSession = "errorlog"
+
+	// localTimeFormat is how server local times are saved and passed to SQL Server
+	localTimeFormat = "2006-01-02T15:04:05.000"
+)
+
+// serverUTCOffset returns how far the server local time is ahead of UTC
+func serverUTCOffset(db *sql.DB) (time.Duration, error) {
+	var minutes int64
+	err := db.QueryRow("SELECT DATEDIFF(MINUTE, GETUTCDATE(), GETDATE());").Scan(&minutes)
+	if err != nil {
+		return 0, errors.Wrap(err, "db.queryrow.scan")
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// errorLogArchiveChanged is true if the first archive was written after since.
+// That means the error log was cycled since the last poll.
+func errorLogArchiveChanged(db *sql.DB, since time.Time) (bool, error) {
+	rows, err := db.Query("EXEC sys.sp_enumerrorlogs;")
+	if err != nil {
+		return false, errors.Wrap(err, "db.query")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var archive int
+		var date string
+		var size int64
+		err = rows.Scan(&archive, &date, &size)
+		if err != nil {
+			return false, errors.Wrap(err, "rows.scan")
+		}
+		if archive != 1 {
+			continue
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "01/02/2006 15:04"} {
+			written, parseErr := time.Parse(layout, date)
+			if parseErr == nil {
+				return !written.Before(since.Truncate(time.Minute)), nil
+			}
+		}
+		// we can't tell so read it
+		return true, nil
+	}
+	return false, errors.Wrap(rows.Err(), "rows.err")
+}
+
+// processErrorLog reads the SQL Server error log with xp_readerrorlog.  Each line
+// becomes an errorlog_written event like the XE event on newer servers.  The last
+// log date and the number of lines at that date are saved in the state file.
+func (p *Program) processErrorLog(ctx context.Context, wid int, info *xe.SQLInfo, source config.Source) (result Result, err error) {
+	result.Session = errorLogSession
+	result.Source = source
+	result.Instance = info.Server
+
+	utcOffset, err := serverUTCOffset(info.DB)
+	if err != nil {
+		return result, errors.Wrap(err, "serverutcoffset")
+	}
+
+	sf, err := status.NewFile(info.Domain, result.Instance, status.ClassErrorLog, errorLogSession)
+	if err != nil {
+		return result, errors.Wrap(err, "status.newfile")
+	}
+	lastDate, lastCount, _, err := sf.GetOffset()
+	if err != nil {
+		return result, errors.Wrap(err, "status.getoffset")
+	}
+	var since time.Time
+	if lastDate != "" {
+		since, err = time.Parse(localTimeFormat, lastDate)
+		if err != nil {
+			return result, errors.Wrap(err, "status: log date")
+		}
+	}
+
+	// the end of the log we were reading is in the first archive after it cycles
+	archives := []int{0}
+	if !since.IsZero() {
+		var cycled bool
+		cycled, err = errorLogArchiveChanged(info.DB, since)
+		if err != nil {
+			return result, errors.Wrap(err, "errorlogarchivechanged")
+		}
+		if cycled {
+			archives = []int{1, 0}
+		}
+	}
+
+	es := eventSource{
+		wid:             wid,
+		info:            info,
+		source:          source,
+		session:         errorLogSession,
+		promServerLabel: prom.ServerLabel(info.Server),
+	}
+	var b batch
+	var pending []xe.ErrorLogLine
+	var pendingDate string
+	var pendingCount int64
+	var curDate string
+	var curCount int64
+	var markDate string
+	var markCount int64
+	done := false
+
+	// emit adds the pending lines as one event and marks where they end
+	emit := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		event := xe.NewErrorLogEvent(info, utcOffset, pending...)
+		pending = pending[:0]
+		added, stop, addErr := p.addEvent(ctx, &es, &b, event, "", 0)
+		if addErr != nil {
+			return addErr
+		}
+		if stop {
+			done = true
+			return nil
+		}
+		if added {
+			result.Rows++
+		}
+		b.mark(pendingDate, pendingCount)
+		markDate, markCount = pendingDate, pendingCount
+		if len(b.events) >= batchSize {
+			return p.commit(ctx, &es, &b, &sf)
+		}
+		return nil
+	}
+
+	for _, archive := range archives {
+		if done {
+			break
+		}
+		start := "NULL"
+		if !since.IsZero() {
+			start = "'" + since.Format(localTimeFormat) + "'"
+		}
+		var rows *sql.Rows
+		rows, err = info.DB.Query(fmt.Sprintf("EXEC master.dbo.xp_readerrorlog %d, 1, NULL, NULL, %s, NULL, N'asc';", archive, start))
+		if err != nil {
+			return result, errors.Wrapf(err, "xp_readerrorlog: %d", archive)
+		}
+
+		for rows.Next() {
+			var line xe.ErrorLogLine
+			err = rows.Scan(&line.LogDate, &line.ProcessInfo, &line.Text)
+			if err != nil {
+				_ = rows.Close()
+				return result, errors.Wrap(err, "rows.scan")
+			}
+			line.ProcessInfo = strings.TrimSpace(line.ProcessInfo)
+
+			// skip what we already read
+			if line.LogDate.Before(since) {
+				continue
+			}
+			lineDate := line.LogDate.Format(localTimeFormat)
+			if lineDate != curDate {
+				curDate = lineDate
+				curCount = 0
+			}
+			curCount++
+			if lineDate == lastDate && curCount <= lastCount {
+				continue
+			}
+
+			readCount.Add(1)
+			expvar.Get("app:eventsRead").(metric.Metric).Add(1)
+
+			if len(pending) > 0 && !pending[len(pending)-1].Continues(line) {
+				err = emit()
+				if err != nil {
+					_ = rows.Close()
+					return result, err
+				}
+			}
+			if done || (source.Rows > 0 && result.Rows >= source.Rows) || ctx.Err() != nil {
+				done = true
+				break
+			}
+			pending = append(pending, line)
+			pendingDate = curDate
+			pendingCount = curCount
+		}
+		err = rows.Err()
+		closeErr := rows.Close()
+		if err != nil {
+			return result, errors.Wrap(err, "rows.err")
+		}
+		if closeErr != nil {
+			return result, errors.Wrap(closeErr, "rows.close")
+		}
+	}
+	if !done {
+		err = emit()
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if len(b.checkpoints) > 0 {
+		err = p.commit(ctx, &es, &b, &sf)
+		if err != nil {
+			return result, err
+		}
+		var lastError error
+		for i := range p.Sinks {
+			snk := *p.Sinks[i]
+			err = snk.Clean()
+			if err != nil {
+				lastError = errors.Wrapf(err, "sink.clean: %s", snk.Name())
+				log.Error(lastError)
+			}
+		}
+		if lastError != nil {
+			return result, lastError
+		}
+	}
+	if markDate != "" {
+		err = sf.Done(markDate, markCount, status.StateSuccess)
+		if err != nil {
+			return result, errors.Wrap(err, "status.done")
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/filter"
+	"github.com/billgraziano/xelogstash/pkg/logstash"
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/billgraziano/xelogstash/pkg/sink"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var newlineRegex = regexp.MustCompile(`\r?\n`)
+
+// eventSource holds what we know about where a group of events came from
+type eventSource struct {
+	wid             int
+	info            *xe.SQLInfo
+	source          config.Source
+	session         string
+	promServerLabel string
+	startAtHit      bool
+	chains          blockingChains // blocked process reports read in this poll
+}
+
+// processEvent parses the XML for one event, applies the exclusions and filters,
+// and adds the JSON for the sinks to the batch.  It returns false if the event is
+// skipped and stop if the event is past the stop_at time.
+func (p *Program) processEvent(ctx context.Context, es *eventSource, b *batch, eventData, fileName string, fileOffset int64) (added bool, stop bool, err error) {
+	var event xe.Event
+	event, err = xe.Parse(es.info, eventData, p.BetaFeatures)
+	if err != nil {
+		log.Error(errors.Wrap(err, "xe.parse"))
+		if es.source.LogBadXML {
+			err = os.WriteFile("bad_xml.log", []byte(eventData), 0600)
+			if err != nil {
+				log.Error(errors.Wrap(err, "write bad xml: os.writefile"))
+			}
+		}
+		// count the error, fail if more than X?
+		return false, false, nil
+	}
+	return p.addEvent(ctx, es, b, event, fileName, fileOffset)
+}
+
+// addEvent applies the exclusions and filters to a parsed event and adds the
+// JSON for the sinks to the batch.  Sources without a file leave fileName empty.
+func (p *Program) addEvent(ctx context.Context, es *eventSource, b *batch, event xe.Event, fileName string, fileOffset int64) (added bool, stop bool, err error) {
+	eventName := event.Name()
+	es.chains.reading = eventName == "blocked_process_report"
+	prom.EventsRead.With(prometheus.Labels{"event": eventName, "domain": strings.ToLower(es.info.Domain), "server": es.promServerLabel}).Inc()
+
+	// is this an event we are skipping?
+	// TODO Lower this into the Parse function
+	if containsString(es.source.ExcludedEvents, eventName) {
+		return false, false, nil
+	}
+
+	// check date range
+	// If I move these inside the file rollover I may avoid skipping events. Ugh.
+	eventTime := event.Timestamp()
+	if eventTime.Before(es.source.StartAt) {
+		if !es.startAtHit {
+			log.Info(fmt.Sprintf("[%d] Source: %s (%s);  'Start At' skipped at least one event", es.wid, es.info.Server, es.session))
+			es.startAtHit = true
+		}
+		return false, false, nil
+	}
+	if eventTime.After(es.source.StopAt) {
+		log.Info(fmt.Sprintf("[%d] Source: %s (%s);  'Stop At' stopped processing", es.wid, es.info.Server, es.session))
+		return false, true, nil
+	}
+
+	// check for 17830 error
+	if es.source.Exclude17830 && eventName == "error_reported" {
+		errnum, ok := event.GetInt64("error_number")
+		if ok && errnum == 17830 {
+			return false, false, nil
+		}
+	}
+
+	// check if we can exclude a dbghelp.dll messages
+	if eventName == "errorlog_written" && !es.source.IncludeDebugDLLMsg {
+		logmsg := event.GetString("message")
+		if strings.Contains(strings.ToLower(logmsg), "using 'dbghelp.dll'") {
+			return false, false, nil
+		}
+	}
+
+	// add default columns
+	event.Set("xe_session_name", es.session)
+	if fileName != "" {
+		event.Set("xe_file_name", fileName)
+		event.Set("xe_file_offset", fileOffset)
+	}
+
+	// the chain needs every report even if the filters drop this one
+	if eventName == "blocked_process_report" {
+		es.chains.add(event)
+	}
+
+	// process the filters.  The last filter to match sets the action
+	if filter.Apply(p.Filters, event) == filter.Exclude {
+		return false, false, nil
+	}
+	var rs string
+	rs, err = es.render(event)
+	if err != nil {
+		return false, false, err
+	}
+
+	ev := sink.Event{Name: eventName, Payload: rs, Time: eventTime, Fields: event}
+	if eventName == "blocked_process_report" {
+		b.addBlocked(ev, event)
+	} else {
+		b.add(ev)
+	}
+	return true, false, nil
+}
+
+// render builds the JSON for the sinks from the event
+func (es *eventSource) render(event xe.Event) (string, error) {
+	lr := logstash.NewRecord()
+	// if payload field is empty, put at root
+	if es.source.PayloadField == "" {
+		for k, v := range event {
+			lr[k] = v
+		}
+	} else { // else put in a field
+		lr[es.source.PayloadField] = event
+		lr[es.source.TimestampField] = event["timestamp"]
+	}
+	// and don't forget timestamp
+	if es.source.TimestampField != "timestamp" && es.source.PayloadField == "" {
+		lr[es.source.TimestampField] = event["timestamp"]
+		delete(lr, "timestamp")
+	}
+
+	rs, err := lr.ToJSON()
+	if err != nil {
+		return "", errors.Wrap(err, "record.tojson")
+	}
+
+	// process the adds and such
+	rs, err = logstash.ProcessMods(rs, es.source.Adds, es.source.Copies, es.source.Moves)
+	if err != nil {
+		return "", errors.Wrap(err, "logstash.processmods")
+	}
+	rs, err = logstash.ProcessUpperLower(rs, es.source.UppercaseFields, es.source.LowercaseFields)
+	if err != nil {
+		return "", errors.Wrap(err, "logstash.processupperlower")
+	}
+
+	// strip newlines
+	if es.source.StripCRLF {
+		rs = newlineRegex.ReplaceAllString(rs, " ")
+	}
+	return rs, nil
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/metric"
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ringTarget is used as the file name for events read from a ring_buffer
+const ringTarget = "ring_buffer"
+
+// ringCheckpoints saves the events read from a ring buffer once they
+// are delivered.  The offset is the number of events delivered.
+type ringCheckpoints struct {
+	ring   *status.Ring
+	events []xe.RingEvent
+	hashes []string
+	saved  int
+}
+
+func (rc *ringCheckpoints) Save(_ string, offset int64, _ string) error {
+	for ; rc.saved < int(offset) && rc.saved < len(rc.events); rc.saved++ {
+		rc.ring.Add(rc.events[rc.saved].Timestamp, rc.hashes[rc.saved])
+	}
+	return rc.ring.Save()
+}
+
+// ringHash identifies an event in a ring buffer
+func ringHash(eventXML string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(eventXML))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// processRingBuffer reads a session that has a ring_buffer target but no event_file target.
+// The whole buffer is read each poll and the events that were already written are skipped.
+func (p *Program) processRingBuffer(ctx context.Context, wid int, info *xe.SQLInfo, source config.Source, result Result) (Result, error) {
+	targetData, err := xe.GetRingBuffer(info.DB, info.Scope(), result.Session)
+	if err != nil {
+		return result, errors.Wrap(err, "xe.getringbuffer")
+	}
+	events, err := xe.RingBufferEvents(targetData)
+	if err != nil {
+		return result, errors.Wrap(err, "xe.ringbufferevents")
+	}
+
+	ring, err := status.NewRing(info.Domain, result.Instance, stateID(info, source, result.Session))
+	if err != nil {
+		return result, errors.Wrap(err, "status.newring")
+	}
+	rc := ringCheckpoints{
+		ring:   &ring,
+		events: events,
+		hashes: make([]string, len(events)),
+	}
+
+	es := eventSource{
+		wid:             wid,
+		info:            info,
+		source:          source,
+		session:         result.Session,
+		promServerLabel: prom.ServerLabel(info.Server),
+	}
+	var b batch
+
+	for i, ev := range events {
+		if (source.Rows > 0 && result.Rows >= source.Rows) || ctx.Err() != nil {
+			break
+		}
+		rc.hashes[i] = ringHash(ev.XML)
+		if ring.Seen(ev.Timestamp, rc.hashes[i]) {
+			continue
+		}
+		readCount.Add(1)
+		expvar.Get("app:eventsRead").(metric.Metric).Add(1)
+
+		added, stop, err := p.processEvent(ctx, &es, &b, ev.XML, ringTarget, int64(i))
+		if err != nil {
+			return result, err
+		}
+		if stop {
+			break
+		}
+		if added {
+			result.Rows++
+		}
+		b.mark(ringTarget, int64(i+1))
+		if b.full(&es) {
+			err = p.commit(ctx, &es, &b, &rc)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if len(b.checkpoints) == 0 {
+		return result, nil
+	}
+	err = p.commit(ctx, &es, &b, &rc)
+	if err != nil {
+		return result, err
+	}
+
+	var lastError error
+	for i := range p.Sinks {
+		snk := *p.Sinks[i]
+		err = snk.Clean()
+		if err != nil {
+			lastError = errors.Wrapf(err, "sink.clean: %s", snk.Name())
+			log.Error(lastError)
+		}
+	}
+	return result, lastError
+}
@@ -4,27 +4,22 @@ import (
 	"context"
 	"expvar"
 	"fmt"
-	"os"
-	"regexp"
-	"strings"
 
 	mssql "github.com/microsoft/go-mssqldb"
 
 	"github.com/billgraziano/xelogstash/pkg/config"
-	"github.com/billgraziano/xelogstash/pkg/logstash"
 	"github.com/billgraziano/xelogstash/pkg/metric"
 	"github.com/billgraziano/xelogstash/pkg/prom"
 	"github.com/billgraziano/xelogstash/pkg/status"
 	"github.com/billgraziano/xelogstash/pkg/xe"
 	"github.com/pkg/errors"
-	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 func (p *Program) processSession(
 	ctx context.Context,
 	wid int,
-	info xe.SQLInfo,
+	info *xe.SQLInfo,
 	source config.Source,
 	sessionid int) (result Result, err error) {
 
@@ -42,8 +37,6 @@ func (p *Program) processSession(
 	var lastFileOffset int64
 	var xestatus string
 
-	newlineRegex := regexp.MustCompile(`\r?\n`)
-
 	result.Instance = info.Server
 
 	err = status.SwitchV2(wid, source.Prefix, info.Domain, info.Server, status.ClassXE, result.Session)
@@ -57,16 +50,19 @@ func (p *Program) processSession(
 	// 	return result, errors.Wrap(err, "dupe.check")
 	// }
 
-	if err = xe.ValidateSession(info.DB, result.Session); err != nil {
+	if err = xe.ValidateSession(info.DB, info.Scope(), result.Session); err != nil {
+		if errors.Cause(err) == xe.ErrNoFileTarget {
+			return p.processRingBuffer(ctx, wid, info, source, result)
+		}
 		return result, errors.Wrap(err, "validatesession")
 	}
 
-	session, err := xe.GetSession(info.DB, result.Session)
+	session, err := xe.GetSession(info.DB, info.Scope(), result.Session)
 	if err != nil {
 		return result, errors.Wrap(err, "xe.getsession")
 	}
 
-	sf, err := status.NewFile(info.Domain, result.Instance, status.ClassXE, result.Session)
+	sf, err := status.NewFile(info.Domain, result.Instance, status.ClassXE, stateID(info, source, result.Session))
 	if err != nil {
 		return result, errors.Wrap(err, "status.newfile")
 	}
@@ -110,9 +106,15 @@ func (p *Program) processSession(
 
 	first := true
 	gotRows := false
-	startAtHit := false
+	es := eventSource{
+		wid:             wid,
+		info:            info,
+		source:          source,
+		session:         result.Session,
+		promServerLabel: promServerLabel,
+	}
 
-	//var elasticBuffer bytes.Buffer
+	var b batch
 
 	for rows.Next() {
 		readCount.Add(1)
@@ -143,34 +145,17 @@ func (p *Program) processSession(
 
 		// Did we just finish a file offset
 		if fileName != lastFileName || fileOffset != lastFileOffset {
-			//if !source.Test {
-			err = sf.Save(lastFileName, lastFileOffset, status.StateSuccess)
-			if err != nil {
-				return result, errors.Wrap(err, "status.Save")
-			}
-			//}
-
-			// Flush all the sinks
-			for i := range p.Sinks {
-				snk := *p.Sinks[i]
-				err = snk.Flush()
+			b.mark(lastFileName, lastFileOffset)
+			done := (source.Rows > 0 && result.Rows >= source.Rows) || ctx.Err() != nil
+			if b.full(&es) || done {
+				err = p.commit(ctx, &es, &b, &sf)
 				if err != nil {
-					newError := errors.Wrap(err, fmt.Sprintf("sink.flush: %s", snk.Name()))
-					log.Error(newError)
-					return result, newError
+					return result, err
 				}
 			}
-
-			// do we have as many rows as we need?
-			if source.Rows > 0 && result.Rows >= source.Rows {
-				break
-			}
-
-			// do we have a cancel?
-			if ctx.Err() != nil {
+			if done {
 				break
 			}
-
 		}
 
 		lastFileName = fileName
@@ -178,160 +163,17 @@ func (p *Program) processSession(
 
 		first = false
 
-		var event xe.Event
-		event, err = xe.Parse(&info, eventData, p.BetaFeatures)
+		var added, stop bool
+		added, stop, err = p.processEvent(ctx, &es, &b, eventData, fileName, fileOffset)
 		if err != nil {
-			log.Error(errors.Wrap(err, "xe.parse"))
-			if source.LogBadXML {
-				err = os.WriteFile("bad_xml.log", []byte(eventData), 0600)
-				if err != nil {
-					log.Error(errors.Wrap(err, "write bad xml: os.writefile"))
-				}
-			}
-			// count the error, fail if more than X?
-			continue
+			return result, err
 		}
-
-		eventName := event.Name()
-		prom.EventsRead.With(prometheus.Labels{"event": eventName, "domain": strings.ToLower(info.Domain), "server": promServerLabel}).Inc()
-
-		// is this an event we are skipping?
-		// TODO Lower this into the Parse function
-		if containsString(source.ExcludedEvents, eventName) {
-			continue
-		}
-
-		// check date range
-		// If I move these inside the file rollover I may avoid skipping events. Ugh.
-		eventTime := event.Timestamp()
-		if eventTime.Before(source.StartAt) {
-			if !startAtHit {
-				log.Info(fmt.Sprintf("[%d] Source: %s (%s);  'Start At' skipped at least one event", wid, info.Server, session.Name))
-				startAtHit = true
-			}
-			continue
-		}
-		if eventTime.After(source.StopAt) {
-			log.Info(fmt.Sprintf("[%d] Source: %s (%s);  'Stop At' stopped processing", wid, info.Server, session.Name))
+		if stop {
 			break
 		}
-
-		// check for 17830 error
-		if source.Exclude17830 && eventName == "error_reported" {
-			errnum, ok := event.GetInt64("error_number")
-			if ok && errnum == 17830 {
-				continue
-			}
-		}
-
-		// check if we can exclude a dbghelp.dll messages
-		if eventName == "errorlog_written" && !source.IncludeDebugDLLMsg {
-			logmsg := event.GetString("message")
-			if strings.Contains(strings.ToLower(logmsg), "using 'dbghelp.dll'") {
-				continue
-			}
-		}
-
-		// add default columns
-		event.Set("xe_session_name", result.Session)
-		event.Set("xe_file_name", fileName)
-		event.Set("xe_file_offset", fileOffset)
-
-		// process the filters.  The last filter to match sets the action
-		action := "include"                   // default to include
-		for fnum, filter := range p.Filters { // loop through the filters
-			matched := true
-			fa, ok := filter["filter_action"]
-			if !ok {
-				return result, fmt.Errorf("filter #%d is missing 'filter_action'", fnum+1)
-			}
-			filterAction := fmt.Sprintf("%v", fa)
-			for filterField, filterValue := range filter { // loop through the filtered fields
-				if filterField == "filter_action" {
-					continue
-				}
-				eventValue, ok := event[filterField] // get the value for the field
-				if !ok {                             // if it doesn't exist, this filter can't match so break looping through fields
-					matched = false
-					break
-				}
-				if eventValue != filterValue { // this field doesn't match, next filter
-					matched = false
-					break
-				}
-			}
-			if matched {
-				action = filterAction
-			}
+		if added {
+			result.Rows++
 		}
-
-		if action == "exclude" {
-			continue
-		}
-
-		lr := logstash.NewRecord()
-		// if payload field is empty, put at root
-		if source.PayloadField == "" {
-			for k, v := range event {
-				lr[k] = v
-			}
-		} else { // else put in a field
-			lr[source.PayloadField] = event
-			lr[source.TimestampField] = event["timestamp"]
-		}
-		// and don't forget timestamp
-		if source.TimestampField != "timestamp" && source.PayloadField == "" {
-			lr[source.TimestampField] = event["timestamp"]
-			delete(lr, "timestamp")
-		}
-
-		var rs string
-		rs, err = lr.ToJSON()
-		if err != nil {
-			return result, errors.Wrap(err, "record.tojson")
-		}
-
-		// process the adds and such
-		rs, err = logstash.ProcessMods(rs, source.Adds, source.Copies, source.Moves)
-		if err != nil {
-			return result, errors.Wrap(err, "logstash.processmods")
-		}
-		rs, err = logstash.ProcessUpperLower(rs, source.UppercaseFields, source.LowercaseFields)
-		if err != nil {
-			return result, errors.Wrap(err, "logstash.processupperlower")
-		}
-
-		// strip newlines
-		if source.StripCRLF {
-			rs = newlineRegex.ReplaceAllString(rs, " ")
-		}
-
-		// Process all the destinations
-		// TODO Put all the events in an array
-		// TODO and write the array when the offset changes
-		// TODO and we save the state
-		for i := range p.Sinks {
-			snk := *p.Sinks[i]
-			_, err = snk.Write(ctx, eventName, rs)
-			if err != nil {
-				newError := errors.Wrap(err, fmt.Sprintf("sink.write: %s", snk.Name()))
-				log.Error(newError)
-				return result, newError
-			}
-		}
-
-		result.Rows++
-		totalCount.Add(1)
-		expvar.Get("app:eventsWritten").(metric.Metric).Add(1)
-		prom.EventsWritten.With(prometheus.Labels{"event": eventName, "domain": strings.ToLower(info.Domain), "server": promServerLabel}).Inc()
-		prom.BytesWritten.With(prometheus.Labels{"event": eventName, "domain": strings.ToLower(info.Domain), "server": promServerLabel}).Add(float64(len(rs)))
-
-		eventCount.Add(eventName, 1)
-		serverKey := fmt.Sprintf("%s-%s-%s", info.Domain, strings.Replace(info.Server, "\\", "-", -1), result.Session)
-		serverCount.Add(serverKey, 1)
-		// if appConfig.Summary {
-		// 	summary.Add(eventName, &rs)
-		// }
 	}
 
 	err = rows.Err()
@@ -350,6 +192,14 @@ func (p *Program) processSession(
 		// where language_id = 1033
 		// and message_id between 25717 and 25730
 		if sqlerr.Number >= 25717 && sqlerr.Number <= 25723 {
+			// deliver what we read so the reset doesn't skip past it
+			if gotRows {
+				b.mark(lastFileName, lastFileOffset)
+				commitErr := p.commit(ctx, &es, &b, &sf)
+				if commitErr != nil {
+					return result, commitErr
+				}
+			}
 			if len(lastFileName) > 0 {
 				saveErr := sf.Done(lastFileName, lastFileOffset, status.StateReset)
 				if saveErr != nil {
@@ -363,16 +213,15 @@ func (p *Program) processSession(
 	}
 
 	if gotRows /* && !source.Test */ {
+		b.mark(lastFileName, lastFileOffset)
+		err = p.commit(ctx, &es, &b, &sf)
+		if err != nil {
+			return result, err
+		}
 
-		// Process all the destinations
 		var lastError error
 		for i := range p.Sinks {
 			snk := *p.Sinks[i]
-			err = snk.Flush()
-			if err != nil {
-				lastError = errors.Wrapf(err, "sink.flush: %s", snk.Name())
-				log.Error(lastError)
-			}
 			err = snk.Clean()
 			if err != nil {
 				lastError = errors.Wrapf(err, "sink.clean: %s", snk.Name())
@@ -383,21 +232,10 @@ func (p *Program) processSession(
 			return result, lastError
 		}
 
-		err = sf.Save(lastFileName, lastFileOffset, status.StateSuccess)
-		if err != nil {
-			return result, errors.Wrap(err, "status.Save")
-		}
-
 		err = sf.Done(lastFileName, lastFileOffset, status.StateSuccess)
 		if err != nil {
 			return result, errors.Wrap(err, "status.done")
 		}
-
-		// write the elastic buffer & reset
-		// err = eshelper.WriteElasticBuffer(esclient, &elasticBuffer)
-		// if err != nil {
-		// 	return result, errors.Wrap(err, "writeelasticbuffer")
-		// }
 	}
 
 	return result, nil
@@ -3,20 +3,21 @@ package app
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
-	"github.com/billgraziano/mssqlh"
 	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/prom"
 	"github.com/billgraziano/xelogstash/pkg/status"
 	"github.com/billgraziano/xelogstash/pkg/xe"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 // ProcessSource handles the sessions and jobs for an instance
 func (p *Program) ProcessSource(ctx context.Context, wid int, source config.Source) (sourceResult Result, err error) {
-
 	contextLogger := log.WithFields(log.Fields{
 		"source": source.FQDN,
 	})
@@ -42,25 +43,29 @@ func (p *Program) ProcessSource(ctx context.Context, wid int, source config.Sour
 		contextLogger.Debugf("user: %s", source.User)
 	}
 
-	cxn := mssqlh.NewConnection(source.FQDN, source.User, source.Password, "master", "sqlxewriter.exe")
-	if source.Driver != "" {
-		cxn.Driver = source.Driver
-	}
-	if source.ODBCDriver != "" {
-		cxn.ODBCDriver = source.ODBCDriver
-	}
-	info, err := xe.NewSQLInfo(cxn.Driver, cxn.String(), source.ServerNameOverride, source.DomainNameOverride)
+	info, err := p.infos.get(wid, source)
 	if err != nil {
 		textMessage = fmt.Sprintf("source: %s err: %v", source.FQDN, err)
 		contextLogger.Error(textMessage)
 		return sourceResult, errors.Wrap(err, "xe.getsqlinfo")
 	}
 
-	defer safeClose(info.DB, &err)
 	contextLogger = contextLogger.WithFields(log.Fields{
 		"instance": info.Server,
 	})
 
+	// Azure SQL Database only has database scoped sessions.  There is no
+	// agent, server audit, error log, or default trace to read.
+	if info.Scope() == xe.DatabaseScope {
+		if source.AgentJobs == config.JobsAll || source.AgentJobs == config.JobsFailed || len(source.Audits) > 0 || source.ErrorLog || source.DefaultTrace {
+			contextLogger.Debug("azure sql database: skipping agent jobs, audits, error log and default trace")
+		}
+		source.AgentJobs = config.JobsNone
+		source.Audits = nil
+		source.ErrorLog = false
+		source.DefaultTrace = false
+	}
+
 	contextLogger.Tracef("%s: sys.messages for login_failed: %d\n", info.Server, len(info.LoginErrors))
 
 	cleanRun := true
@@ -76,6 +81,7 @@ func (p *Program) ProcessSource(ctx context.Context, wid int, source config.Sour
 
 		var result Result
 		result, err = p.processSession(ctx, wid, info, source, i)
+		p.polled(source.FQDN, status.ClassXE, source.Sessions[i], info.Domain, info.Server, start, result.Rows, err)
 		runtime := time.Since(start)
 		totalSeconds := runtime.Seconds()
 		totalMilliseconds := runtime.Milliseconds()
@@ -143,6 +149,47 @@ func (p *Program) ProcessSource(ctx context.Context, wid int, source config.Sour
 		return sourceResult, nil
 	}
 
+	// Process server audits
+	for _, audit := range source.Audits {
+		if ctx.Err() != nil {
+			break
+		}
+		start := time.Now()
+		var result Result
+		result, err = p.processAudit(ctx, wid, info, source, audit)
+		p.polled(source.FQDN, status.ClassAudit, audit, info.Domain, info.Server, start, result.Rows, err)
+		sourceResult.Rows += result.Rows
+		if !p.logResult(contextLogger, source, info, status.ClassAudit, audit, result, time.Since(start), err) {
+			cleanRun = false
+		}
+	}
+
+	// Process the error log and default trace for servers without XE
+	if source.ErrorLog && ctx.Err() == nil {
+		start := time.Now()
+		var result Result
+		result, err = p.processErrorLog(ctx, wid, info, source)
+		p.polled(source.FQDN, status.ClassErrorLog, errorLogSession, info.Domain, info.Server, start, result.Rows, err)
+		sourceResult.Rows += result.Rows
+		if !p.logResult(contextLogger, source, info, status.ClassErrorLog, errorLogSession, result, time.Since(start), err) {
+			cleanRun = false
+		}
+	}
+	if source.DefaultTrace && ctx.Err() == nil {
+		start := time.Now()
+		var result Result
+		result, err = p.processDefaultTrace(ctx, wid, info, source)
+		p.polled(source.FQDN, status.ClassTrace, traceSession, info.Domain, info.Server, start, result.Rows, err)
+		sourceResult.Rows += result.Rows
+		if !p.logResult(contextLogger, source, info, status.ClassTrace, traceSession, result, time.Since(start), err) {
+			cleanRun = false
+		}
+	}
+
+	if ctx.Err() != nil {
+		return sourceResult, nil
+	}
+
 	// Process Agent Jobs
 	if source.AgentJobs == config.JobsAll || source.AgentJobs == config.JobsFailed {
 		start := time.Now()
@@ -152,7 +199,8 @@ func (p *Program) ProcessSource(ctx context.Context, wid int, source config.Sour
 		})
 
 		var result Result
-		result, err = p.processAgentJobs(ctx, wid, source)
+		result, err = p.processAgentJobs(ctx, wid, info, source)
+		p.polled(source.FQDN, status.ClassAgentJobs, "agent_jobs", info.Domain, info.Server, start, result.Rows, err)
 		runtime := time.Since(start)
 		totalSeconds := runtime.Seconds()
 		totalMilliseconds := runtime.Milliseconds()
@@ -204,3 +252,48 @@ func (p *Program) ProcessSource(ctx context.Context, wid int, source config.Sour
 	}
 	return sourceResult, err
 }
+
+// logResult logs reading an audit, the error log, or the default trace.
+// It returns false if there was an error.  A missing audit or trace is
+// only a warning.
+func (p *Program) logResult(contextLogger *log.Entry, source config.Source, info *xe.SQLInfo, class, name string, result Result, runtime time.Duration, err error) bool {
+	logger := contextLogger.WithFields(log.Fields{
+		"session": name,
+	})
+	if errors.Cause(err) == xe.ErrNotFound || errors.Cause(err) == errNoDefaultTrace {
+		logger.Warn(fmt.Sprintf("source: %s (%s) - %s - %s : %s", source.FQDN, info.Domain, class, name, errors.Cause(err).Error()))
+		return true
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("source: %s (%s) - %s - %s : %s", source.FQDN, info.Domain, class, name, err.Error()))
+		return false
+	}
+	textMessage := fmt.Sprintf("%s (%s) %s: %s; events: %s", result.Instance, info.Domain, strings.ToLower(class), name, humanize.Comma(int64(result.Rows)))
+	entry := logger.WithFields(log.Fields{
+		"events":      result.Rows,
+		"duration_ms": runtime.Milliseconds(),
+	})
+	if p.Verbose {
+		entry.Info(textMessage)
+	} else {
+		entry.Debug(textMessage)
+	}
+	return true
+}
+
+// polled records reading a session for /status and the poll metrics.
+// A session that doesn't exist on the server isn't counted as an error.
+func (p *Program) polled(source, class, session, domain, instance string, start time.Time, rows int, err error) {
+	p.health.session(source, class, session, domain, instance, start, rows, err)
+	if errors.Cause(err) == xe.ErrNotFound {
+		return
+	}
+	labels := prometheus.Labels{"domain": strings.ToLower(domain), "server": prom.ServerLabel(instance), "session": session}
+	prom.PollDuration.With(labels).Observe(time.Since(start).Seconds())
+	if err != nil {
+		prom.PollErrors.With(labels).Inc()
+		return
+	}
+	prom.PollRows.With(labels).Observe(float64(rows))
+	prom.PollLastSuccess.With(labels).Set(float64(start.UnixNano()) / 1e9)
+}
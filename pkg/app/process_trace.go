@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"strings"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/metric"
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/billgraziano/xelogstash/pkg/status"
+	"github.com/billgraziano/xelogstash/pkg/xe"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// traceSession names the default trace in the state file and the events
+const traceSession = "default_trace"
+
+// errNoDefaultTrace is returned if the default trace isn't running
+var errNoDefaultTrace = errors.New("default trace not running")
+
+// getDefaultTrace returns the base file name and start time of the default trace.
+// Reading the base file name reads all the rollover files.
+func getDefaultTrace(db *sql.DB) (string, time.Time, error) {
+	var path string
+	var start time.Time
+	err := db.QueryRow("SELECT [path], [start_time] FROM sys.traces WHERE [is_default] = 1;").Scan(&path, &start)
+	if err == sql.ErrNoRows {
+		return "", start, errNoDefaultTrace
+	}
+	if err != nil {
+		return "", start, errors.Wrap(err, "db.queryrow.scan")
+	}
+	// ...\MSSQL\Log\log_123.trc becomes ...\MSSQL\Log\log.trc
+	if n := strings.LastIndexAny(path, `\/`); n >= 0 {
+		path = path[:n+1] + "log.trc"
+	}
+	return path, start, nil
+}
+
+// processDefaultTrace reads the default trace with sys.fn_trace_gettable.
+// The state file holds the start time of the trace and the last EventSequence.
+// The sequence starts over when SQL Server restarts so the rest of the
+// previous trace is read before the new one.
+func (p *Program) processDefaultTrace(ctx context.Context, wid int, info *xe.SQLInfo, source config.Source) (result Result, err error) {
+	result.Session = traceSession
+	result.Source = source
+	result.Instance = info.Server
+
+	path, traceStart, err := getDefaultTrace(info.DB)
+	if err != nil {
+		return result, errors.Wrap(err, "getdefaulttrace")
+	}
+	utcOffset, err := serverUTCOffset(info.DB)
+	if err != nil {
+		return result, errors.Wrap(err, "serverutcoffset")
+	}
+
+	sf, err := status.NewFile(info.Domain, result.Instance, status.ClassTrace, traceSession)
+	if err != nil {
+		return result, errors.Wrap(err, "status.newfile")
+	}
+	lastStart, lastSequence, _, err := sf.GetOffset()
+	if err != nil {
+		return result, errors.Wrap(err, "status.getoffset")
+	}
+	curStart := traceStart.Format(localTimeFormat)
+	curSequence := int64(0)
+	if lastStart == "" {
+		lastStart = curStart
+	}
+	if lastStart == curStart {
+		curSequence = lastSequence
+	}
+
+	query := `
+		SELECT	T.[EventClass], E.[name], COALESCE(V.[subclass_name], ''), T.[StartTime], T.[EventSequence]
+			,COALESCE(T.[SPID], 0)
+			,COALESCE(T.[DatabaseName], '')
+			,COALESCE(T.[ObjectName], '')
+			,COALESCE(T.[LoginName], '')
+			,COALESCE(T.[HostName], '')
+			,COALESCE(T.[ApplicationName], '')
+			,COALESCE(CAST(T.[TextData] AS NVARCHAR(MAX)), '')
+			,COALESCE(T.[FileName], '')
+			,COALESCE(T.[Error], 0)
+			,COALESCE(T.[Severity], 0)
+			,COALESCE(T.[Duration], 0)
+			,COALESCE(T.[IntegerData], 0)
+		FROM	sys.fn_trace_gettable(?, DEFAULT) T
+		JOIN	sys.trace_events E ON E.[trace_event_id] = T.[EventClass]
+		LEFT JOIN sys.trace_subclass_values V ON V.[trace_event_id] = T.[EventClass]
+						AND V.[trace_column_id] = 21
+						AND V.[subclass_value] = T.[EventSubClass]
+		WHERE	(T.[StartTime] >= ? AND T.[StartTime] < ? AND T.[EventSequence] > ?)
+		OR		(T.[StartTime] >= ? AND T.[EventSequence] > ?)
+		ORDER BY CASE WHEN T.[StartTime] < ? THEN 0 ELSE 1 END, T.[EventSequence]
+		OPTION (RECOMPILE);`
+	rows, err := info.DB.Query(query, path, lastStart, curStart, lastSequence, curStart, curSequence, curStart)
+	if err != nil {
+		return result, errors.Wrap(err, "query")
+	}
+	defer safeClose(rows, &err)
+
+	es := eventSource{
+		wid:             wid,
+		info:            info,
+		source:          source,
+		session:         traceSession,
+		promServerLabel: prom.ServerLabel(info.Server),
+	}
+	var b batch
+	var markStart string
+	var markSequence int64
+
+	for rows.Next() {
+		if (source.Rows > 0 && result.Rows >= source.Rows) || ctx.Err() != nil {
+			break
+		}
+		readCount.Add(1)
+		expvar.Get("app:eventsRead").(metric.Metric).Add(1)
+
+		var t xe.TraceEvent
+		err = rows.Scan(&t.EventClass, &t.EventName, &t.SubClassName, &t.StartTime, &t.EventSequence,
+			&t.SPID, &t.DatabaseName, &t.ObjectName, &t.LoginName, &t.HostName, &t.ApplicationName,
+			&t.TextData, &t.FileName, &t.Error, &t.Severity, &t.Duration, &t.IntegerData)
+		if err != nil {
+			return result, errors.Wrap(err, "rows.scan")
+		}
+
+		var added, stop bool
+		added, stop, err = p.addEvent(ctx, &es, &b, xe.NewTraceEvent(info, utcOffset, t), "", 0)
+		if err != nil {
+			return result, err
+		}
+		if stop {
+			break
+		}
+		if added {
+			result.Rows++
+		}
+
+		// events from the previous trace are saved with its start time
+		markStart = curStart
+		if t.StartTime.Before(traceStart) {
+			markStart = lastStart
+		}
+		markSequence = t.EventSequence
+		b.mark(markStart, markSequence)
+		if len(b.events) >= batchSize {
+			err = p.commit(ctx, &es, &b, &sf)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return result, errors.Wrap(err, "rows.err")
+	}
+
+	if markStart != "" {
+		err = p.commit(ctx, &es, &b, &sf)
+		if err != nil {
+			return result, err
+		}
+		var lastError error
+		for i := range p.Sinks {
+			snk := *p.Sinks[i]
+			err = snk.Clean()
+			if err != nil {
+				lastError = errors.Wrapf(err, "sink.clean: %s", snk.Name())
+				log.Error(lastError)
+			}
+		}
+		if lastError != nil {
+			return result, lastError
+		}
+		err = sf.Done(markStart, markSequence, status.StateSuccess)
+		if err != nil {
+			return result, errors.Wrap(err, "status.done")
+		}
+	}
+	return result, nil
+}
@@ -9,7 +9,6 @@ import (
 	"runtime"
 	"time"
 
-	"github.com/billgraziano/mssqlh"
 	"github.com/billgraziano/xelogstash/pkg/metric"
 	"github.com/billgraziano/xelogstash/pkg/sink"
 	"github.com/billgraziano/xelogstash/pkg/status"
@@ -36,6 +35,9 @@ func (p *Program) Start(svc service.Service) error {
 	ConfigureExpvar()
 	http.Handle("/debug/metrics", metric.Handler(metric.Exposed))
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", p.healthz)
+	http.HandleFunc("/readyz", p.readyz)
+	http.HandleFunc("/status", p.statusz)
 
 	err = p.startPolling()
 	if err != nil {
@@ -105,6 +107,7 @@ func (p *Program) startPolling() (err error) {
 		}
 	}
 
+	p.infos.ttl = settings.App.MetadataTTL.Duration
 	p.targets = len(settings.Sources)
 	log.Infof("sources: %d; default rows: %d", p.targets, settings.Defaults.Rows)
 
@@ -127,6 +130,8 @@ func (p *Program) startPolling() (err error) {
 		}
 	}
 
+	p.health.setReady(true)
+
 	if settings.App.Verbose {
 		log.Info("verbose: true")
 		p.Verbose = settings.App.Verbose
@@ -140,9 +145,32 @@ func (p *Program) startPolling() (err error) {
 	// launch the polling go routines
 	log.Tracef("loop: %t", p.Loop)
 	if p.Loop {
+		workers := settings.App.Workers
+		if workers <= 0 {
+			workers = runtime.NumCPU() * 4
+		}
+		log.Infof("workers: %d", workers)
+
+		// spread out the first poll of each source
+		sched := newScheduler(workers, func(ctx context.Context, j *job, lateness time.Duration) bool {
+			return p.poll(ctx, j, lateness, settings)
+		})
+		now := time.Now()
 		for i := 0; i < p.targets; i++ {
-			go p.run(ctx, i, settings)
+			src := settings.Sources[i]
+			delay := time.Duration(settings.Defaults.PollSeconds*1000*i/p.targets) * time.Millisecond
+			sched.add(&job{
+				id:       i,
+				name:     src.FQDN,
+				interval: time.Duration(src.PollSeconds) * time.Second,
+				due:      now.Add(delay),
+			})
 		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			sched.run(ctx)
+		}()
 
 		go func(ctx context.Context, count int) {
 			p.logMemory(ctx, count)
@@ -150,7 +178,8 @@ func (p *Program) startPolling() (err error) {
 
 	} else {
 		for i := 0; i < p.targets; i++ {
-			p.run(ctx, i, settings)
+			src := settings.Sources[i]
+			p.poll(ctx, &job{id: i, name: src.FQDN, due: time.Now()}, 0, settings)
 		}
 		writeMemory(p.StartTime, 1)
 	}
@@ -158,8 +187,11 @@ func (p *Program) startPolling() (err error) {
 	return nil
 }
 
-func (p *Program) run(ctx context.Context, id int, cfg config.Config) {
-
+// poll runs one poll of a source.  The first poll logs the settings and
+// checks that no other source reads the same instance.  It returns false
+// if the source shouldn't be polled again.
+func (p *Program) poll(ctx context.Context, j *job, lateness time.Duration, cfg config.Config) (again bool) {
+	again = true
 	defer func() {
 		err := recover()
 		if err != nil {
@@ -168,112 +200,82 @@ func (p *Program) run(ctx context.Context, id int, cfg config.Config) {
 			buf := make([]byte, 4096)
 			buf = buf[:runtime.Stack(buf, true)]
 			log.Error(fmt.Sprintf("%s\n", buf))
-			// result := writePanic(msg, buf)
-			// if result != nil {
-			// 	log.Error(errors.Wrap(result, "writepanic"))
-			// }
 		}
 	}()
 
 	// get the source
-	if id >= len(cfg.Sources) {
-		log.Errorf("poll exiting: id: %d len(sources): %d", id, len(cfg.Sources))
-		return
+	if j.id >= len(cfg.Sources) {
+		log.Errorf("poll exiting: id: %d len(sources): %d", j.id, len(cfg.Sources))
+		return false
 	}
-	src := cfg.Sources[id]
+	src := cfg.Sources[j.id]
 	contextLogger := log.WithFields(log.Fields{
 		"source": src.FQDN,
 	})
 
-	p.wg.Add(1)
-	defer p.wg.Done()
-
-	counter := 1
-	contextLogger.Tracef("poll routine launched: %v", service.Platform())
-
-	// sleep to spread out the launch (ms)
-	if p.Loop {
-		delay := cfg.Defaults.PollSeconds * 1000 * id / p.targets
-		if delay == 0 {
-			delay = id
+	if !j.checked {
+		if j.polls <= 1 {
+			if src.ServerNameOverride != "" || src.DomainNameOverride != "" {
+				logmsg := fmt.Sprintf("%s: source_name_override: '%s' domain_name_override: '%s'", src.FQDN, src.ServerNameOverride, src.DomainNameOverride)
+				contextLogger.Info(logmsg)
+			}
+			contextLogger.Info(fmt.Sprintf("%s: polling interval: %ds", src.FQDN, src.PollSeconds))
 		}
 
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(time.Duration(delay) * time.Millisecond):
-			break
+		dupe, err := p.checkdupe(j.id, src)
+		if err != nil {
+			// the server could be down or entered incorrectly
+			// so we keep trying each poll
+			contextLogger.Error(err)
+			p.health.poll(src.FQDN, time.Now(), Result{}, err)
+			return true
 		}
+		if dupe {
+			return false
+		}
+		j.checked = true
 	}
 
-	if src.ServerNameOverride != "" || src.DomainNameOverride != "" {
-		logmsg := fmt.Sprintf("%s: source_name_override: '%s' domain_name_override: '%s'", src.FQDN, src.ServerNameOverride, src.DomainNameOverride)
-		contextLogger.Info(logmsg)
-	}
-
-	logmsg := fmt.Sprintf("%s: polling interval: %ds", src.FQDN, src.PollSeconds)
-	contextLogger.Info(logmsg)
-
-	// ok is false if duplicate or context times out
-	ok := p.checkdupes(ctx, src)
-	if !ok {
-		return
+	contextLogger.Tracef("source: %s; polling (#%d); late: %s", src.FQDN, j.polls, lateness)
+	if j.interval > 0 && lateness > j.interval {
+		contextLogger.Warnf("source: %s; poll started %s late", src.FQDN, lateness.Round(time.Millisecond))
 	}
+	start := time.Now()
+	result, err := p.ProcessSource(ctx, j.id, src)
+	p.health.poll(src.FQDN, start, result, err)
+	if err != nil {
+		errmsg := ""
+		if result.Instance != "" {
+			errmsg += fmt.Sprintf("instance: %s;", result.Instance)
+		} else {
+			errmsg += fmt.Sprintf("fqdn: %s;", src.FQDN)
+		}
 
-	ticker := time.NewTicker(time.Duration(src.PollSeconds) * time.Second)
-	for {
-		// run at time zero
-		contextLogger.Tracef("source: %s; polling (#%d)...", src.FQDN, counter)
-		result, err := p.ProcessSource(ctx, id, src)
-		if err != nil {
-			errmsg := ""
-			if result.Instance != "" {
-				errmsg += fmt.Sprintf("instance: %s;", result.Instance)
-			} else {
-				errmsg += fmt.Sprintf("fqdn: %s;", src.FQDN)
-			}
-
-			if result.Session != "" {
-				if errmsg != "" {
-					errmsg += " "
-				}
-				errmsg += fmt.Sprintf("session: %s;", result.Session)
-			}
+		if result.Session != "" {
 			if errmsg != "" {
 				errmsg += " "
 			}
-			errmsg += fmt.Sprintf("err: %s", err)
-			if errors.Cause(err) == xe.ErrNotFound || errors.Cause(err) == xe.ErrNoFileTarget {
-				if cfg.App.StrictSessions {
-					contextLogger.Error(errmsg)
-				}
-			} else {
-				contextLogger.Error(errmsg)
-			}
+			errmsg += fmt.Sprintf("session: %s;", result.Session)
 		}
-
-		if !p.Loop {
-			contextLogger.Tracef("source: %s; one poll finished", src.FQDN)
-			return
+		if errmsg != "" {
+			errmsg += " "
 		}
-
-		select {
-		case <-ticker.C:
-			counter++
-			continue
-		case <-ctx.Done():
-			//log.Debugf("[%d] ctx.pause at %v...", id, time.Now())
-			ticker.Stop()
-
-			// simulate a slow stop
-			if p.ExtraDelay > 0 {
-				// #nosec G404
-				<-time.After(time.Millisecond * time.Duration(rand.Intn(p.ExtraDelay*1000)))
+		errmsg += fmt.Sprintf("err: %s", err)
+		if errors.Cause(err) == xe.ErrNotFound || errors.Cause(err) == xe.ErrNoFileTarget {
+			if cfg.App.StrictSessions {
+				contextLogger.Error(errmsg)
 			}
-			contextLogger.Debugf("source: %s 'program.run' received ctx.done", src.FQDN)
-			return
+		} else {
+			contextLogger.Error(errmsg)
 		}
 	}
+
+	// simulate a slow stop
+	if ctx.Err() != nil && p.ExtraDelay > 0 {
+		// #nosec G404
+		<-time.After(time.Millisecond * time.Duration(rand.Intn(p.ExtraDelay*1000)))
+	}
+	return true
 }
 
 // stopPolling stops all polling and closes all sinks
@@ -281,9 +283,11 @@ func (p *Program) stopPolling() (err error) {
 	p.Lock()
 	defer p.Unlock()
 
+	p.health.setReady(false)
 	log.Debug("sending cancel to pollers...")
 	p.Cancel()
 	p.wg.Wait()
+	p.infos.closeAll()
 
 	badClose := false
 	log.Trace("closing sinks...")
@@ -314,6 +318,8 @@ func (p *Program) stopPolling() (err error) {
 
 	// clean out the map of servers so we can restart
 	status.Reset()
+	sink.ResetStates()
+	p.health.reset()
 
 	return nil
 }
@@ -330,52 +336,39 @@ func (p *Program) Stop(s service.Service) error {
 	return nil
 }
 
-func (p *Program) checkdupes(ctx context.Context, src config.Source) bool {
-	for {
-		// TODO need a version of this with context
-		cxn := mssqlh.NewConnection(src.FQDN, src.User, src.Password, "master", "sqlxewriter.exe")
-		if src.Driver != "" {
-			cxn.Driver = src.Driver
-		}
-		if src.ODBCDriver != "" {
-			cxn.ODBCDriver = src.ODBCDriver
-		}
-		info, err := xe.NewSQLInfo(cxn.Driver, cxn.String(), src.ServerNameOverride, src.DomainNameOverride)
-		if err != nil {
-			// if there was an error the server could be down
-			// or entered incorrectly
-			// we just keep logging the error
-			err = errors.Wrap(err, fmt.Sprintf("checkdupes: fqdn: %s", src.FQDN))
-			log.Error(err)
-			if info.DB != nil {
-				err := info.DB.Close()
-				if err != nil {
-					log.Error(errors.Wrap(err, fmt.Sprintf("checkdupes: close: fqdn: %s", src.FQDN)))
-				}
-			}
-		} else { // we connected and got info
-			err = info.DB.Close()
-			if err != nil {
-				log.Error(errors.Wrap(err, fmt.Sprintf("checkdupes: close: fqdn: %s", src.FQDN)))
-			}
-			err = status.CheckDupeInstance(info.Domain, info.Server)
-			if err == nil {
-				return true // no dupe
-			}
-			log.Error(errors.Wrap(err, fmt.Sprintf("skipping duplicate: fqdn: '%s'; domain: '%s'; server: '%s'", src.FQDN, info.Domain, info.Server)))
-			return false
-		}
+// checkdupe connects to the source and checks whether another source
+// already reads the same instance.  An error means it couldn't connect.
+func (p *Program) checkdupe(id int, src config.Source) (dupe bool, err error) {
+	info, err := p.infos.get(id, src)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("checkdupes: fqdn: %s", src.FQDN))
+	}
+	err = status.CheckDupeInstance(info.Domain, sourceInstance(info, src))
+	if err != nil {
+		log.Error(errors.Wrap(err, fmt.Sprintf("skipping duplicate: fqdn: '%s'; domain: '%s'; server: '%s'", src.FQDN, info.Domain, info.Server)))
+		p.infos.drop(infoKey(id, src), info)
+		return true, nil
+	}
+	return false, nil
+}
 
-		// we just keep checking in case the server was down
-		ticker := time.NewTicker(time.Duration(src.PollSeconds) * time.Second)
-		select {
-		case <-ticker.C:
-			continue
-		case <-ctx.Done():
-			ticker.Stop()
-			return false
-		}
+// sourceInstance is the instance a source reads.  Every Azure SQL Database
+// on a logical server has the same @@SERVERNAME so the database is added.
+func sourceInstance(info *xe.SQLInfo, src config.Source) string {
+	if info.Scope() == xe.DatabaseScope && src.Database != "" {
+		return info.Server + "/" + src.Database
+	}
+	return info.Server
+}
+
+// stateID is the id of the state file for a session.  Databases on the
+// same logical server can have sessions with the same name so the
+// database is added.
+func stateID(info *xe.SQLInfo, src config.Source, session string) string {
+	if info.Scope() == xe.DatabaseScope && src.Database != "" {
+		return src.Database + "_" + session
 	}
+	return session
 }
 
 func (p *Program) enableHTTP(port int) error {
@@ -385,6 +378,7 @@ func (p *Program) enableHTTP(port int) error {
 	log.Infof("expvars at http://localhost:%d/debug/vars", port)
 	log.Infof("metric graphs at http://localhost:%d/debug/metrics", port)
 	log.Infof("prometheus metrics at http://localhost:%d/metrics", port)
+	log.Infof("status at http://localhost:%d/status", port)
 
 	p.Server = &http.Server{
 		Addr:    addr,
@@ -35,7 +35,7 @@ func (p *Program) getConfig() (config.Config, error) {
 	if err != nil {
 		return c, errors.Wrap(err, "config.get")
 	}
-	p.Filters = c.Filters
+	p.Filters = c.Rules
 	return c, nil
 }
 
@@ -6,7 +6,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/billgraziano/xelogstash/pkg/config"
+	"github.com/billgraziano/xelogstash/pkg/filter"
 
 	"github.com/billgraziano/xelogstash/pkg/sink"
 	log "github.com/sirupsen/logrus"
@@ -45,7 +45,13 @@ type Program struct {
 
 	Sinks []*sink.Sinker
 
-	Filters []config.Filter
+	// connections and metadata for each source
+	infos infoCache
+
+	Filters []filter.Rule
+
+	// the last poll of each source and session for /status
+	health health
 
 	BetaFeatures bool // Enable beta features for testing
 }
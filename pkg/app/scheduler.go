@@ -0,0 +1,160 @@
+package app
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/prom"
+)
+
+// job is a source waiting for its next poll
+type job struct {
+	id       int // index into the sources
+	name     string
+	interval time.Duration
+	due      time.Time
+	polls    int  // number of polls started
+	checked  bool // the source passed the duplicate check
+	index    int  // position in the queue
+}
+
+// jobQueue is a priority queue of jobs ordered by when they are due
+type jobQueue []*job
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	j.index = -1
+	*q = old[:n-1]
+	return j
+}
+
+// pollFunc runs one poll of a job.  It returns false if the job
+// shouldn't be polled again.
+type pollFunc func(ctx context.Context, j *job, lateness time.Duration) bool
+
+// scheduler polls jobs when they are due using at most workers at a time
+type scheduler struct {
+	workers int
+	poll    pollFunc
+
+	mu    sync.Mutex
+	queue jobQueue
+	wake  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newScheduler(workers int, poll pollFunc) *scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &scheduler{
+		workers: workers,
+		poll:    poll,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// add queues a job
+func (s *scheduler) add(j *job) {
+	s.mu.Lock()
+	heap.Push(&s.queue, j)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the job that is due first
+func (s *scheduler) next() *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return nil
+	}
+	return s.queue[0]
+}
+
+// run starts jobs as they come due until the context is cancelled.
+// It waits for running polls to finish before it returns.
+func (s *scheduler) run(ctx context.Context) {
+	defer s.wg.Wait()
+	slots := make(chan struct{}, s.workers)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		j := s.next()
+		wait := time.Hour
+		if j != nil {
+			wait = time.Until(j.due)
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			case <-timer.C:
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			continue
+		}
+
+		// wait for a free worker
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+
+		s.mu.Lock()
+		heap.Remove(&s.queue, j.index)
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func(j *job) {
+			defer s.wg.Done()
+			defer func() { <-slots }()
+
+			started := time.Now()
+			lateness := started.Sub(j.due)
+			prom.PollLateness.Observe(lateness.Seconds())
+			j.polls++
+			if !s.poll(ctx, j, lateness) || ctx.Err() != nil {
+				return
+			}
+
+			// a poll that runs long is due again right away
+			// but missed polls aren't made up
+			j.due = j.due.Add(j.interval)
+			if now := time.Now(); j.due.Before(now) {
+				j.due = now
+			}
+			s.add(j)
+		}(j)
+	}
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerWorkers(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	running, most := 0, 0
+	polls := make(map[int]int)
+
+	s := newScheduler(2, func(ctx context.Context, j *job, lateness time.Duration) bool {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		polls[j.id]++
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return j.id != 4 // job 4 only runs once
+	})
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		s.add(&job{id: i, interval: 40 * time.Millisecond, due: now})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s.run(ctx)
+
+	assert.Equal(2, most)
+	assert.Equal(1, polls[4])
+	for i := 0; i < 4; i++ {
+		assert.GreaterOrEqual(polls[i], 3, "job %d", i)
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	assert := assert.New(t)
+	order := make([]int, 0)
+	late := make(map[int]time.Duration)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newScheduler(1, func(ctx context.Context, j *job, lateness time.Duration) bool {
+		order = append(order, j.id)
+		late[j.id] = lateness
+		if len(order) == 3 {
+			cancel()
+		}
+		return false
+	})
+	now := time.Now()
+	s.add(&job{id: 1, due: now.Add(30 * time.Millisecond)})
+	s.add(&job{id: 2, due: now.Add(10 * time.Millisecond)})
+	s.add(&job{id: 3, due: now.Add(-time.Second)})
+	s.run(ctx)
+
+	assert.Equal([]int{3, 2, 1}, order)
+	assert.GreaterOrEqual(late[3], time.Second)
+	assert.Less(late[2], time.Second)
+}
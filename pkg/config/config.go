@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/billgraziano/xelogstash/pkg/filter"
 	"github.com/billgraziano/xelogstash/pkg/sink"
 	"github.com/billgraziano/xelogstash/pkg/sink/sampler"
 
@@ -98,12 +99,27 @@ func Get(f, src, version, sha1ver string) (config Config, err error) {
 		}
 	}
 
-	// make sure each filter has a filter action field
+	// compile the filters once
+	config.Rules = make([]filter.Rule, 0, len(config.Filters))
 	for i, f := range config.Filters {
-		_, ok := f["filter_action"]
-		if !ok {
-			return config, fmt.Errorf("filter #%d missing 'filter_action'", i+1)
+		r, err := f.Rule()
+		if err != nil {
+			return config, errors.Wrapf(err, "filter #%d", i+1)
+		}
+		config.Rules = append(config.Rules, r)
+	}
+
+	names := make(map[string]bool)
+	for i := range config.Sessions.Deploy {
+		d := &config.Sessions.Deploy[i]
+		err = d.Validate()
+		if err != nil {
+			return config, errors.Wrap(err, "sessions.deploy")
+		}
+		if names[strings.ToLower(d.Name)] {
+			return config, fmt.Errorf("sessions.deploy: duplicate session: %s", d.Name)
 		}
+		names[strings.ToLower(d.Name)] = true
 	}
 
 	err = config.Defaults.validate()
@@ -132,76 +148,91 @@ func Get(f, src, version, sha1ver string) (config Config, err error) {
 
 	// Set FileSink defaults
 	if config.FileSink != nil {
-		if config.FileSink.RetainHours == 0 {
-			config.FileSink.RetainHours = 168 // 7 days
+		err = config.FileSink.setup("events")
+		if err != nil {
+			return config, err
 		}
-
-		if config.FileSink.Directory == "" {
-			var executable string
-			executable, err = os.Executable()
-			if err != nil {
-				return config, errors.Wrap(err, "os.executable")
-			}
-			exeDir := filepath.Dir(executable)
-			config.FileSink.Directory = filepath.Join(exeDir, "events")
+		config.rot = config.FileSink.rot
+	}
+	for i := range config.Sink.FileSink {
+		fs := &config.Sink.FileSink[i]
+		err = fs.setup(filepath.Join("events", fs.Name))
+		if err != nil {
+			return config, err
 		}
-
-		rot := sink.NewRotator(config.FileSink.Directory, "sqlevents", "events")
-		rot.Retention = time.Duration(config.FileSink.RetainHours) * time.Hour
-		rot.Hourly = true
-		config.rot = rot
 	}
 
 	return config, err
 }
 
-// CloseRotator closes the rotator stored in a Config
+// CloseRotator closes the rotators for the file sinks
 func (c *Config) CloseRotator() error {
-	if c.rot == nil {
-		return nil
+	var err error
+	if c.rot != nil {
+		err = c.rot.Close()
+	}
+	for _, fs := range c.Sink.FileSink {
+		if fs.rot == nil {
+			continue
+		}
+		if closeErr := fs.rot.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
 	}
-	return c.rot.Close()
+	return err
 }
 
 func (c *Config) GetRotator() *sink.Rotator {
 	return c.rot
 }
 
-// GetSinks returns an array of sinks based on the config
+// GetSinks returns an array of sinks based on the config.  This is the
+// single [filesink], [elastic], [kafka], [logstash] and [sampler] sections
+// followed by the named sinks in [[sink.*]].
 func (c *Config) GetSinks() ([]sink.Sinker, error) {
 	sinks := make([]sink.Sinker, 0)
+	add := func(snk sink.Sinker, err error) error {
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, snk)
+		return nil
+	}
 
-	// Add FileSink
 	if c.FileSink != nil {
-		//fileSink := sink.NewFileSink(c.FileSink.Directory, c.FileSink.RetainHours)
-		of := sink.NewOneFile(c.rot)
-		sinks = append(sinks, of)
+		if err := add(c.fileSink(*c.FileSink, "filesink")); err != nil {
+			return sinks, err
+		}
 	}
-
-	// Add an ElasticSink
 	if len(c.Elastic.Addresses) > 0 && c.Elastic.Username != "" && c.Elastic.Password != "" {
-		es, err := sink.NewElasticSink(c.Elastic.Addresses, c.Elastic.ProxyServer, c.Elastic.Username, c.Elastic.Password)
-		if err != nil {
-			return sinks, errors.Wrap(err, "sink.newelasticsink")
+		if err := add(c.elasticSink(c.Elastic, "elastic")); err != nil {
+			return sinks, err
 		}
-		es.DefaultIndex = c.Elastic.DefaultIndex
-		es.EventIndexMap, err = buildmap(c.Elastic.RawEventMap, "", "")
-		if err != nil {
-			return sinks, errors.Wrap(err, "elastic.buildmap")
+	}
+	if c.Kafka != nil && len(c.Kafka.Brokers) > 0 {
+		if err := add(c.kafkaSink(*c.Kafka, "kafka")); err != nil {
+			return sinks, err
 		}
-		es.AutoCreateIndexes = c.Elastic.AutoCreateIndexes
-		sinks = append(sinks, es)
 	}
-
-	// Add LogstashSink
 	if c.Logstash != nil {
-		ls := *c.Logstash
-		lss, err := sink.NewLogstashSink(ls.Host, 30)
-		if err != nil {
-			return sinks, errors.Wrap(err, "sink.newlogstashsink")
+		if err := add(c.logstashSink(*c.Logstash, "logstash")); err != nil {
+			return sinks, err
+		}
+	}
+	if c.OTLP != nil {
+		if err := add(c.otlpSink(*c.OTLP, "otlp")); err != nil {
+			return sinks, err
+		}
+	}
+	if c.Splunk != nil {
+		if err := add(c.splunkSink(*c.Splunk, "splunk")); err != nil {
+			return sinks, err
+		}
+	}
+	if c.Syslog != nil {
+		if err := add(c.syslogSink(*c.Syslog, "syslog")); err != nil {
+			return sinks, err
 		}
-		//lss.RetryAlertThreshold = c.Logstash.RetryAlertThreshold
-		sinks = append(sinks, lss)
 	}
 
 	// Add any SamplerSink
@@ -212,13 +243,352 @@ func (c *Config) GetSinks() ([]sink.Sinker, error) {
 		if err != nil {
 			return sinks, errors.Wrap(err, "os.executable")
 		}
-		ss := sampler.New(filepath.Dir(exec), dur)
+		ss, err := sc.route(sampler.New(filepath.Dir(exec), dur), "sampler")
+		if err != nil {
+			return sinks, err
+		}
 		sinks = append(sinks, ss)
 	}
 
+	// named sinks.  The id for the spool directory and errors is the type
+	// and the name.
+	names := make(map[string]bool)
+	named := func(kind string, i int, name string) (string, error) {
+		if name == "" {
+			return "", fmt.Errorf("sink.%s #%d: missing name", kind, i+1)
+		}
+		if names[strings.ToLower(name)] {
+			return "", fmt.Errorf("sink.%s #%d: duplicate name: %s", kind, i+1, name)
+		}
+		names[strings.ToLower(name)] = true
+		return kind + "-" + name, nil
+	}
+	for i, fs := range c.Sink.FileSink {
+		id, err := named("filesink", i, fs.Name)
+		if err != nil {
+			return sinks, err
+		}
+		if err = add(c.fileSink(fs, id)); err != nil {
+			return sinks, err
+		}
+	}
+	for i, ec := range c.Sink.Elastic {
+		id, err := named("elastic", i, ec.Name)
+		if err != nil {
+			return sinks, err
+		}
+		if err = add(c.elasticSink(ec, id)); err != nil {
+			return sinks, err
+		}
+	}
+	for i, kc := range c.Sink.Kafka {
+		id, err := named("kafka", i, kc.Name)
+		if err != nil {
+			return sinks, err
+		}
+		if err = add(c.kafkaSink(kc, id)); err != nil {
+			return sinks, err
+		}
+	}
+	for i, ls := range c.Sink.Logstash {
+		id, err := named("logstash", i, ls.Name)
+		if err != nil {
+			return sinks, err
+		}
+		if err = add(c.logstashSink(ls, id)); err != nil {
+			return sinks, err
+		}
+	}
+	for i, oc := range c.Sink.OTLP {
+		id, err := named("otlp", i, oc.Name)
+		if err != nil {
+			return sinks, err
+		}
+		if err = add(c.otlpSink(oc, id)); err != nil {
+			return sinks, err
+		}
+	}
+	for i, sc := range c.Sink.Splunk {
+		id, err := named("splunk", i, sc.Name)
+		if err != nil {
+			return sinks, err
+		}
+		if err = add(c.splunkSink(sc, id)); err != nil {
+			return sinks, err
+		}
+	}
+	for i, sc := range c.Sink.Syslog {
+		id, err := named("syslog", i, sc.Name)
+		if err != nil {
+			return sinks, err
+		}
+		if err = add(c.syslogSink(sc, id)); err != nil {
+			return sinks, err
+		}
+	}
 	return sinks, nil
 }
 
+// fileSink builds a file sink.  The rotator is set up in Get.
+func (c *Config) fileSink(fs FileSink, id string) (sink.Sinker, error) {
+	if fs.rot == nil {
+		return nil, fmt.Errorf("%s: no rotator", id)
+	}
+	f, err := fs.formatter(id)
+	if err != nil {
+		return nil, err
+	}
+	snk := sink.NewNamed(sink.NewFormatted(sink.NewOneFile(fs.rot), f), fs.Name)
+	return fs.route(snk, id)
+}
+
+// elasticSink builds an ElasticSink
+func (c *Config) elasticSink(ec ElasticConfig, id string) (sink.Sinker, error) {
+	es, err := sink.NewElasticSink(ec.Addresses, ec.ProxyServer, ec.Username, ec.Password)
+	if err != nil {
+		return nil, errors.Wrap(err, "sink.newelasticsink")
+	}
+	es.DefaultIndex = ec.DefaultIndex
+	es.EventIndexMap, err = buildmap(ec.RawEventMap, "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "elastic.buildmap")
+	}
+	es.AutoCreateIndexes = ec.AutoCreateIndexes
+	if ec.BatchCount > 0 {
+		es.BatchCount = ec.BatchCount
+	}
+	if ec.BatchBytes > 0 {
+		es.BatchBytes = ec.BatchBytes
+	}
+	if ec.FlushInterval.Duration > 0 {
+		es.FlushInterval = ec.FlushInterval.Duration
+	}
+	if ec.MaxRetries != nil {
+		es.MaxRetries = *ec.MaxRetries
+	}
+	es.DeadLetterFile = ec.DeadLetterFile
+	es.DataStreams = ec.DataStreams
+	es.ILMPolicy = ec.ILMPolicy
+	es.ManageTemplates = ec.ManageTemplates
+	if ec.TemplateName != "" {
+		es.TemplateName = ec.TemplateName
+	}
+	if ec.TemplatePriority > 0 {
+		es.TemplatePriority = ec.TemplatePriority
+	}
+	snk, err := c.spool(sink.NewNamed(es, ec.Name), ec.Spool, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "elastic.spool")
+	}
+	return ec.route(snk, id)
+}
+
+// kafkaSink builds a KafkaSink
+func (c *Config) kafkaSink(kc KafkaConfig, id string) (sink.Sinker, error) {
+	if kc.DefaultTopic == "" {
+		return nil, fmt.Errorf("%s: missing default_topic", id)
+	}
+	ks := sink.NewKafkaSink(kc.Brokers, kc.DefaultTopic)
+	var err error
+	ks.EventTopicMap, err = buildmap(kc.RawEventMap, "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "kafka.buildmap")
+	}
+	if kc.Compression != "" {
+		ks.Compression = kc.Compression
+	}
+	if kc.Acks != "" {
+		ks.Acks = kc.Acks
+	}
+	ks.BatchBytes = kc.BatchBytes
+	ks.Linger = kc.Linger.Duration
+	f, err := kc.formatter(id)
+	if err != nil {
+		return nil, err
+	}
+	snk, err := c.spool(sink.NewNamed(sink.NewFormatted(ks, f), kc.Name), kc.Spool, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "kafka.spool")
+	}
+	return kc.route(snk, id)
+}
+
+// logstashSink builds a LogstashSink
+func (c *Config) logstashSink(ls Logstash, id string) (sink.Sinker, error) {
+	lss, err := sink.NewLogstashSink(ls.Host, 30)
+	if err != nil {
+		return nil, errors.Wrap(err, "sink.newlogstashsink")
+	}
+	//lss.RetryAlertThreshold = c.Logstash.RetryAlertThreshold
+	f, err := ls.formatter(id)
+	if err != nil {
+		return nil, err
+	}
+	snk, err := c.spool(sink.NewNamed(sink.NewFormatted(lss, f), ls.Name), ls.Spool, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "logstash.spool")
+	}
+	return ls.route(snk, id)
+}
+
+// otlpSink builds an OTLPSink
+func (c *Config) otlpSink(oc OTLPConfig, id string) (sink.Sinker, error) {
+	ot := sink.NewOTLPSink(oc.Endpoint)
+	switch strings.ToLower(oc.Encoding) {
+	case "":
+	case "protobuf", "json":
+		ot.Encoding = strings.ToLower(oc.Encoding)
+	default:
+		return nil, fmt.Errorf("%s: invalid encoding: %s", id, oc.Encoding)
+	}
+	ot.Headers = oc.Headers
+	if oc.ServiceName != "" {
+		ot.ServiceName = oc.ServiceName
+	}
+	if oc.Timeout.Duration > 0 {
+		ot.Timeout = oc.Timeout.Duration
+	}
+	snk, err := c.spool(sink.NewNamed(ot, oc.Name), oc.Spool, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "otlp.spool")
+	}
+	return oc.route(snk, id)
+}
+
+// splunkSink builds a SplunkHECSink
+func (c *Config) splunkSink(sc SplunkConfig, id string) (sink.Sinker, error) {
+	hec := sink.NewSplunkHECSink(sc.URL, sc.Token)
+	if sc.Source != "" {
+		hec.Source = sc.Source
+	}
+	if sc.SourceTypePrefix != nil {
+		hec.SourceTypePrefix = *sc.SourceTypePrefix
+	}
+	hec.DefaultIndex = sc.DefaultIndex
+	var err error
+	hec.EventIndexMap, err = buildmap(sc.RawEventMap, "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "splunk.buildmap")
+	}
+	if sc.BatchBytes > 0 {
+		hec.BatchBytes = sc.BatchBytes
+	}
+	if sc.Timeout.Duration > 0 {
+		hec.Timeout = sc.Timeout.Duration
+	}
+	hec.Ack = sc.Ack
+	if sc.AckTimeout.Duration > 0 {
+		hec.AckTimeout = sc.AckTimeout.Duration
+	}
+	snk, err := c.spool(sink.NewNamed(hec, sc.Name), sc.Spool, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "splunk.spool")
+	}
+	return sc.route(snk, id)
+}
+
+// syslogSink builds a SyslogSink
+func (c *Config) syslogSink(sc SyslogConfig, id string) (sink.Sinker, error) {
+	if sc.Address == "" {
+		return nil, fmt.Errorf("%s: missing address", id)
+	}
+	network := strings.ToLower(sc.Network)
+	switch network {
+	case "":
+		network = "udp"
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("%s: invalid network: %s", id, sc.Network)
+	}
+	ss := sink.NewSyslogSink(network, sc.Address)
+	if sc.Facility != "" {
+		f, err := sink.SyslogFacility(sc.Facility)
+		if err != nil {
+			return nil, errors.Wrap(err, id)
+		}
+		ss.Facility = f
+	}
+	if sc.AppName != "" {
+		ss.AppName = sc.AppName
+	}
+	if strings.EqualFold(sc.Format, "description") {
+		if len(sc.Extensions) > 0 {
+			return nil, fmt.Errorf("%s: extensions can't be used with format description", id)
+		}
+		ss.Format = "description"
+	} else {
+		f, err := sc.formatter(id)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := f.(sink.JSONFormatter); !ok {
+			ss.Output = f
+		}
+	}
+	ss.CAFile = sc.CAFile
+	ss.CertFile = sc.CertFile
+	ss.KeyFile = sc.KeyFile
+	if sc.Timeout.Duration > 0 {
+		ss.Timeout = sc.Timeout.Duration
+	}
+	snk, err := c.spool(sink.NewNamed(ss, sc.Name), sc.Spool, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "syslog.spool")
+	}
+	return sc.route(snk, id)
+}
+
+// spool wraps a sink in a disk spool if it is enabled.
+// Each sink gets its own directory under the spool directory.
+func (c *Config) spool(snk sink.Sinker, enabled bool, name string) (sink.Sinker, error) {
+	if !enabled {
+		return snk, nil
+	}
+	var sc SpoolConfig
+	if c.Spool != nil {
+		sc = *c.Spool
+	}
+	dir := sc.Dir
+	if dir == "" {
+		exec, err := os.Executable()
+		if err != nil {
+			return snk, errors.Wrap(err, "os.executable")
+		}
+		dir = filepath.Join(filepath.Dir(exec), "spool")
+	}
+	sp := sink.NewSpool(snk, filepath.Join(dir, name))
+	if sc.MaxSizeMB > 0 {
+		sp.MaxBytes = int64(sc.MaxSizeMB) * 1024 * 1024
+	}
+	if sc.SegmentSizeMB > 0 {
+		sp.SegmentBytes = int64(sc.SegmentSizeMB) * 1024 * 1024
+	}
+	return sp, nil
+}
+
+// setup sets the defaults for a file sink and creates its rotator.  The
+// directory defaults to dir under the directory of the executable.
+func (fs *FileSink) setup(dir string) error {
+	if fs.RetainHours == 0 {
+		fs.RetainHours = 168 // 7 days
+	}
+
+	if fs.Directory == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return errors.Wrap(err, "os.executable")
+		}
+		exeDir := filepath.Dir(executable)
+		fs.Directory = filepath.Join(exeDir, dir)
+	}
+
+	rot := sink.NewRotator(fs.Directory, "sqlevents", "events")
+	rot.Retention = time.Duration(fs.RetainHours) * time.Hour
+	rot.Hourly = true
+	fs.rot = rot
+	return nil
+}
+
 // processLookBack pushes the StartAt forward if needed based on look_back
 func (s *Source) processLookback() error {
 	if s.LookBackRaw == "" {
@@ -299,6 +669,13 @@ func (c *Config) decodekv(version, sha1ver string) error {
 		return errors.Wrap(err, "buildmap.elastic.eventindexmap")
 	}
 
+	if c.Kafka != nil {
+		c.Kafka.EventTopicMap, err = buildmap(c.Kafka.RawEventMap, version, sha1ver)
+		if err != nil {
+			return errors.Wrap(err, "buildmap.kafka.eventtopicmap")
+		}
+	}
+
 	// if c.App.Copies, err = buildmap(c.App.RawCopies); err != nil {
 	// 	return errors.Wrap(err, "app-copies")
 	// }
@@ -362,6 +739,9 @@ func (c *Config) setSourceDefaults() error {
 		if v.Password != "" {
 			n.Password = v.Password
 		}
+		if v.Database != "" {
+			n.Database = v.Database
+		}
 		if v.ServerNameOverride != "" {
 			n.ServerNameOverride = v.ServerNameOverride
 		}
@@ -376,6 +756,9 @@ func (c *Config) setSourceDefaults() error {
 		if v.IgnoreSessions {
 			n.Sessions = []string{}
 		}
+		if len(v.Audits) > 0 {
+			n.Audits = v.Audits
+		}
 		if len(v.ExcludedEvents) > 0 {
 			n.ExcludedEvents = v.ExcludedEvents
 		}
@@ -424,6 +807,12 @@ func (c *Config) setSourceDefaults() error {
 		if v.Exclude17830 {
 			n.Exclude17830 = v.Exclude17830
 		}
+		if v.ErrorLog {
+			n.ErrorLog = v.ErrorLog
+		}
+		if v.DefaultTrace {
+			n.DefaultTrace = v.DefaultTrace
+		}
 
 		// if v.Test != false {
 		// 	n.Test = v.Test
@@ -492,6 +881,10 @@ func (e *ElasticConfig) Print() {
 	for k, v := range e.EventIndexMap {
 		fmt.Printf("-- event: %s -> %s\r\n", k, v)
 	}
+	fmt.Println("-- data_streams:", e.DataStreams)
+	fmt.Println("-- ilm_policy:", e.ILMPolicy)
+	fmt.Println("-- manage_templates:", e.ManageTemplates)
+	fmt.Println("-- template_priority:", e.TemplatePriority)
 	fmt.Println("--------------------------------------------------")
 
 }
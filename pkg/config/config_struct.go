@@ -3,8 +3,12 @@ package config
 import (
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/billgraziano/toml"
+	"github.com/billgraziano/xelogstash/pkg/filter"
 	"github.com/billgraziano/xelogstash/pkg/sink"
+	"github.com/billgraziano/xelogstash/pkg/xe"
 )
 
 // Config defines the configuration read from the TOML file
@@ -15,9 +19,15 @@ type Config struct {
 	Sources  []Source `toml:"source"`
 
 	Elastic  ElasticConfig `toml:"elastic"`
+	Sink     SinksConfig   `toml:"sink"`
 	FileSink *FileSink     `toml:"filesink"`
+	Kafka    *KafkaConfig  `toml:"kafka"`
 	Logstash *Logstash     `toml:"logstash"`
+	OTLP     *OTLPConfig   `toml:"otlp"`
+	Splunk   *SplunkConfig `toml:"splunk"`
+	Syslog   *SyslogConfig `toml:"syslog"`
 	Sampler  *Sampler      `toml:"sampler"`
+	Spool    *SpoolConfig  `toml:"spool"`
 	MetaData toml.MetaData
 
 	ConfigFile     string
@@ -25,8 +35,10 @@ type Config struct {
 	SourcesFile    string
 	SourcesFileMod time.Time
 
-	Filters []Filter `toml:"filter"`
-	rot     *sink.Rotator
+	Filters  []Filter       `toml:"filter"`
+	Rules    []filter.Rule  // Filters compiled by Get
+	Sessions SessionsConfig `toml:"sessions"`
+	rot      *sink.Rotator
 	//Sinks    []sink.Sinker
 }
 
@@ -37,12 +49,16 @@ type Source struct {
 	Password           string `toml:"password"`
 	Driver             string `toml:"driver"`
 	ODBCDriver         string `toml:"odbc_driver"`
+	Database           string `toml:"database"` // master unless this is Azure SQL Database
 	ServerNameOverride string `toml:"server_name_override"`
 	DomainNameOverride string `toml:"domain_name_override"`
 	PollSeconds        int    `toml:"poll_seconds"`
 
 	Sessions       []string
-	IgnoreSessions bool `toml:"ignore_sessions"` // if true, skip XE sessions
+	IgnoreSessions bool     `toml:"ignore_sessions"` // if true, skip XE sessions
+	Audits         []string `toml:"audits"`          // server audits with a file target
+	ErrorLog       bool     `toml:"errorlog"`        // read the error log with xp_readerrorlog
+	DefaultTrace   bool     `toml:"default_trace"`   // read the default trace
 	Prefix         string
 	AgentJobs      string
 	PayloadField   string `toml:"payload_field_name"`
@@ -70,7 +86,7 @@ type Source struct {
 
 // App defines the application configuration
 type App struct {
-	Workers        int
+	Workers        int `toml:"workers"` // most sources polled at the same time
 	Logstash       string
 	Samples        bool   // Print sample JSON to stdout
 	Summary        bool   // Print a summary to stdout
@@ -80,6 +96,9 @@ type App struct {
 	WatchConfig    bool   `toml:"watch_config"`
 	BetaFeatures   bool   `toml:"beta_features"`
 
+	// How long to use the metadata read from a source before reading it again
+	MetadataTTL duration `toml:"metadata_ttl"`
+
 	// Enables a web server on :8080 with basic metrics
 	HTTPMetrics     bool `toml:"http_metrics"`
 	HTTPMetricsPort int  `toml:"http_metrics_port"`
@@ -101,8 +120,22 @@ type AppLog struct {
 	RawMoves  []string `toml:"moves"`
 }
 
+// SinksConfig holds the named sinks in [[sink.filesink]], [[sink.elastic]],
+// [[sink.kafka]], [[sink.logstash]], [[sink.otlp]], [[sink.splunk]] and
+// [[sink.syslog]].  The name is used in the logs and the Prometheus sink label.
+type SinksConfig struct {
+	FileSink []FileSink      `toml:"filesink"`
+	Elastic  []ElasticConfig `toml:"elastic"`
+	Kafka    []KafkaConfig   `toml:"kafka"`
+	Logstash []Logstash      `toml:"logstash"`
+	OTLP     []OTLPConfig    `toml:"otlp"`
+	Splunk   []SplunkConfig  `toml:"splunk"`
+	Syslog   []SyslogConfig  `toml:"syslog"`
+}
+
 // ElasticConfig holds the configuration for sending events to elastic
 type ElasticConfig struct {
+	Name              string   `toml:"name"`
 	Addresses         []string `toml:"addresses"`
 	Username          string   `toml:"username"`
 	Password          string   `toml:"password"`
@@ -112,18 +145,144 @@ type ElasticConfig struct {
 	RawEventMap       []string `toml:"event_index_map"`
 	AutoCreateIndexes bool     `toml:"auto_create_indexes"`
 	ProxyServer       string   `toml:"proxy_server"`
+	Spool             bool     `toml:"spool"`
+	BatchCount        int      `toml:"batch_count"`
+	BatchBytes        int      `toml:"batch_bytes"`
+	FlushInterval     duration `toml:"flush_interval"`
+	MaxRetries        *int     `toml:"max_retries"`
+	DeadLetterFile    string   `toml:"dead_letter_file"`
+	DataStreams       bool     `toml:"data_streams"`
+	ILMPolicy         string   `toml:"ilm_policy"`
+	ManageTemplates   bool     `toml:"manage_templates"`
+	TemplateName      string   `toml:"template_name"`
+	TemplatePriority  int      `toml:"template_priority"`
+	Routes
+}
+
+// KafkaConfig configures a KafkaSink
+type KafkaConfig struct {
+	Name          string   `toml:"name"`
+	Brokers       []string `toml:"brokers"`
+	DefaultTopic  string   `toml:"default_topic"`
+	EventTopicMap map[string]string
+	RawEventMap   []string `toml:"event_topic_map"`
+	Compression   string   `toml:"compression"` // none (default), gzip, snappy, lz4, zstd
+	Acks          string   `toml:"acks"`        // all (default), leader, none
+	BatchBytes    int      `toml:"batch_bytes"`
+	Linger        duration `toml:"linger"`
+	Spool         bool     `toml:"spool"`
+	Routes
+	Output
 }
 
 // FileSink configures a file sink
 type FileSink struct {
+	Name        string `toml:"name"`
 	Directory   string `toml:"dir"`
 	RetainHours int    `toml:"retain_hours"`
+	Routes
+	Output
+	rot *sink.Rotator
 }
 
 // Logstash configures a LogstashSink
 type Logstash struct {
+	Name                string `toml:"name"`
 	Host                string `toml:"host"`
 	RetryAlertThreshold int    `toml:"retry_alert_threshold"`
+	Spool               bool   `toml:"spool"`
+	Routes
+	Output
+}
+
+// OTLPConfig configures an OTLPSink
+type OTLPConfig struct {
+	Name        string            `toml:"name"`
+	Endpoint    string            `toml:"endpoint"`
+	Encoding    string            `toml:"encoding"` // protobuf (default) or json
+	Headers     map[string]string `toml:"headers"`
+	ServiceName string            `toml:"service_name"`
+	Timeout     duration          `toml:"timeout"`
+	Spool       bool              `toml:"spool"`
+	Routes
+}
+
+// SplunkConfig configures a SplunkHECSink
+type SplunkConfig struct {
+	Name             string   `toml:"name"`
+	URL              string   `toml:"url"`
+	Token            string   `toml:"token"`
+	Source           string   `toml:"source"`
+	SourceTypePrefix *string  `toml:"sourcetype_prefix"`
+	DefaultIndex     string   `toml:"default_index"`
+	RawEventMap      []string `toml:"event_index_map"`
+	BatchBytes       int      `toml:"batch_bytes"`
+	Timeout          duration `toml:"timeout"`
+	Ack              bool     `toml:"ack"`
+	AckTimeout       duration `toml:"ack_timeout"`
+	Spool            bool     `toml:"spool"`
+	Routes
+}
+
+// SyslogConfig configures a SyslogSink.  Besides the Output formats, the
+// format can be description to send xe_description.  It doesn't take
+// extensions.
+type SyslogConfig struct {
+	Name     string   `toml:"name"`
+	Network  string   `toml:"network"` // udp (default), tcp or tls
+	Address  string   `toml:"address"`
+	Facility string   `toml:"facility"` // local0 (default)
+	AppName  string   `toml:"app_name"`
+	CAFile   string   `toml:"ca_file"`
+	CertFile string   `toml:"cert_file"`
+	KeyFile  string   `toml:"key_file"`
+	Timeout  duration `toml:"timeout"`
+	Spool    bool     `toml:"spool"`
+	Routes
+	Output
+}
+
+// Output picks how a sink writes events.  Elastic, OTLP and Splunk always
+// write JSON.
+type Output struct {
+	Format     string            `toml:"format"`     // json (default), cef or leef
+	Extensions map[string]string `toml:"extensions"` // event field to CEF or LEEF key
+}
+
+// formatter returns the Formatter for the output
+func (o Output) formatter(name string) (sink.Formatter, error) {
+	f, err := sink.NewFormatter(o.Format, o.Extensions)
+	if err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	return f, nil
+}
+
+// SessionsConfig holds the XE sessions the tool deploys
+type SessionsConfig struct {
+	Deploy []xe.Definition `toml:"deploy"`
+}
+
+// Routes picks the events a sink gets using filter expressions
+type Routes struct {
+	Include string `toml:"include"` // only events that match
+	Exclude string `toml:"exclude"` // except events that match
+}
+
+// route wraps a sink with its routes
+func (r Routes) route(snk sink.Sinker, name string) (sink.Sinker, error) {
+	routed, err := sink.NewRoute(snk, r.Include, r.Exclude)
+	if err != nil {
+		return snk, errors.Wrap(err, name)
+	}
+	return routed, nil
+}
+
+// SpoolConfig configures the disk spool for sinks that set spool = true
+type SpoolConfig struct {
+	Dir           string `toml:"dir"`
+	MaxSizeMB     int    `toml:"max_size_mb"`
+	SegmentSizeMB int    `toml:"segment_size_mb"`
 }
 
 type duration struct {
@@ -139,6 +298,31 @@ func (d *duration) UnmarshalText(text []byte) error {
 // Sampler configures a SamplerSink
 type Sampler struct {
 	Duration duration
+	Routes
 }
 
+// Filter is a [[filter]] from the config file.  filter_action and where
+// are the action and expression.  Any other keys must match exactly.
 type Filter map[string]interface{}
+
+// Rule compiles a filter
+func (f Filter) Rule() (filter.Rule, error) {
+	action, ok := f["filter_action"].(string)
+	if !ok {
+		return filter.Rule{}, errors.New("missing 'filter_action'")
+	}
+	var where string
+	if v, ok := f["where"]; ok {
+		where, ok = v.(string)
+		if !ok {
+			return filter.Rule{}, errors.New("'where' must be a string")
+		}
+	}
+	fields := make(map[string]interface{})
+	for k, v := range f {
+		if k != "filter_action" && k != "where" {
+			fields[k] = v
+		}
+	}
+	return filter.New(action, where, fields)
+}
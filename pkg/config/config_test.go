@@ -2,10 +2,12 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/billgraziano/toml"
+	"github.com/billgraziano/xelogstash/pkg/sink"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -113,6 +115,7 @@ func TestProcessEnvVariables(t *testing.T) {
 	cfg.Elastic.Username = "$(env:SQLXE_UP)"
 	cfg.Elastic.Password = "$(env:SQLXE_UP)"
 	cfg.Sources = []Source{{User: "$(env:SQLXE_UP)", Password: "$(env:SQLXE_UP)"}}
+	cfg.Sink.Elastic = []ElasticConfig{{Name: "east", Username: "$(env:SQLXE_UP)", Password: "$(env:SQLXE_UP)"}}
 	err := cfg.processEnvVariables()
 	assert.NoError(err)
 	assert.Equal("userpass", cfg.Defaults.User)
@@ -121,4 +124,393 @@ func TestProcessEnvVariables(t *testing.T) {
 	assert.Equal("userpass", cfg.Elastic.Password)
 	assert.Equal("userpass", cfg.Sources[0].User)
 	assert.Equal("userpass", cfg.Sources[0].Password)
+	assert.Equal("userpass", cfg.Sink.Elastic[0].Username)
+	assert.Equal("userpass", cfg.Sink.Elastic[0].Password)
+}
+
+func TestKafkaConfig(t *testing.T) {
+	assert := assert.New(t)
+	var c = `
+	[kafka]
+	brokers = ["kafka1:9092", "kafka2:9092"]
+	default_topic = "sqlxe"
+	event_topic_map = ["xml_deadlock_report:deadlocks"]
+	compression = "zstd"
+	linger = "50ms"
+	`
+	cfg := Config{}
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	assert.NoError(cfg.decodekv("", ""))
+	assert.Equal("deadlocks", cfg.Kafka.EventTopicMap["xml_deadlock_report"])
+	assert.Equal(50*time.Millisecond, cfg.Kafka.Linger.Duration)
+
+	sinks, err := cfg.GetSinks()
+	assert.NoError(err)
+	assert.Equal(1, len(sinks))
+	assert.Equal("kafka: kafka1:9092, kafka2:9092", sinks[0].Name())
+
+	cfg.Kafka.DefaultTopic = ""
+	_, err = cfg.GetSinks()
+	assert.Error(err)
+	assert.Contains(err.Error(), "kafka: missing default_topic")
+
+	// a named kafka sink builds its own topic map
+	c = `
+	[[sink.kafka]]
+	name = "east"
+	brokers = ["kafka1:9092"]
+	default_topic = "sqlxe"
+	event_topic_map = ["login:security", "xml_deadlock_report:deadlocks"]
+	`
+	cfg = Config{}
+	_, err = toml.Decode(c, &cfg)
+	assert.NoError(err)
+	assert.NoError(cfg.decodekv("", ""))
+	sinks, err = cfg.GetSinks()
+	assert.NoError(err)
+	if !assert.Equal(1, len(sinks)) {
+		return
+	}
+	ks, ok := sinks[0].(*sink.Named).Sinker.(*sink.KafkaSink)
+	if assert.True(ok) {
+		assert.Equal("security", ks.EventTopicMap["login"])
+		assert.Equal("deadlocks", ks.EventTopicMap["xml_deadlock_report"])
+	}
+}
+
+func TestSpoolConfig(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	var c = `
+	[spool]
+	dir = '` + dir + `'
+	max_size_mb = 10
+
+	[kafka]
+	brokers = ["kafka1:9092"]
+	default_topic = "sqlxe"
+	spool = true
+	`
+	cfg := Config{}
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	sinks, err := cfg.GetSinks()
+	assert.NoError(err)
+	assert.Equal(1, len(sinks))
+	sp, ok := sinks[0].(*sink.Spool)
+	assert.True(ok)
+	assert.Equal(int64(10*1024*1024), sp.MaxBytes)
+	assert.Equal(filepath.Join(dir, "kafka"), sp.Dir)
+	assert.Equal("spool: kafka: kafka1:9092", sp.Name())
+}
+
+func TestSessionsDeployConfig(t *testing.T) {
+	assert := assert.New(t)
+	var c = `
+	[[sessions.deploy]]
+	name = "logstash_logins"
+	max_file_size_mb = 50
+
+		[[sessions.deploy.event]]
+		name = "sqlserver.login"
+		actions = ["sqlserver.client_app_name", "sqlserver.client_hostname"]
+		predicate = "[is_cached] = 0"
+		fields = { collect_options_text = 1 }
+	`
+	cfg := Config{}
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	assert.Equal(1, len(cfg.Sessions.Deploy))
+	d := cfg.Sessions.Deploy[0]
+	assert.NoError(d.Validate())
+	assert.Equal(50, d.MaxFileSizeMB)
+	assert.Equal(20, d.MaxRolloverFiles)
+	assert.Equal(1, len(d.Events))
+	assert.Equal(int64(1), d.Events[0].Fields["collect_options_text"])
+}
+
+func TestFilterRules(t *testing.T) {
+	assert := assert.New(t)
+	var c = `
+	[[filter]]
+	filter_action = "exclude"
+	where = '''
+		name == 'rpc_completed'
+		and duration < 1s
+		and database_name not in ('Sales', 'HR')'''
+
+	[[filter]]
+	filter_action = "include"
+	error_number = 15151
+	where = "server_instance_name == 'server01'"
+
+	[[filter]]
+	filter_action = "exclude"
+	where = "name == 'login' and"
+	`
+	cfg := Config{}
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	assert.Equal(3, len(cfg.Filters))
+
+	r, err := cfg.Filters[0].Rule()
+	assert.NoError(err)
+	assert.True(r.Match(map[string]interface{}{"name": "rpc_completed", "duration": int64(5000), "database_name": "Test"}))
+	assert.False(r.Match(map[string]interface{}{"name": "rpc_completed", "duration": int64(5000), "database_name": "HR"}))
+
+	r, err = cfg.Filters[1].Rule()
+	assert.NoError(err)
+	assert.True(r.Match(map[string]interface{}{"error_number": int64(15151), "server_instance_name": "server01"}))
+	assert.False(r.Match(map[string]interface{}{"error_number": int64(15151), "server_instance_name": "server02"}))
+
+	_, err = cfg.Filters[2].Rule()
+	assert.Error(err)
+	assert.Contains(err.Error(), "line 1, col 20")
+
+	_, err = Filter{"error_number": 1}.Rule()
+	assert.Error(err)
+}
+
+func TestSinkRoutes(t *testing.T) {
+	assert := assert.New(t)
+	var c = `
+	[kafka]
+	brokers = ["kafka1:9092"]
+	default_topic = "sqlxe"
+	include = "xe_category == 'login'"
+	exclude = "server_principal_name =~ '^NT SERVICE'"
+	`
+	cfg := Config{}
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	assert.Equal("xe_category == 'login'", cfg.Kafka.Include)
+	sinks, err := cfg.GetSinks()
+	assert.NoError(err)
+	assert.Equal(1, len(sinks))
+	r, ok := sinks[0].(*sink.Route)
+	assert.True(ok)
+	assert.Equal("kafka: kafka1:9092", r.Name())
+	assert.True(r.Routed(sink.Event{Fields: map[string]interface{}{"xe_category": "login", "server_principal_name": "sa"}}))
+	assert.False(r.Routed(sink.Event{Fields: map[string]interface{}{"xe_category": "login", "server_principal_name": "NT SERVICE\\SQLAgent"}}))
+	assert.False(r.Routed(sink.Event{Fields: map[string]interface{}{"xe_category": "tsql"}}))
+
+	cfg.Kafka.Include = "xe_category in ('login'"
+	_, err = cfg.GetSinks()
+	assert.Error(err)
+	assert.Contains(err.Error(), "kafka: include")
+}
+
+func TestNamedSinks(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	var c = `
+	[spool]
+	dir = '` + dir + `'
+
+	[kafka]
+	brokers = ["kafka0:9092"]
+	default_topic = "sqlxe"
+
+	[[sink.logstash]]
+	name = "security"
+	host = "siem:5044"
+	include = "xe_category == 'login'"
+
+	[[sink.logstash]]
+	name = "ops"
+	host = "ops:5044"
+	spool = true
+
+	[[sink.kafka]]
+	name = "east"
+	brokers = ["kafka1:9092"]
+	default_topic = "sqlxe"
+	`
+	cfg := Config{}
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	assert.Equal(2, len(cfg.Sink.Logstash))
+	sinks, err := cfg.GetSinks()
+	assert.NoError(err)
+	names := make([]string, 0)
+	for _, snk := range sinks {
+		names = append(names, snk.Name())
+	}
+	assert.Equal([]string{"kafka: kafka0:9092", "east", "security", "spool: ops"}, names)
+	sp, ok := sinks[3].(*sink.Spool)
+	if !assert.True(ok) {
+		return
+	}
+	assert.Equal(filepath.Join(dir, "logstash-ops"), sp.Dir)
+
+	cfg.Sink.Kafka = append(cfg.Sink.Kafka, KafkaConfig{Name: "OPS", Brokers: []string{"kafka2:9092"}, DefaultTopic: "sqlxe"})
+	_, err = cfg.GetSinks()
+	assert.Error(err)
+	assert.Contains(err.Error(), "sink.logstash #2: duplicate name: ops")
+
+	cfg.Sink.Kafka = []KafkaConfig{{Brokers: []string{"kafka2:9092"}}}
+	_, err = cfg.GetSinks()
+	assert.Error(err)
+	assert.Contains(err.Error(), "sink.kafka #1: missing name")
+}
+
+func TestOTLPSink(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("OTLP_TEST_KEY", "secret")
+	var c = `
+	[otlp]
+	endpoint = "http://collector:4318/v1/logs"
+
+	[[sink.otlp]]
+	name = "otel"
+	encoding = "JSON"
+	service_name = "sqlxe"
+	timeout = "5s"
+	headers = { "api-key" = "$(env:OTLP_TEST_KEY)" }
+	exclude = "name == 'login'"
+	`
+	cfg := Config{}
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	assert.NoError(cfg.processEnvVariables())
+	assert.Equal("secret", cfg.Sink.OTLP[0].Headers["api-key"])
+	sinks, err := cfg.GetSinks()
+	assert.NoError(err)
+	if !assert.Equal(2, len(sinks)) {
+		return
+	}
+	assert.Equal("otlp: http://collector:4318/v1/logs", sinks[0].Name())
+	assert.Equal("otel", sinks[1].Name())
+	_, ok := sinks[1].(*sink.Route)
+	assert.True(ok)
+
+	cfg.Sink.OTLP[0].Encoding = "xml"
+	_, err = cfg.GetSinks()
+	assert.Error(err)
+	assert.Contains(err.Error(), "otlp-otel: invalid encoding: xml")
+}
+
+func TestSplunkSink(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("HEC_TEST_TOKEN", "hec-secret")
+	var c = `
+	[splunk]
+	url = "https://splunk:8088"
+	token = "$(env:HEC_TEST_TOKEN)"
+	default_index = "sqlxe"
+	event_index_map = ["login:security"]
+	ack = true
+
+	[[sink.splunk]]
+	name = "soc"
+	url = "https://soc:8088"
+	token = "abc"
+	sourcetype_prefix = ""
+	spool = true
+	`
+	cfg := Config{Spool: &SpoolConfig{Dir: t.TempDir()}}
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	assert.NoError(cfg.processEnvVariables())
+	assert.Equal("hec-secret", cfg.Splunk.Token)
+	sinks, err := cfg.GetSinks()
+	assert.NoError(err)
+	if !assert.Equal(2, len(sinks)) {
+		return
+	}
+	hec, ok := sinks[0].(*sink.Named).Sinker.(*sink.SplunkHECSink)
+	if assert.True(ok) {
+		assert.Equal("https://splunk:8088", hec.URL)
+		assert.Equal("security", hec.EventIndexMap["login"])
+		assert.Equal("sqlxe:", hec.SourceTypePrefix)
+		assert.True(hec.Ack)
+	}
+	assert.Equal("spool: soc", sinks[1].Name())
+}
+
+func TestSyslogSink(t *testing.T) {
+	assert := assert.New(t)
+	var c = `
+	[syslog]
+	address = "localhost:514"
+
+	[[sink.syslog]]
+	name = "siem"
+	network = "TLS"
+	address = "siem:6514"
+	facility = "local4"
+	format = "description"
+	`
+	var cfg Config
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	sinks, err := cfg.GetSinks()
+	assert.NoError(err)
+	if !assert.Equal(2, len(sinks)) {
+		return
+	}
+	ss, ok := sinks[0].(*sink.Named).Sinker.(*sink.SyslogSink)
+	if assert.True(ok) {
+		assert.Equal("udp", ss.Network)
+		assert.Equal(16, ss.Facility)
+		assert.Equal("json", ss.Format)
+	}
+	ss, ok = sinks[1].(*sink.Named).Sinker.(*sink.SyslogSink)
+	if assert.True(ok) {
+		assert.Equal("tls", ss.Network)
+		assert.Equal(20, ss.Facility)
+		assert.Equal("description", ss.Format)
+	}
+
+	cfg.Sink.Syslog[0].Extensions = map[string]string{"client_hostname": "shost"}
+	_, err = cfg.GetSinks()
+	assert.Error(err)
+	assert.Contains(err.Error(), "syslog-siem: extensions can't be used with format description")
+	cfg.Sink.Syslog[0].Extensions = nil
+
+	cfg.Sink.Syslog[0].Facility = "local9"
+	_, err = cfg.GetSinks()
+	assert.Error(err)
+	assert.Contains(err.Error(), "syslog-siem: invalid syslog facility: local9")
+}
+
+func TestOutputFormat(t *testing.T) {
+	assert := assert.New(t)
+	var c = `
+	[[sink.logstash]]
+	name = "qradar"
+	host = "qradar:5044"
+	format = "leef"
+
+	[[sink.syslog]]
+	name = "arcsight"
+	address = "arcsight:514"
+	format = "cef"
+	extensions = { server_principal_name = "duser", client_hostname = "" }
+	`
+	var cfg Config
+	_, err := toml.Decode(c, &cfg)
+	assert.NoError(err)
+	sinks, err := cfg.GetSinks()
+	assert.NoError(err)
+	if !assert.Equal(2, len(sinks)) {
+		return
+	}
+	_, ok := sinks[0].(*sink.Named).Sinker.(*sink.Formatted)
+	assert.True(ok)
+	ss, ok := sinks[1].(*sink.Named).Sinker.(*sink.SyslogSink)
+	if assert.True(ok) {
+		cef, ok := ss.Output.(*sink.CEFFormatter)
+		if assert.True(ok) {
+			assert.Equal("duser", cef.Extensions["server_principal_name"])
+			_, ok = cef.Extensions["client_hostname"]
+			assert.False(ok)
+		}
+	}
+
+	cfg.Sink.Logstash[0].Format = "xml"
+	_, err = cfg.GetSinks()
+	assert.Error(err)
+	assert.Contains(err.Error(), "logstash-qradar: invalid format: xml")
 }
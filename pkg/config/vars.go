@@ -73,5 +73,53 @@ func (cfg *Config) processEnvVariables() error {
 	if err != nil {
 		return errors.Wrap(err, "elastic.password")
 	}
+	for i := range cfg.Sink.Elastic {
+		ec := &cfg.Sink.Elastic[i]
+		ec.Username, err = setFromEnv(ec.Username)
+		if err != nil {
+			return errors.Wrapf(err, "sink.elastic[%s].username", ec.Name)
+		}
+		ec.Password, err = setFromEnv(ec.Password)
+		if err != nil {
+			return errors.Wrapf(err, "sink.elastic[%s].password", ec.Name)
+		}
+	}
+	if cfg.OTLP != nil {
+		err = headersFromEnv(cfg.OTLP.Headers)
+		if err != nil {
+			return errors.Wrap(err, "otlp.headers")
+		}
+	}
+	if cfg.Splunk != nil {
+		cfg.Splunk.Token, err = setFromEnv(cfg.Splunk.Token)
+		if err != nil {
+			return errors.Wrap(err, "splunk.token")
+		}
+	}
+	for i := range cfg.Sink.Splunk {
+		sc := &cfg.Sink.Splunk[i]
+		sc.Token, err = setFromEnv(sc.Token)
+		if err != nil {
+			return errors.Wrapf(err, "sink.splunk[%s].token", sc.Name)
+		}
+	}
+	for i := range cfg.Sink.OTLP {
+		err = headersFromEnv(cfg.Sink.OTLP[i].Headers)
+		if err != nil {
+			return errors.Wrapf(err, "sink.otlp[%s].headers", cfg.Sink.OTLP[i].Name)
+		}
+	}
+	return nil
+}
+
+// headersFromEnv replaces the values of HTTP headers with environment variables
+func headersFromEnv(headers map[string]string) error {
+	for k, v := range headers {
+		val, err := setFromEnv(v)
+		if err != nil {
+			return errors.Wrap(err, k)
+		}
+		headers[k] = val
+	}
 	return nil
 }
@@ -2,38 +2,71 @@ package eshelper
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"time"
 
-	"github.com/billgraziano/xelogstash/pkg/log"
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/pkg/errors"
 )
 
-// BulkResponse holds the response from Elastic
+// BulkResponse holds the response from Elastic.  Each item is keyed
+// by the action such as "index" or "create".
 type BulkResponse struct {
-	Errors bool `json:"errors"`
-	Items  []struct {
-		Index struct {
-			ID     string `json:"_id"`
-			Result string `json:"result"`
-			Status int    `json:"status"`
-			Error  struct {
-				Type   string `json:"type"`
-				Reason string `json:"reason"`
-				Cause  struct {
-					Type   string `json:"type"`
-					Reason string `json:"reason"`
-				} `json:"caused_by"`
-			} `json:"error"`
-		} `json:"index"`
-	} `json:"items"`
+	Errors bool                  `json:"errors"`
+	Items  []map[string]BulkItem `json:"items"`
+}
+
+// BulkItem holds the result for one document in a bulk request
+type BulkItem struct {
+	ID     string `json:"_id"`
+	Index  string `json:"_index"`
+	Result string `json:"result"`
+	Status int    `json:"status"`
+	Error  struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+		Cause  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"caused_by"`
+	} `json:"error"`
+}
+
+// Item returns the result for the document at position i in the request
+func (br BulkResponse) Item(i int) (BulkItem, bool) {
+	if i >= len(br.Items) {
+		return BulkItem{}, false
+	}
+	for _, item := range br.Items[i] {
+		return item, true
+	}
+	return BulkItem{}, false
+}
+
+// OK is true if the document was written
+func (bi BulkItem) OK() bool {
+	return bi.Status >= 200 && bi.Status < 300
+}
+
+// Err returns the error for a failed document
+func (bi BulkItem) Err() error {
+	msg := fmt.Sprintf("status: %d; %s: %s", bi.Status, bi.Error.Type, bi.Error.Reason)
+	if bi.Error.Cause.Type != "" {
+		msg += fmt.Sprintf(" (%s: %s)", bi.Error.Cause.Type, bi.Error.Cause.Reason)
+	}
+	return errors.New(msg)
+}
+
+// Retryable is true for a status that may succeed if the request is sent again
+func Retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
 }
 
 // NewClient creates a client given an http.Transport.  This is typically used for a proxy.
@@ -128,24 +161,22 @@ func CreateIndexes(es *elasticsearch.Client, indexes []string) error {
 	return nil
 }
 
-// WriteElasticBuffer sends the buffer to Elastic
-func WriteElasticBuffer(esclient *elasticsearch.Client, buf *bytes.Buffer) error {
-	if len(buf.Bytes()) == 0 {
-		return nil
-	}
-
-	res, err := esclient.Bulk(bytes.NewReader(buf.Bytes()))
+// Bulk sends a bulk request and parses the response.  It returns the
+// HTTP status so the caller can decide whether to retry.  A status of zero
+// means the request didn't get a response.
+func Bulk(ctx context.Context, esclient *elasticsearch.Client, body []byte) (BulkResponse, int, error) {
+	var br BulkResponse
+	res, err := esclient.Bulk(bytes.NewReader(body), esclient.Bulk.WithContext(ctx))
 	if err != nil {
-		return errors.Wrap(err, "failure indexing batch")
+		return br, 0, errors.Wrap(err, "es.bulk")
 	}
 	defer res.Body.Close()
 	if res.IsError() {
-		log.Error(fmt.Sprintf("buffer bytes: %d", len(buf.Bytes())))
-		log.Error(res.String())
-		log.Error(string(buf.String()))
-		return errors.New("res.iserror true writing to elastic")
+		return br, res.StatusCode, errors.Errorf("es.bulk: %s", res.String())
 	}
-	io.Copy(io.Discard, res.Body)
-	buf.Reset()
-	return nil
+	err = json.NewDecoder(res.Body).Decode(&br)
+	if err != nil {
+		return br, res.StatusCode, errors.Wrap(err, "es.bulk: decode")
+	}
+	return br, res.StatusCode, nil
 }
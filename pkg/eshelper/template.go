@@ -0,0 +1,87 @@
+package eshelper
+
+import (
+	"bytes"
+	_ "embed" // for the bundled component template
+	"encoding/json"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/pkg/errors"
+)
+
+// ComponentTemplate is the name of the bundled component template
+// with the mappings for the xe fields
+const ComponentTemplate = "sqlxewriter-mappings"
+
+//go:embed templates/sqlxewriter-mappings.json
+var componentTemplate []byte
+
+// IndexTemplate describes the index template the Elastic sink installs
+type IndexTemplate struct {
+	Name       string
+	Patterns   []string // index or data stream names
+	DataStream bool
+	ILMPolicy  string
+	Priority   int
+}
+
+// body returns the JSON for the PUT _index_template request
+func (it IndexTemplate) body() ([]byte, error) {
+	type settings struct {
+		Lifecycle string `json:"index.lifecycle.name,omitempty"`
+	}
+	t := struct {
+		IndexPatterns []string  `json:"index_patterns"`
+		DataStream    *struct{} `json:"data_stream,omitempty"`
+		ComposedOf    []string  `json:"composed_of"`
+		Priority      int       `json:"priority"`
+		Template      struct {
+			Settings *settings `json:"settings,omitempty"`
+		} `json:"template"`
+		Meta map[string]string `json:"_meta"`
+	}{
+		IndexPatterns: it.Patterns,
+		ComposedOf:    []string{ComponentTemplate},
+		Priority:      it.Priority,
+		Meta:          map[string]string{"managed_by": "sqlxewriter"},
+	}
+	if it.DataStream {
+		t.DataStream = &struct{}{}
+	}
+	if it.ILMPolicy != "" {
+		t.Template.Settings = &settings{Lifecycle: it.ILMPolicy}
+	}
+	return json.Marshal(t)
+}
+
+// InstallTemplates creates or replaces the bundled component template
+// and an index template that uses it.  Elastic applies these when it
+// creates an index or data stream that matches the patterns.
+func InstallTemplates(es *elasticsearch.Client, it IndexTemplate) error {
+	if len(it.Patterns) == 0 {
+		return errors.New("index template: no index patterns")
+	}
+	res, err := es.Cluster.PutComponentTemplate(ComponentTemplate, bytes.NewReader(componentTemplate))
+	if err != nil {
+		return errors.Wrap(err, "es.cluster.putcomponenttemplate")
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return errors.New(fmt.Sprintf("error creating component template [%s]: %s", ComponentTemplate, res.String()))
+	}
+
+	body, err := it.body()
+	if err != nil {
+		return errors.Wrap(err, "json.marshal")
+	}
+	res, err = es.Indices.PutIndexTemplate(it.Name, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "es.indices.putindextemplate")
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return errors.New(fmt.Sprintf("error creating index template [%s]: %s", it.Name, res.String()))
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type node interface {
+	eval(event map[string]interface{}) bool
+}
+
+// field is a field name.  A dotted name is looked up as is first and
+// then one map at a time so it works for flat and nested fields.
+type field struct {
+	name  string
+	parts []string
+}
+
+func newField(name string) field {
+	return field{name: name, parts: strings.Split(name, ".")}
+}
+
+func (f field) lookup(event map[string]interface{}) (interface{}, bool) {
+	v, ok := event[f.name]
+	if ok || len(f.parts) == 1 {
+		return v, ok
+	}
+	var cur interface{} = event
+	for _, part := range f.parts {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ n node }
+
+func (n andNode) eval(event map[string]interface{}) bool {
+	return n.left.eval(event) && n.right.eval(event)
+}
+
+func (n orNode) eval(event map[string]interface{}) bool {
+	return n.left.eval(event) || n.right.eval(event)
+}
+
+func (n notNode) eval(event map[string]interface{}) bool {
+	return !n.n.eval(event)
+}
+
+type existsNode struct{ f field }
+
+func (n existsNode) eval(event map[string]interface{}) bool {
+	_, ok := n.f.lookup(event)
+	return ok
+}
+
+// compareNode compares a field to a value.  A missing field is false for
+// every operator.  Values that can't be compared are never equal.
+type compareNode struct {
+	f     field
+	op    string
+	value interface{}
+}
+
+func (n compareNode) eval(event map[string]interface{}) bool {
+	v, ok := n.f.lookup(event)
+	if !ok {
+		return false
+	}
+	c, ok := compare(v, n.value)
+	if !ok {
+		return n.op == "!="
+	}
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+type inNode struct {
+	f      field
+	values []interface{}
+}
+
+func (n inNode) eval(event map[string]interface{}) bool {
+	v, ok := n.f.lookup(event)
+	if !ok {
+		return false
+	}
+	for _, value := range n.values {
+		if c, ok := compare(v, value); ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type matchNode struct {
+	f      field
+	re     *regexp.Regexp
+	negate bool
+}
+
+func (n matchNode) eval(event map[string]interface{}) bool {
+	v, ok := n.f.lookup(event)
+	if !ok {
+		return false
+	}
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	return n.re.MatchString(s) != n.negate
+}
+
+// number returns integers as int64 and floats as float64
+func number(v interface{}) (interface{}, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint8:
+		return int64(x), true
+	case uint16:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case uint64:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	// named integers such as logstash.Severity
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(rv.Uint()), true
+	}
+	return nil, false
+}
+
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+func sign(b, a bool) int {
+	if a {
+		return -1
+	}
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compare returns -1, 0 or 1 comparing an event value to a literal.  It
+// returns false if they are different types.
+func compare(v, literal interface{}) (int, bool) {
+	if lit, ok := number(literal); ok {
+		n, ok := number(v)
+		if !ok {
+			return 0, false
+		}
+		a, aok := n.(int64)
+		b, bok := lit.(int64)
+		if aok && bok {
+			return sign(a > b, a < b), true
+		}
+		x, y := toFloat(n), toFloat(lit)
+		return sign(x > y, x < y), true
+	}
+	switch lit := literal.(type) {
+	case string:
+		switch x := v.(type) {
+		case string:
+			return strings.Compare(x, lit), true
+		case time.Time:
+			t, err := time.Parse(time.RFC3339, lit)
+			if err != nil {
+				return 0, false
+			}
+			return sign(x.After(t), x.Before(t)), true
+		}
+	case bool:
+		if x, ok := v.(bool); ok {
+			if x == lit {
+				return 0, true
+			}
+			return sign(x, lit), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,89 @@
+// Package filter compiles the expressions that include or exclude events.
+//
+//	name == 'rpc_completed' and duration < 1s and database_name not in ('Sales', 'HR')
+//
+// Expressions support ==, !=, <, <=, >, >=, in, not in, =~ and !~ (regular
+// expressions), exists, and, or, not and parentheses.  Durations such as
+// 1s or 250ms are converted to microseconds.
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Include is the action to keep matching events
+	Include = "include"
+	// Exclude is the action to drop matching events
+	Exclude = "exclude"
+)
+
+// Rule is a compiled filter
+type Rule struct {
+	Action string
+	Where  string // the expression as written
+	expr   node
+}
+
+// New compiles a rule.  The fields are exact matches that are combined
+// with the expression using and.  This is the original format of a filter.
+func New(action, where string, fields map[string]interface{}) (Rule, error) {
+	r := Rule{Action: strings.ToLower(action), Where: where}
+	if r.Action != Include && r.Action != Exclude {
+		return r, fmt.Errorf("filter_action must be include or exclude: '%s'", action)
+	}
+	if strings.TrimSpace(where) != "" {
+		n, err := parse(where)
+		if err != nil {
+			return r, errors.Wrap(err, "where")
+		}
+		r.expr = n
+	}
+
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := fields[k]
+		if _, ok := number(v); !ok {
+			switch v.(type) {
+			case string, bool:
+			default:
+				return r, fmt.Errorf("field %s: unsupported value: %v (%T)", k, v, v)
+			}
+		}
+		var n node = compareNode{f: field{name: k, parts: []string{k}}, op: "==", value: v}
+		if r.expr != nil {
+			n = andNode{r.expr, n}
+		}
+		r.expr = n
+	}
+	return r, nil
+}
+
+// Match reports whether an event matches the rule.  A rule without
+// fields or an expression matches every event.
+func (r Rule) Match(event map[string]interface{}) bool {
+	if r.expr == nil {
+		return true
+	}
+	return r.expr.eval(event)
+}
+
+// Apply returns the action of the last rule that matches the event.  It
+// returns Include if no rule matches.
+func Apply(rules []Rule, event map[string]interface{}) string {
+	action := Include
+	for _, r := range rules {
+		if r.Match(event) {
+			action = r.Action
+		}
+	}
+	return action
+}
@@ -0,0 +1,125 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func event() map[string]interface{} {
+	return map[string]interface{}{
+		"name":          "rpc_completed",
+		"duration":      int64(250000),
+		"cpu_time":      int64(1500),
+		"database_name": "Sales",
+		"statement":     "exec dbo.GetOrder @id = 7",
+		"is_system":     false,
+		"timestamp":     time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC),
+		"global":        map[string]interface{}{"host": map[string]interface{}{"name": "D40"}},
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{"name == 'rpc_completed'", true},
+		{`name == "rpc_completed"`, true},
+		{"name != 'rpc_completed'", false},
+		{"duration < 1s", true},
+		{"duration >= 250ms", true},
+		{"duration > 250ms", false},
+		{"cpu_time <= 1500.0", true},
+		{"database_name in ('HR', 'Sales')", true},
+		{"database_name not in ('HR', 'Sales')", false},
+		{"statement =~ '^exec\\s+dbo\\.'", true},
+		{"statement !~ 'GetOrder'", false},
+		{"error_number exists", false},
+		{"not error_number exists", true},
+		{"error_number == 1", false},
+		{"error_number != 1", false},
+		{"database_name == 1", false},
+		{"database_name != 1", true},
+		{"is_system == false", true},
+		{"timestamp > '2026-03-09T11:00:00Z'", true},
+		{"global.host.name == 'D40'", true},
+		{"global.host.missing exists", false},
+		{"name == 'rpc_completed' and duration < 1s and not database_name in ('Sales')", false},
+		{"NAME == 'rpc_completed'", false},
+		{"name == 'y' OR name == 'rpc_completed'", true},
+		{"(name == 'x' or name == 'rpc_completed') and cpu_time > 1000", true},
+		{"name == 'x' or name == 'rpc_completed' and cpu_time > 5000", false},
+	}
+	for _, tc := range tests {
+		r, err := New(Exclude, tc.where, nil)
+		if !assert.NoError(err, tc.where) {
+			continue
+		}
+		assert.Equal(tc.want, r.Match(event()), tc.where)
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		where     string
+		line, col int
+	}{
+		{"name = 'x'", 1, 6},
+		{"name == ", 1, 9},
+		{"name == 'x' and", 1, 16},
+		{"name == 'x'\n  and duration <> 5", 2, 17},
+		{"name == 'x'\nor statement =~ '('", 2, 17},
+		{"database_name in ('a' 'b')", 1, 23},
+		{"(name == 'x'", 1, 13},
+		{"name == 'x", 1, 9},
+		{"duration < 5parsecs", 1, 12},
+		{"name == 'x' extra", 1, 13},
+		{"'x' == name", 1, 1},
+		{"", 1, 1},
+	}
+	for _, tc := range tests {
+		_, err := parse(tc.where)
+		if !assert.Error(err, tc.where) {
+			continue
+		}
+		var e *Error
+		if !assert.True(errors.As(err, &e), tc.where) {
+			continue
+		}
+		assert.Equal(tc.line, e.Line, "line: %s: %s", tc.where, e)
+		assert.Equal(tc.col, e.Col, "col: %s: %s", tc.where, e)
+	}
+}
+
+func TestRules(t *testing.T) {
+	assert := assert.New(t)
+
+	// the original exact match filters
+	r1, err := New(Exclude, "", map[string]interface{}{"name": "rpc_completed"})
+	assert.NoError(err)
+	r2, err := New(Include, "", map[string]interface{}{"name": "rpc_completed", "database_name": "Sales"})
+	assert.NoError(err)
+	assert.Equal(Include, Apply([]Rule{r1, r2}, event()))
+	assert.Equal(Exclude, Apply([]Rule{r2, r1}, event()))
+	assert.Equal(Include, Apply(nil, event()))
+
+	// fields and where together
+	r3, err := New("EXCLUDE", "duration < 1s", map[string]interface{}{"name": "rpc_completed", "cpu_time": int64(1500)})
+	assert.NoError(err)
+	assert.Equal(Exclude, Apply([]Rule{r3}, event()))
+
+	// no fields matches everything
+	all, err := New(Exclude, "", nil)
+	assert.NoError(err)
+	assert.True(all.Match(map[string]interface{}{}))
+
+	_, err = New("drop", "name == 'x'", nil)
+	assert.Error(err)
+	_, err = New(Exclude, "", map[string]interface{}{"name": []interface{}{"a"}})
+	assert.Error(err)
+}
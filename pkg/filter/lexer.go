@@ -0,0 +1,190 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tEOF tokenKind = iota
+	tIdent
+	tString
+	tNumber
+	tOp
+	tLParen
+	tRParen
+	tComma
+)
+
+type token struct {
+	kind  tokenKind
+	text  string      // the identifier, operator or string contents
+	value interface{} // int64 or float64 for numbers
+	line  int
+	col   int
+}
+
+// Error is a syntax error in an expression.  Line and Col start at 1.
+type Error struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d, col %d: %s", e.Line, e.Col, e.Msg)
+}
+
+type lexer struct {
+	src  []rune
+	pos  int
+	line int
+	col  int
+}
+
+func (l *lexer) errorf(line, col int, format string, args ...interface{}) error {
+	return &Error{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) peek() rune {
+	if l.pos >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos]
+}
+
+func (l *lexer) next() rune {
+	r := l.src[l.pos]
+	l.pos++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '@' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokens splits an expression into tokens
+func tokens(src string) ([]token, error) {
+	l := &lexer{src: []rune(src), line: 1, col: 1}
+	var toks []token
+	for {
+		for l.pos < len(l.src) && unicode.IsSpace(l.peek()) {
+			l.next()
+		}
+		line, col := l.line, l.col
+		if l.pos >= len(l.src) {
+			toks = append(toks, token{kind: tEOF, line: line, col: col})
+			return toks, nil
+		}
+		r := l.peek()
+		switch {
+		case r == '(':
+			l.next()
+			toks = append(toks, token{kind: tLParen, text: "(", line: line, col: col})
+		case r == ')':
+			l.next()
+			toks = append(toks, token{kind: tRParen, text: ")", line: line, col: col})
+		case r == ',':
+			l.next()
+			toks = append(toks, token{kind: tComma, text: ",", line: line, col: col})
+		case r == '\'' || r == '"':
+			s, err := l.str()
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tString, text: s, line: line, col: col})
+		case r == '-' || unicode.IsDigit(r):
+			t, err := l.number()
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, t)
+		case isIdentStart(r):
+			var sb strings.Builder
+			for l.pos < len(l.src) && isIdentPart(l.peek()) {
+				sb.WriteRune(l.next())
+			}
+			ident := sb.String()
+			if strings.HasSuffix(ident, ".") || strings.Contains(ident, "..") {
+				return nil, l.errorf(line, col, "invalid field: %s", ident)
+			}
+			toks = append(toks, token{kind: tIdent, text: ident, line: line, col: col})
+		case strings.ContainsRune("=!<>", r):
+			l.next()
+			op := string(r)
+			if n := l.peek(); n == '=' || (n == '~' && (r == '=' || r == '!')) {
+				op += string(l.next())
+			}
+			switch op {
+			case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+			default:
+				return nil, l.errorf(line, col, "unknown operator: %s", op)
+			}
+			toks = append(toks, token{kind: tOp, text: op, line: line, col: col})
+		default:
+			return nil, l.errorf(line, col, "unexpected character: %q", r)
+		}
+	}
+}
+
+// str reads a quoted string.  A backslash only escapes the quote or
+// another backslash so regular expressions don't need doubled slashes.
+func (l *lexer) str() (string, error) {
+	line, col := l.line, l.col
+	quote := l.next()
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return "", l.errorf(line, col, "unterminated string")
+		}
+		r := l.next()
+		if r == quote {
+			return sb.String(), nil
+		}
+		if r == '\\' && l.pos < len(l.src) && (l.peek() == quote || l.peek() == '\\') {
+			r = l.next()
+		}
+		sb.WriteRune(r)
+	}
+}
+
+// number reads an integer, a float or a duration like 1s or 250ms.
+// Durations are converted to microseconds.
+func (l *lexer) number() (token, error) {
+	line, col := l.line, l.col
+	var sb strings.Builder
+	sb.WriteRune(l.next())
+	for l.pos < len(l.src) && (l.peek() == '.' || l.peek() == 'µ' || unicode.IsLetter(l.peek()) || unicode.IsDigit(l.peek())) {
+		sb.WriteRune(l.next())
+	}
+	text := sb.String()
+	t := token{kind: tNumber, text: text, line: line, col: col}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		t.value = i
+		return t, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		t.value = f
+		return t, nil
+	}
+	if d, err := time.ParseDuration(text); err == nil {
+		t.value = d.Microseconds()
+		return t, nil
+	}
+	return t, l.errorf(line, col, "invalid number: %s", text)
+}
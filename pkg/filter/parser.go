@@ -0,0 +1,200 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &Error{Line: t.line, Col: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// keyword reports whether the next token is the keyword
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tIdent && strings.EqualFold(t.text, kw)
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tEOF:
+		return "end of expression"
+	case tString:
+		return fmt.Sprintf("string '%s'", t.text)
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// parse compiles an expression
+func parse(src string) (node, error) {
+	toks, err := tokens(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	if p.peek().kind == tEOF {
+		return nil, p.errorf(p.peek(), "empty expression")
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tEOF {
+		return nil, p.errorf(t, "unexpected %s", describe(t))
+	}
+	return n, nil
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.next()
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (node, error) {
+	if p.keyword("not") {
+		p.next()
+		n, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.term()
+}
+
+var keywords = map[string]bool{"and": true, "or": true, "not": true, "in": true, "exists": true, "true": true, "false": true}
+
+// term is a parenthesized expression or a test of one field
+func (p *parser) term() (node, error) {
+	t := p.next()
+	if t.kind == tLParen {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tRParen {
+			return nil, p.errorf(c, "expected ')' but found %s", describe(c))
+		}
+		return n, nil
+	}
+	if t.kind != tIdent || keywords[strings.ToLower(t.text)] {
+		return nil, p.errorf(t, "expected a field but found %s", describe(t))
+	}
+	f := newField(t.text)
+
+	op := p.next()
+	switch {
+	case op.kind == tIdent && strings.EqualFold(op.text, "exists"):
+		return existsNode{f}, nil
+	case op.kind == tIdent && strings.EqualFold(op.text, "in"):
+		return p.in(f)
+	case op.kind == tIdent && strings.EqualFold(op.text, "not"):
+		if !p.keyword("in") {
+			return nil, p.errorf(p.peek(), "expected 'in' after 'not' but found %s", describe(p.peek()))
+		}
+		p.next()
+		n, err := p.in(f)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case op.kind == tOp && (op.text == "=~" || op.text == "!~"):
+		v := p.next()
+		if v.kind != tString {
+			return nil, p.errorf(v, "expected a regular expression string but found %s", describe(v))
+		}
+		re, err := regexp.Compile(v.text)
+		if err != nil {
+			return nil, p.errorf(v, "invalid regular expression: %s", err)
+		}
+		return matchNode{f, re, op.text == "!~"}, nil
+	case op.kind == tOp:
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{f, op.text, v}, nil
+	}
+	return nil, p.errorf(op, "expected an operator after %s but found %s", t.text, describe(op))
+}
+
+// in reads the list of values after in
+func (p *parser) in(f field) (node, error) {
+	if t := p.next(); t.kind != tLParen {
+		return nil, p.errorf(t, "expected '(' after 'in' but found %s", describe(t))
+	}
+	var values []interface{}
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		t := p.next()
+		if t.kind == tRParen {
+			return inNode{f, values}, nil
+		}
+		if t.kind != tComma {
+			return nil, p.errorf(t, "expected ',' or ')' but found %s", describe(t))
+		}
+	}
+}
+
+// value reads a string, number, duration, true or false
+func (p *parser) value() (interface{}, error) {
+	t := p.next()
+	switch {
+	case t.kind == tString:
+		return t.text, nil
+	case t.kind == tNumber:
+		return t.value, nil
+	case t.kind == tIdent && strings.EqualFold(t.text, "true"):
+		return true, nil
+	case t.kind == tIdent && strings.EqualFold(t.text, "false"):
+		return false, nil
+	}
+	return nil, p.errorf(t, "expected a value but found %s", describe(t))
+}
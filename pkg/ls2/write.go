@@ -1,6 +1,7 @@
 package ls2
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -8,7 +9,15 @@ import (
 	"github.com/pkg/errors"
 )
 
+// Write writes a message.  A failed write is retried until it succeeds
+// or the context of the Logstash is done.
 func (ls *Logstash) Write(message string) error {
+	return ls.WriteContext(ls.ctx, message)
+}
+
+// WriteContext writes a message.  A failed write is retried until it
+// succeeds or ctx is done.  It returns an error if the message wasn't written.
+func (ls *Logstash) WriteContext(ctx context.Context, message string) error {
 	var err error
 	ls.RLock()
 	err = ls.write(message)
@@ -21,7 +30,7 @@ func (ls *Logstash) Write(message string) error {
 	// do the retry stuff
 	ls.Lock()
 	defer ls.Unlock()
-	return ls.writeloop(message)
+	return ls.writeloop(ctx, message)
 }
 
 func (ls *Logstash) write(message string) error {
@@ -49,7 +58,7 @@ func (ls *Logstash) write(message string) error {
 	return nil
 }
 
-func (ls *Logstash) writeloop(message string) error {
+func (ls *Logstash) writeloop(ctx context.Context, message string) error {
 	var err error
 	// first, try the write again in case someone else fixed it
 	err = ls.write(message)
@@ -67,7 +76,7 @@ func (ls *Logstash) writeloop(message string) error {
 	)
 
 	i := 0
-	bo, cancel := policy.Start(ls.ctx)
+	bo, cancel := policy.Start(ctx)
 	defer cancel()
 	for backoff.Continue(bo) {
 		i++
@@ -90,9 +99,12 @@ func (ls *Logstash) writeloop(message string) error {
 			ls.Logger.Error(errors.Wrap(err, "writeloop: ls.write"))
 		} else { // write with no error means we break out of the loop
 			ls.Logger.Infof("writeloop: write succeeded: %s", ls.Host)
-			break
+			return nil
 		}
 
 	}
-	return nil
+	if ctx.Err() != nil {
+		return errors.Wrap(ctx.Err(), "writeloop")
+	}
+	return errors.Wrap(err, "writeloop")
 }
@@ -32,12 +32,142 @@ var (
 		},
 		[]string{"event", "domain", "server"},
 	)
+
+	SinkEventsWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sqlxewriter_sink_write_total",
+			Help: "Events confirmed by a sink",
+		},
+		[]string{"sink"},
+	)
+
+	SinkWriteErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sqlxewriter_sink_error_total",
+			Help: "Events a sink failed to confirm",
+		},
+		[]string{"sink"},
+	)
+
+	SpoolDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sqlxewriter_spool_depth",
+			Help: "Events in the spool waiting to be written to a sink",
+		},
+		[]string{"sink"},
+	)
+
+	SpoolBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sqlxewriter_spool_bytes",
+			Help: "Disk used by the spool segment files",
+		},
+		[]string{"sink"},
+	)
+
+	SpoolAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sqlxewriter_spool_age_seconds",
+			Help: "Age of the oldest event in the spool",
+		},
+		[]string{"sink"},
+	)
+
+	EventLag = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sqlxewriter_event_lag_seconds",
+			Help:    "Time from the event timestamp until the sinks confirmed the event",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 14400, 86400},
+		},
+		[]string{"domain", "server"},
+	)
+
+	EventLastTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sqlxewriter_event_last_timestamp_seconds",
+			Help: "Timestamp of the newest event the sinks confirmed",
+		},
+		[]string{"domain", "server", "session"},
+	)
+
+	PollLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sqlxewriter_poll_last_success_timestamp_seconds",
+			Help: "When the last poll of a session without an error started",
+		},
+		[]string{"domain", "server", "session"},
+	)
+
+	PollDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sqlxewriter_poll_duration_seconds",
+			Help:    "How long a poll of a session took",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+		},
+		[]string{"domain", "server", "session"},
+	)
+
+	PollRows = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sqlxewriter_poll_rows",
+			Help:    "Events read by a poll of a session",
+			Buckets: []float64{0, 1, 10, 100, 1000, 10000, 100000, 1000000},
+		},
+		[]string{"domain", "server", "session"},
+	)
+
+	PollErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sqlxewriter_poll_error_total",
+			Help: "Polls of a session that failed",
+		},
+		[]string{"domain", "server", "session"},
+	)
+
+	SinkWriteDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sqlxewriter_sink_write_duration_seconds",
+			Help:    "How long a sink took to confirm a batch of events",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"sink"},
+	)
+
+	SinkBatchErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sqlxewriter_sink_batch_error_total",
+			Help: "Batches a sink failed to confirm completely",
+		},
+		[]string{"sink"},
+	)
+
+	PollLateness = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "sqlxewriter_poll_lateness_seconds",
+			Help:    "How long after it was due that a poll of a source started",
+			Buckets: []float64{0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(EventsRead)
 	prometheus.MustRegister(EventsWritten)
 	prometheus.MustRegister(BytesWritten)
+	prometheus.MustRegister(SinkEventsWritten)
+	prometheus.MustRegister(SinkWriteErrors)
+	prometheus.MustRegister(SpoolDepth)
+	prometheus.MustRegister(SpoolBytes)
+	prometheus.MustRegister(SpoolAge)
+	prometheus.MustRegister(PollLateness)
+	prometheus.MustRegister(EventLag)
+	prometheus.MustRegister(EventLastTimestamp)
+	prometheus.MustRegister(PollLastSuccess)
+	prometheus.MustRegister(PollDuration)
+	prometheus.MustRegister(PollRows)
+	prometheus.MustRegister(PollErrors)
+	prometheus.MustRegister(SinkWriteDuration)
+	prometheus.MustRegister(SinkBatchErrors)
 }
 
 // ServerLabel accepts @@SERVERNAME in COMPUTER[\\INSTANCE]
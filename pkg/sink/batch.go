@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Event is one event to write to a sink
+type Event struct {
+	Name    string    // the event name such as login or error_reported
+	Payload string    // the JSON for the event
+	Time    time.Time // the event timestamp for the lag metrics
+
+	// Fields are the event before adds, copies and moves.  Route uses
+	// them to pick the sinks for the event.
+	Fields map[string]interface{}
+}
+
+// WriteEach writes each event, then flushes the sink.  It is the WriteBatch
+// for sinks that have no delivery confirmation beyond a successful write.
+// A failed write rejects that event and every event after it.
+// A failed flush rejects every event.
+func WriteEach(ctx context.Context, s Sinker, events []Event) []error {
+	for i, e := range events {
+		_, err := s.Write(ctx, e.Name, e.Payload)
+		if err != nil {
+			return Reject(events, i, errors.Wrap(err, "write"))
+		}
+	}
+	err := s.Flush()
+	if err != nil {
+		return Reject(events, 0, errors.Wrap(err, "flush"))
+	}
+	return nil
+}
+
+// Reject returns the errors for a batch where every event starting at first failed with err
+func Reject(events []Event, first int, err error) []error {
+	errs := make([]error, len(events))
+	for i := first; i < len(events); i++ {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Delivered returns how many of the n events at the start of a batch were
+// confirmed and the first error.  A nil errs means all n were confirmed.
+func Delivered(n int, errs []error) (int, error) {
+	for i, err := range errs {
+		if err != nil && i < n {
+			return i, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,31 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelivered(t *testing.T) {
+	assert := assert.New(t)
+	events := []Event{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+
+	n, err := Delivered(len(events), nil)
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	n, err = Delivered(len(events), Reject(events, 1, errors.New("nack")))
+	assert.Error(err)
+	assert.Equal(1, n)
+
+	mem := &memSink{}
+	assert.Nil(WriteEach(context.Background(), mem, events))
+	assert.Equal(3, mem.count())
+
+	mem.setFail(true)
+	n, err = Delivered(len(events), WriteEach(context.Background(), mem, events))
+	assert.Error(err)
+	assert.Equal(0, n)
+}
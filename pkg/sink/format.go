@@ -0,0 +1,330 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+// Formatter writes the JSON for an event in the format a sink sends
+type Formatter interface {
+	Format(name, event string) (string, error)
+}
+
+// Output formats
+const (
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+)
+
+// DefaultCEFExtensions maps event fields to CEF extension keys
+var DefaultCEFExtensions = map[string]string{
+	"mssql_server_name":     "dvchost",
+	"server_principal_name": "suser",
+	"client_hostname":       "shost",
+	"client_app_name":       "requestClientApplication",
+	"database_name":         "cs1",
+	"error_number":          "cn1",
+	"session_id":            "spid",
+	"action_name":           "act",
+	"xe_category":           "cat",
+	"xe_description":        "msg",
+}
+
+// DefaultLEEFExtensions maps event fields to LEEF attributes.  Fields
+// without a LEEF attribute keep their name.
+var DefaultLEEFExtensions = map[string]string{
+	"mssql_server_name":     "mssql_server_name",
+	"server_principal_name": "usrName",
+	"client_hostname":       "identHostName",
+	"client_app_name":       "client_app_name",
+	"database_name":         "database_name",
+	"error_number":          "error_number",
+	"session_id":            "session_id",
+	"action_name":           "action_name",
+	"xe_category":           "cat",
+	"xe_description":        "xe_description",
+}
+
+// NewFormatter returns the formatter for json, cef or leef.  The
+// extensions are added to the default mappings of field to key.  A field
+// mapped to an empty key is left out.
+func NewFormatter(format string, extensions map[string]string) (Formatter, error) {
+	var defaults map[string]string
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		return JSONFormatter{}, nil
+	case FormatCEF:
+		defaults = DefaultCEFExtensions
+	case FormatLEEF:
+		defaults = DefaultLEEFExtensions
+	default:
+		return nil, fmt.Errorf("invalid format: %s", format)
+	}
+	m := make(map[string]string)
+	for k, v := range defaults {
+		m[k] = v
+	}
+	for k, v := range extensions {
+		if v != "" && !extensionKey.MatchString(v) {
+			return nil, fmt.Errorf("invalid extension key: %s: %s", k, v)
+		}
+		if v == "" {
+			delete(m, k)
+			continue
+		}
+		m[k] = v
+	}
+	if strings.EqualFold(format, FormatLEEF) {
+		return &LEEFFormatter{Extensions: m}, nil
+	}
+	return &CEFFormatter{Extensions: m}, nil
+}
+
+var extensionKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// JSONFormatter writes the event as is
+type JSONFormatter struct{}
+
+// Format returns the event
+func (JSONFormatter) Format(_, event string) (string, error) {
+	return event, nil
+}
+
+// CEFFormatter writes ArcSight Common Event Format
+type CEFFormatter struct {
+	Extensions map[string]string // event field to extension key
+}
+
+// Format returns the event as CEF
+func (cf *CEFFormatter) Format(name, event string) (string, error) {
+	if !gjson.Valid(event) {
+		return "", errors.New("cef: invalid json")
+	}
+	sg := signature(name, event)
+	var sb strings.Builder
+	sb.WriteString("CEF:0|Microsoft|SQL Server|")
+	sb.WriteString(cefHeader(gjson.Get(event, "mssql_product_version").String()))
+	sb.WriteString("|")
+	sb.WriteString(cefHeader(sg.id))
+	sb.WriteString("|")
+	sb.WriteString(cefHeader(sg.name))
+	sb.WriteString("|")
+	sb.WriteString(strconv.Itoa(sg.severity))
+	sb.WriteString("|")
+	ext := make([]string, 0)
+	if ts, ok := eventTime(event); ok {
+		ext = append(ext, "rt="+strconv.FormatInt(ts.UnixMilli(), 10))
+	}
+	for _, kv := range extensions(event, cf.Extensions) {
+		ext = append(ext, kv.key+"="+cefValue(kv.value))
+		if cefLabel.MatchString(kv.key) {
+			ext = append(ext, kv.key+"Label="+cefValue(kv.field))
+		}
+	}
+	sb.WriteString(strings.Join(ext, " "))
+	return sb.String(), nil
+}
+
+// cefLabel matches the custom keys that take a label
+var cefLabel = regexp.MustCompile(`^(cs[1-6]|cn[1-3]|cfp[1-4]|flexString[12]|flexNumber[12])$`)
+
+var cefHeaderEscape = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+var cefValueEscape = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+func cefHeader(s string) string {
+	return cefHeaderEscape.Replace(s)
+}
+
+func cefValue(s string) string {
+	return cefValueEscape.Replace(s)
+}
+
+// LEEFFormatter writes IBM QRadar Log Event Extended Format 1.0
+type LEEFFormatter struct {
+	Extensions map[string]string // event field to attribute
+}
+
+// Format returns the event as LEEF
+func (lf *LEEFFormatter) Format(name, event string) (string, error) {
+	if !gjson.Valid(event) {
+		return "", errors.New("leef: invalid json")
+	}
+	sg := signature(name, event)
+	var sb strings.Builder
+	sb.WriteString("LEEF:1.0|Microsoft|SQL Server|")
+	sb.WriteString(leefHeader(gjson.Get(event, "mssql_product_version").String()))
+	sb.WriteString("|")
+	sb.WriteString(leefHeader(sg.id))
+	sb.WriteString("|")
+	attrs := make([]string, 0)
+	if ts, ok := eventTime(event); ok {
+		attrs = append(attrs, "devTime="+ts.UTC().Format("2006-01-02T15:04:05.000Z"))
+		attrs = append(attrs, "devTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSX")
+	}
+	attrs = append(attrs, "sev="+strconv.Itoa(sg.severity))
+	attrs = append(attrs, "eventName="+leefValue(sg.name))
+	for _, kv := range extensions(event, lf.Extensions) {
+		attrs = append(attrs, kv.key+"="+leefValue(kv.value))
+	}
+	sb.WriteString(strings.Join(attrs, "\t"))
+	return sb.String(), nil
+}
+
+var leefHeaderEscape = strings.NewReplacer(`|`, `\|`, "\t", " ", "\r", " ", "\n", " ")
+var leefValueEscape = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+func leefHeader(s string) string {
+	return leefHeaderEscape.Replace(s)
+}
+
+func leefValue(s string) string {
+	return leefValueEscape.Replace(s)
+}
+
+// sig is the signature ID, name and severity (0 to 10) of an event
+type sig struct {
+	id       string
+	name     string
+	severity int
+}
+
+// signature picks the signature for an event.  Audit events use the
+// action_id.  Errors use the error number.  Other events use the event
+// name.  Failed logins from error 18456, the login errors and the LGIF
+// audit action are named "Login failed".
+func signature(name, event string) sig {
+	s := sig{id: name, name: name, severity: siemSeverity(event)}
+	if s.id == "" {
+		s.id = gjson.Get(event, "name").String()
+		s.name = s.id
+	}
+	errnum := gjson.Get(event, "error_number")
+	action := gjson.Get(event, "action_id").String()
+	switch {
+	case action != "":
+		s.id = "audit:" + action
+		if an := gjson.Get(event, "action_name").String(); an != "" {
+			s.name = an
+		}
+	case errnum.Exists():
+		s.id = strconv.FormatInt(errnum.Int(), 10)
+	}
+	if action == "LGIF" || errnum.Int() == 18456 || gjson.Get(event, "login_failed").Exists() {
+		s.name = "Login failed"
+		if s.severity < 7 {
+			s.severity = 7
+		}
+	}
+	return s
+}
+
+// siemSeverity maps xe_severity_value to the 0 to 10 scale of CEF and LEEF
+func siemSeverity(event string) int {
+	v := gjson.Get(event, "xe_severity_value")
+	if !v.Exists() {
+		return 3
+	}
+	switch n := v.Int(); {
+	case n <= 2:
+		return 10
+	case n == 3:
+		return 7
+	case n == 4:
+		return 5
+	case n == 5:
+		return 4
+	default:
+		return 3
+	}
+}
+
+// eventTime returns the timestamp field of an event
+func eventTime(event string) (time.Time, bool) {
+	ts, err := time.Parse(time.RFC3339Nano, gjson.Get(event, "timestamp").String())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+type extension struct {
+	field string
+	key   string
+	value string
+}
+
+// extensions returns the mapped fields of an event sorted by key
+func extensions(event string, m map[string]string) []extension {
+	list := make([]extension, 0, len(m))
+	for field, key := range m {
+		v := gjson.Get(event, gjson.Escape(field))
+		if !v.Exists() || v.Type == gjson.Null {
+			continue
+		}
+		value := v.String()
+		if v.IsObject() || v.IsArray() {
+			value = v.Raw
+		}
+		list = append(list, extension{field: field, key: key, value: value})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].key == list[j].key {
+			return list[i].field < list[j].field
+		}
+		return list[i].key < list[j].key
+	})
+	return list
+}
+
+// Formatted wraps a sink so it writes events with a Formatter instead of
+// as JSON
+type Formatted struct {
+	Sinker
+	formatter Formatter
+}
+
+// NewFormatted returns the sink wrapped with the formatter.  It returns
+// the sink as is for JSON.
+func NewFormatted(snk Sinker, f Formatter) Sinker {
+	if _, ok := f.(JSONFormatter); ok || f == nil {
+		return snk
+	}
+	return &Formatted{Sinker: snk, formatter: f}
+}
+
+// Write formats and writes one event
+func (fs *Formatted) Write(ctx context.Context, name, event string) (int, error) {
+	s, err := fs.formatter.Format(name, event)
+	if err != nil {
+		return 0, err
+	}
+	return fs.Sinker.Write(ctx, name, s)
+}
+
+// WriteBatch formats the events and writes them.  An event that can't be
+// formatted rejects it and every event after it.
+func (fs *Formatted) WriteBatch(ctx context.Context, events []Event) []error {
+	formatted := make([]Event, len(events))
+	for i, ev := range events {
+		s, err := fs.formatter.Format(ev.Name, ev.Payload)
+		if err != nil {
+			errs := Reject(events, i, err)
+			if i > 0 {
+				copy(errs, fs.Sinker.WriteBatch(ctx, formatted[:i]))
+			}
+			return errs
+		}
+		ev.Payload = s
+		formatted[i] = ev
+	}
+	return fs.Sinker.WriteBatch(ctx, formatted)
+}
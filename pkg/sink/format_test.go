@@ -0,0 +1,99 @@
+package sink
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCEF(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFormatter("CEF", map[string]string{"client_hostname": "", "statement": "cs2"})
+	if !assert.NoError(err) {
+		return
+	}
+	event := `{"timestamp":"2024-03-01T10:11:12.123Z","mssql_server_name":"D40\\SQL2019","mssql_product_version":"15.0.4123.1","error_number":18456,"xe_severity_value":6,"server_principal_name":"sa","client_hostname":"ws01","xe_description":"Login failed for user 'sa'. Reason: a=b|c","statement":"SELECT 1\nGO"}`
+	s, err := f.Format("error_reported", event)
+	assert.NoError(err)
+	assert.Equal(`CEF:0|Microsoft|SQL Server|15.0.4123.1|18456|Login failed|7|rt=1709287872123 cn1=18456 cn1Label=error_number cs2=SELECT 1\nGO cs2Label=statement dvchost=D40\\SQL2019 msg=Login failed for user 'sa'. Reason: a\=b|c suser=sa`, s)
+
+	_, err = f.Format("x", "not json")
+	assert.Error(err)
+}
+
+func TestLEEF(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFormatter("leef", nil)
+	if !assert.NoError(err) {
+		return
+	}
+	event := `{"timestamp":"2024-03-01T10:11:12.123-05:00","action_id":"LGIF","action_name":"LOGIN FAILED","succeeded":false,"xe_severity_value":4,"server_principal_name":"sa","client_hostname":"ws01","xe_category":"audit"}`
+	s, err := f.Format("audit", event)
+	assert.NoError(err)
+	assert.Equal("LEEF:1.0|Microsoft|SQL Server||audit:LGIF|devTime=2024-03-01T15:11:12.123Z\tdevTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSX\tsev=7\teventName=Login failed\taction_name=LOGIN FAILED\tcat=audit\tidentHostName=ws01\tusrName=sa", s)
+}
+
+func TestSignature(t *testing.T) {
+	assert := assert.New(t)
+	type test struct {
+		name  string
+		event string
+		id    string
+		title string
+		sev   int
+	}
+	tests := []test{
+		{"login", `{"xe_severity_value":6}`, "login", "login", 3},
+		{"error_reported", `{"error_number":1205,"xe_severity_value":3}`, "1205", "error_reported", 7},
+		{"error_reported", `{"error_number":18452,"login_failed":"Login failed","xe_severity_value":3}`, "18452", "Login failed", 7},
+		{"audit", `{"action_id":"SL","action_name":"SELECT","xe_severity_value":6}`, "audit:SL", "SELECT", 3},
+		{"", `{"name":"deadlock","xe_severity_value":2}`, "deadlock", "deadlock", 10},
+	}
+	for _, tc := range tests {
+		sg := signature(tc.name, tc.event)
+		assert.Equal(tc.id, sg.id, tc.event)
+		assert.Equal(tc.title, sg.name, tc.event)
+		assert.Equal(tc.sev, sg.severity, tc.event)
+	}
+}
+
+func TestNewFormatter(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFormatter("", nil)
+	assert.NoError(err)
+	assert.Equal(JSONFormatter{}, f)
+	_, err = NewFormatter("xml", nil)
+	assert.Error(err)
+	_, err = NewFormatter("cef", map[string]string{"client_hostname": "s host"})
+	assert.Error(err)
+
+	// JSON doesn't wrap the sink
+	mem := &memSink{}
+	assert.Equal(mem, NewFormatted(mem, f))
+}
+
+func TestFormatted(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFormatter("cef", nil)
+	if !assert.NoError(err) {
+		return
+	}
+	mem := &memSink{}
+	fs := NewFormatted(mem, f)
+	errs := fs.WriteBatch(context.Background(), []Event{
+		{Name: "login", Payload: `{"server_principal_name":"sa"}`},
+		{Name: "bad", Payload: `{`},
+		{Name: "login", Payload: `{}`},
+	})
+	if assert.Equal(3, len(errs)) {
+		assert.NoError(errs[0])
+		assert.Error(errs[1])
+		assert.Error(errs[2])
+	}
+	if assert.Equal(1, mem.count()) {
+		assert.True(strings.HasPrefix(mem.get(0), "CEF:0|"))
+		assert.True(strings.HasSuffix(mem.get(0), "|3|suser=sa"))
+	}
+}
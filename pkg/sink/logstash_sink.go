@@ -54,7 +54,14 @@ func (lss *LogstashSink) Open(ctx context.Context, ignore string) error {
 }
 
 func (lss *LogstashSink) Write(ctx context.Context, name, event string) (int, error) {
-	return 0, lss.ls.Write(event)
+	return 0, lss.ls.WriteContext(ctx, event)
+}
+
+// WriteBatch writes the events to logstash.  An event is confirmed
+// once the TCP write succeeds.  If the writes are still failing when ctx
+// is done, the rest of the batch is rejected.
+func (lss *LogstashSink) WriteBatch(ctx context.Context, events []Event) []error {
+	return WriteEach(ctx, lss, events)
 }
 
 // Reopen is a noop -- handled in the ls2 package
@@ -0,0 +1,32 @@
+package sink
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogstashWriteBatchCanceled(t *testing.T) {
+	assert := assert.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	host := l.Addr().String()
+	assert.NoError(l.Close())
+
+	lss, err := NewLogstashSink(host, 1)
+	assert.NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	events := []Event{{Name: "a", Payload: `{"a":1}`}, {Name: "b", Payload: `{"b":2}`}, {Name: "c", Payload: `{"c":3}`}}
+	errs := lss.WriteBatch(ctx, events)
+	assert.Len(errs, len(events))
+	for _, err := range errs {
+		assert.Error(err)
+	}
+	n, err := Delivered(len(events), errs)
+	assert.Equal(0, n)
+	assert.ErrorIs(err, context.Canceled)
+}
@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/prom"
+)
+
+// Named gives a sink the name used in the logs and the Prometheus sink
+// label.  It counts the events the sink confirms and fails to confirm
+// and keeps the state returned by States.
+type Named struct {
+	Sinker
+	name string
+}
+
+// NewNamed wraps a sink.  An empty name keeps the name of the sink.
+func NewNamed(snk Sinker, name string) *Named {
+	if name == "" {
+		name = snk.Name()
+	}
+	setState(name, func(*State) {})
+	return &Named{Sinker: snk, name: name}
+}
+
+// Name returns the name of the sink
+func (n *Named) Name() string {
+	return n.name
+}
+
+// Open opens the sink
+func (n *Named) Open(ctx context.Context, id string) error {
+	err := n.Sinker.Open(ctx, id)
+	setState(n.name, func(st *State) {
+		if err != nil {
+			st.failed(err)
+			return
+		}
+		st.Connected = true
+	})
+	return err
+}
+
+// Close closes the sink
+func (n *Named) Close() error {
+	err := n.Sinker.Close()
+	setState(n.name, func(st *State) {
+		st.Connected = false
+	})
+	return err
+}
+
+// WriteBatch writes the events to the sink and counts them
+func (n *Named) WriteBatch(ctx context.Context, events []Event) []error {
+	start := time.Now()
+	errs := n.Sinker.WriteBatch(ctx, events)
+	prom.SinkWriteDuration.WithLabelValues(n.name).Observe(time.Since(start).Seconds())
+	failed := 0
+	var first error
+	for i, err := range errs {
+		if err != nil && i < len(events) {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	prom.SinkEventsWritten.WithLabelValues(n.name).Add(float64(len(events) - failed))
+	if failed > 0 {
+		prom.SinkWriteErrors.WithLabelValues(n.name).Add(float64(failed))
+		prom.SinkBatchErrors.WithLabelValues(n.name).Inc()
+	}
+	setState(n.name, func(st *State) {
+		st.Written += int64(len(events) - failed)
+		st.Failed += int64(failed)
+		if first != nil {
+			st.failed(first)
+			return
+		}
+		now := time.Now()
+		st.Connected = true
+		st.LastWrite = &now
+	})
+	return errs
+}
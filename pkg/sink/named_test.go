@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamed(t *testing.T) {
+	assert := assert.New(t)
+	mem := &memSink{}
+	assert.Equal("mem", NewNamed(mem, "").Name())
+
+	snk := NewNamed(mem, "ops")
+	assert.Equal("ops", snk.Name())
+	assert.Nil(snk.WriteBatch(context.Background(), routeEvents()))
+	assert.Equal(4.0, testutil.ToFloat64(prom.SinkEventsWritten.WithLabelValues("ops")))
+
+	mem.setFail(true)
+	errs := snk.WriteBatch(context.Background(), routeEvents())
+	assert.Equal(4, len(errs))
+	assert.Equal(4.0, testutil.ToFloat64(prom.SinkEventsWritten.WithLabelValues("ops")))
+	assert.Equal(4.0, testutil.ToFloat64(prom.SinkWriteErrors.WithLabelValues("ops")))
+}
+
+func TestNamedState(t *testing.T) {
+	assert := assert.New(t)
+	ResetStates()
+	mem := &memSink{}
+	snk := NewNamed(mem, "state")
+	states := States()
+	assert.Equal(1, len(states))
+	assert.Equal("state", states[0].Name)
+	assert.False(states[0].Connected)
+
+	assert.NoError(snk.Open(context.Background(), "id"))
+	assert.True(States()[0].Connected)
+
+	assert.Nil(snk.WriteBatch(context.Background(), routeEvents()))
+	st := States()[0]
+	assert.True(st.Connected)
+	assert.NotNil(st.LastWrite)
+	assert.Equal(int64(4), st.Written)
+
+	mem.setFail(true)
+	snk.WriteBatch(context.Background(), routeEvents())
+	st = States()[0]
+	assert.False(st.Connected)
+	assert.NotEmpty(st.LastError)
+	assert.NotNil(st.LastErrorTime)
+	assert.Equal(int64(4), st.Failed)
+
+	mem.setFail(false)
+	assert.Nil(snk.WriteBatch(context.Background(), routeEvents()))
+	st = States()[0]
+	assert.True(st.Connected)
+	assert.Equal(int64(8), st.Written)
+
+	assert.NoError(snk.Close())
+	assert.False(States()[0].Connected)
+	ResetStates()
+	assert.Equal(0, len(States()))
+}
+
+func TestNamedMetrics(t *testing.T) {
+	assert := assert.New(t)
+	mem := &memSink{}
+	snk := NewNamed(mem, "latency")
+	assert.Nil(snk.WriteBatch(context.Background(), routeEvents()))
+	assert.Equal(1, testutil.CollectAndCount(prom.SinkWriteDuration.WithLabelValues("latency").(prometheus.Collector)))
+	assert.Equal(0.0, testutil.ToFloat64(prom.SinkBatchErrors.WithLabelValues("latency")))
+
+	mem.setFail(true)
+	snk.WriteBatch(context.Background(), routeEvents())
+	assert.Equal(1.0, testutil.ToFloat64(prom.SinkBatchErrors.WithLabelValues("latency")))
+}
@@ -0,0 +1,364 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpResource is the events for one set of resource attributes
+type otlpResource struct {
+	attrs   map[string]interface{}
+	records []otlpRecord
+}
+
+// otlpRecord is a LogRecord from opentelemetry-proto logs/v1
+type otlpRecord struct {
+	time     time.Time
+	observed time.Time
+	severity int
+	sevText  string
+	body     interface{}
+	attrs    map[string]interface{}
+}
+
+// OTLP severity numbers
+const (
+	otlpDebug = 5
+	otlpInfo  = 9
+	otlpInfo2 = 10
+	otlpWarn  = 13
+	otlpError = 17
+	otlpFatal = 21
+)
+
+// otlpSeverity maps a syslog severity like xe_severity_value to an OTLP
+// severity number and text
+func otlpSeverity(v int64) (int, string) {
+	switch {
+	case v <= 2:
+		return otlpFatal, "FATAL"
+	case v == 3:
+		return otlpError, "ERROR"
+	case v == 4:
+		return otlpWarn, "WARN"
+	case v == 5:
+		return otlpInfo2, "INFO2"
+	case v == 6:
+		return otlpInfo, "INFO"
+	}
+	return otlpDebug, "DEBUG"
+}
+
+// otlpRecords converts events to log records grouped by the mssql_*
+// fields, which become the resource attributes
+func otlpRecords(events []Event, service string) ([]*otlpResource, error) {
+	now := time.Now()
+	resources := make([]*otlpResource, 0)
+	byKey := make(map[string]*otlpResource)
+	for _, ev := range events {
+		d := json.NewDecoder(strings.NewReader(ev.Payload))
+		d.UseNumber()
+		var m map[string]interface{}
+		if err := d.Decode(&m); err != nil {
+			return nil, errors.Wrap(err, "json.decode")
+		}
+		rec := otlpRecord{time: ev.Time, observed: now, attrs: make(map[string]interface{})}
+		res := map[string]interface{}{"service.name": service}
+		for k, v := range m {
+			if strings.HasPrefix(k, "mssql_") {
+				res[k] = v
+				continue
+			}
+			rec.attrs[k] = v
+		}
+		if rec.time.IsZero() {
+			if ts, ok := m["timestamp"].(string); ok {
+				rec.time, _ = time.Parse(time.RFC3339Nano, ts)
+			}
+		}
+		rec.severity, rec.sevText = otlpInfo, "INFO"
+		if n, ok := m["xe_severity_value"].(json.Number); ok {
+			if v, err := n.Int64(); err == nil {
+				rec.severity, rec.sevText = otlpSeverity(v)
+			}
+		}
+		rec.body = ev.Name
+		if desc, ok := m["xe_description"].(string); ok && desc != "" {
+			rec.body = desc
+		}
+		if _, ok := rec.attrs["event.name"]; !ok && ev.Name != "" {
+			rec.attrs["event.name"] = ev.Name
+		}
+
+		key, err := json.Marshal(res)
+		if err != nil {
+			return nil, errors.Wrap(err, "json.marshal")
+		}
+		r, ok := byKey[string(key)]
+		if !ok {
+			r = &otlpResource{attrs: res}
+			byKey[string(key)] = r
+			resources = append(resources, r)
+		}
+		r.records = append(r.records, rec)
+	}
+	return resources, nil
+}
+
+// sortedKeys returns the keys of a map in order so the output is stable
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano()) // #nosec G115 -- times are after 1970
+}
+
+// Protobuf encoding of ExportLogsServiceRequest.  The field numbers are
+// from opentelemetry-proto.
+
+func otlpProto(resources []*otlpResource) []byte {
+	var b []byte
+	for _, r := range resources {
+		b = protowire.AppendTag(b, 1, protowire.BytesType) // resource_logs
+		b = protowire.AppendBytes(b, protoResourceLogs(r))
+	}
+	return b
+}
+
+func protoResourceLogs(r *otlpResource) []byte {
+	var res []byte
+	for _, k := range sortedKeys(r.attrs) {
+		res = protowire.AppendTag(res, 1, protowire.BytesType) // attributes
+		res = protowire.AppendBytes(res, protoKeyValue(k, r.attrs[k]))
+	}
+
+	var scope []byte
+	scope = protowire.AppendTag(scope, 1, protowire.BytesType) // name
+	scope = protowire.AppendString(scope, "sqlxewriter")
+
+	var sl []byte
+	sl = protowire.AppendTag(sl, 1, protowire.BytesType) // scope
+	sl = protowire.AppendBytes(sl, scope)
+	for _, rec := range r.records {
+		sl = protowire.AppendTag(sl, 2, protowire.BytesType) // log_records
+		sl = protowire.AppendBytes(sl, protoLogRecord(rec))
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType) // resource
+	b = protowire.AppendBytes(b, res)
+	b = protowire.AppendTag(b, 2, protowire.BytesType) // scope_logs
+	b = protowire.AppendBytes(b, sl)
+	return b
+}
+
+func protoLogRecord(rec otlpRecord) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type) // time_unix_nano
+	b = protowire.AppendFixed64(b, unixNano(rec.time))
+	b = protowire.AppendTag(b, 2, protowire.VarintType) // severity_number
+	b = protowire.AppendVarint(b, uint64(rec.severity)) // #nosec G115 -- small positive numbers
+	b = protowire.AppendTag(b, 3, protowire.BytesType)  // severity_text
+	b = protowire.AppendString(b, rec.sevText)
+	b = protowire.AppendTag(b, 5, protowire.BytesType) // body
+	b = protowire.AppendBytes(b, protoAnyValue(rec.body))
+	for _, k := range sortedKeys(rec.attrs) {
+		if rec.attrs[k] == nil {
+			continue
+		}
+		b = protowire.AppendTag(b, 6, protowire.BytesType) // attributes
+		b = protowire.AppendBytes(b, protoKeyValue(k, rec.attrs[k]))
+	}
+	b = protowire.AppendTag(b, 11, protowire.Fixed64Type) // observed_time_unix_nano
+	b = protowire.AppendFixed64(b, unixNano(rec.observed))
+	return b
+}
+
+func protoKeyValue(k string, v interface{}) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, k)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, protoAnyValue(v))
+	return b
+}
+
+// protoAnyValue encodes a value decoded from the JSON of an event
+func protoAnyValue(v interface{}) []byte {
+	var b []byte
+	switch x := v.(type) {
+	case string:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, x)
+	case bool:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(x))
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			b = protowire.AppendTag(b, 3, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(i)) // #nosec G115 -- int64 is encoded as two's complement
+		} else {
+			f, _ := x.Float64()
+			b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(f))
+		}
+	case []interface{}:
+		var arr []byte
+		for _, item := range x {
+			arr = protowire.AppendTag(arr, 1, protowire.BytesType)
+			arr = protowire.AppendBytes(arr, protoAnyValue(item))
+		}
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, arr)
+	case map[string]interface{}:
+		var kvs []byte
+		for _, k := range sortedKeys(x) {
+			kvs = protowire.AppendTag(kvs, 1, protowire.BytesType)
+			kvs = protowire.AppendBytes(kvs, protoKeyValue(k, x[k]))
+		}
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, kvs)
+	}
+	return b
+}
+
+// otlpPartialSuccess reads rejected_log_records and error_message from
+// an ExportLogsServiceResponse
+func otlpPartialSuccess(b []byte) (int64, string) {
+	var rejected int64
+	var msg string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return rejected, msg
+		}
+		b = b[n:]
+		if num == 1 && typ == protowire.BytesType {
+			ps, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return rejected, msg
+			}
+			b = b[n:]
+			for len(ps) > 0 {
+				pnum, ptyp, n := protowire.ConsumeTag(ps)
+				if n < 0 {
+					return rejected, msg
+				}
+				ps = ps[n:]
+				switch {
+				case pnum == 1 && ptyp == protowire.VarintType:
+					v, n := protowire.ConsumeVarint(ps)
+					if n < 0 {
+						return rejected, msg
+					}
+					rejected = int64(v) // #nosec G115 -- int64 field
+					ps = ps[n:]
+				case pnum == 2 && ptyp == protowire.BytesType:
+					v, n := protowire.ConsumeString(ps)
+					if n < 0 {
+						return rejected, msg
+					}
+					msg = v
+					ps = ps[n:]
+				default:
+					n = protowire.ConsumeFieldValue(pnum, ptyp, ps)
+					if n < 0 {
+						return rejected, msg
+					}
+					ps = ps[n:]
+				}
+			}
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return rejected, msg
+		}
+		b = b[n:]
+	}
+	return rejected, msg
+}
+
+// JSON encoding of ExportLogsServiceRequest.  64 bit integers are
+// strings and field names are lowerCamelCase.
+
+func otlpJSON(resources []*otlpResource) ([]byte, error) {
+	rls := make([]interface{}, 0, len(resources))
+	for _, r := range resources {
+		records := make([]interface{}, 0, len(r.records))
+		for _, rec := range r.records {
+			records = append(records, map[string]interface{}{
+				"timeUnixNano":         strconv.FormatUint(unixNano(rec.time), 10),
+				"observedTimeUnixNano": strconv.FormatUint(unixNano(rec.observed), 10),
+				"severityNumber":       rec.severity,
+				"severityText":         rec.sevText,
+				"body":                 jsonAnyValue(rec.body),
+				"attributes":           jsonKeyValues(rec.attrs),
+			})
+		}
+		rls = append(rls, map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": jsonKeyValues(r.attrs)},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]interface{}{"name": "sqlxewriter"},
+				"logRecords": records,
+			}},
+		})
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(map[string]interface{}{"resourceLogs": rls})
+	return buf.Bytes(), err
+}
+
+func jsonKeyValues(m map[string]interface{}) []interface{} {
+	kvs := make([]interface{}, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		if m[k] == nil {
+			continue
+		}
+		kvs = append(kvs, map[string]interface{}{"key": k, "value": jsonAnyValue(m[k])})
+	}
+	return kvs
+}
+
+func jsonAnyValue(v interface{}) map[string]interface{} {
+	switch x := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": x}
+	case bool:
+		return map[string]interface{}{"boolValue": x}
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return map[string]interface{}{"intValue": strconv.FormatInt(i, 10)}
+		}
+		f, _ := x.Float64()
+		return map[string]interface{}{"doubleValue": f}
+	case []interface{}:
+		values := make([]interface{}, 0, len(x))
+		for _, item := range x {
+			values = append(values, jsonAnyValue(item))
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case map[string]interface{}:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": jsonKeyValues(x)}}
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/billgraziano/xelogstash/pkg/filter"
+	"github.com/pkg/errors"
+)
+
+// Route wraps a sink so it only gets some of the events.  An event is
+// written if it matches Include, or there is no Include, and it doesn't
+// match Exclude.  The rules test the fields of the event before adds,
+// copies and moves.  Events that aren't routed count as delivered.
+type Route struct {
+	Sinker
+	include *filter.Rule
+	exclude *filter.Rule
+}
+
+// NewRoute returns the sink wrapped with the rules.  It returns the sink
+// as is if both rules are empty.
+func NewRoute(snk Sinker, include, exclude string) (Sinker, error) {
+	if include == "" && exclude == "" {
+		return snk, nil
+	}
+	r := &Route{Sinker: snk}
+	if include != "" {
+		rule, err := filter.New(filter.Include, include, nil)
+		if err != nil {
+			return snk, errors.Wrap(err, "include")
+		}
+		r.include = &rule
+	}
+	if exclude != "" {
+		rule, err := filter.New(filter.Exclude, exclude, nil)
+		if err != nil {
+			return snk, errors.Wrap(err, "exclude")
+		}
+		r.exclude = &rule
+	}
+	return r, nil
+}
+
+// Routed reports whether the sink gets an event
+func (r *Route) Routed(ev Event) bool {
+	if r.include != nil && !r.include.Match(ev.Fields) {
+		return false
+	}
+	if r.exclude != nil && r.exclude.Match(ev.Fields) {
+		return false
+	}
+	return true
+}
+
+// WriteBatch writes the events that are routed to the sink.  The errors
+// line up with the events passed in.
+func (r *Route) WriteBatch(ctx context.Context, events []Event) []error {
+	routed := make([]Event, 0, len(events))
+	index := make([]int, 0, len(events))
+	for i, ev := range events {
+		if r.Routed(ev) {
+			routed = append(routed, ev)
+			index = append(index, i)
+		}
+	}
+	if len(routed) == 0 {
+		return nil
+	}
+	errs := r.Sinker.WriteBatch(ctx, routed)
+	if errs == nil {
+		return nil
+	}
+	all := make([]error, len(events))
+	for i, err := range errs {
+		if i < len(index) {
+			all[index[i]] = err
+		}
+	}
+	return all
+}
@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func routeEvents() []Event {
+	return []Event{
+		{Name: "login", Payload: "1", Fields: map[string]interface{}{"name": "login", "xe_category": "login"}},
+		{Name: "rpc_completed", Payload: "2", Fields: map[string]interface{}{"name": "rpc_completed", "xe_category": "tsql"}},
+		{Name: "error_reported", Payload: "3", Fields: map[string]interface{}{"name": "error_reported", "severity": int64(16)}},
+		{Name: "logout", Payload: "4", Fields: map[string]interface{}{"name": "logout", "xe_category": "login"}},
+	}
+}
+
+func TestRoute(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	mem := &memSink{}
+	snk, err := NewRoute(mem, "xe_category == 'login' or severity >= 16", "name == 'logout'")
+	assert.NoError(err)
+	assert.Equal("mem", snk.Name())
+	errs := snk.WriteBatch(ctx, routeEvents())
+	assert.Nil(errs)
+	assert.Equal([]string{"1", "3"}, mem.events)
+
+	// nothing routed
+	mem = &memSink{}
+	snk, err = NewRoute(mem, "xe_category == 'audit'", "")
+	assert.NoError(err)
+	assert.Nil(snk.WriteBatch(ctx, routeEvents()))
+	assert.Equal(0, mem.count())
+
+	// no rules is the sink itself
+	mem = &memSink{}
+	snk, err = NewRoute(mem, "", "")
+	assert.NoError(err)
+	assert.Equal(mem, snk)
+
+	_, err = NewRoute(mem, "xe_category = 'login'", "")
+	assert.Error(err)
+	assert.Contains(err.Error(), "include: where: line 1, col 13")
+}
+
+func TestRouteFailure(t *testing.T) {
+	assert := assert.New(t)
+	mem := &memSink{}
+	mem.setFail(true)
+	snk, err := NewRoute(mem, "", "xe_category == 'login'")
+	assert.NoError(err)
+	events := routeEvents()
+	errs := snk.WriteBatch(context.Background(), events)
+	assert.Equal(len(events), len(errs))
+
+	// the login before the first routed event is delivered
+	n, err := Delivered(len(events), errs)
+	assert.Error(err)
+	assert.Equal(1, n)
+}
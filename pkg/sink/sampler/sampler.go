@@ -93,6 +93,11 @@ func (s *Sampler) Name() string {
 	return fmt.Sprintf("sampler: %s (%s)", s.path, s.duration.String())
 }
 
+// WriteBatch writes a sample of the events
+func (s *Sampler) WriteBatch(ctx context.Context, events []sink.Event) []error {
+	return sink.WriteEach(ctx, s, events)
+}
+
 func (s *Sampler) Close() error  { return nil }
 func (s *Sampler) Sync() error   { return nil }
 func (s *Sampler) Clean() error  { return nil }
@@ -10,6 +10,10 @@ import (
 type Sinker interface {
 	Open(context.Context, string) error
 	Write(context.Context, string, string) (int, error)
+	// WriteBatch writes the events and waits until the destination confirms
+	// them.  It returns an error for each event that wasn't confirmed, or nil
+	// if every event was confirmed.
+	WriteBatch(context.Context, []Event) []error
 	Flush() error
 	Close() error
 	Name() string
@@ -3,17 +3,35 @@ package sink
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/billgraziano/xelogstash/pkg/eshelper"
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
-// ElasticSink writes to an ElasticSearch instance
+// Defaults for batching and retries in the ElasticSink
+const (
+	DefaultElasticBatchCount       = 500
+	DefaultElasticBatchBytes       = 5 * 1024 * 1024
+	DefaultElasticFlushInterval    = 5 * time.Second
+	DefaultElasticMaxRetries       = 5
+	DefaultElasticTemplateName     = "sqlxewriter"
+	DefaultElasticTemplatePriority = 200
+	elasticMaxRetryInterval        = time.Minute
+)
+
+// ElasticSink writes to an ElasticSearch instance.  Events are buffered
+// per index and sent using the bulk API.
 type ElasticSink struct {
 	Addresses         []string
 	Username          string
@@ -22,25 +40,62 @@ type ElasticSink struct {
 	EventIndexMap     map[string]string
 	AutoCreateIndexes bool
 	ProxyServer       string
-	client            *elasticsearch.Client
-	//buf               *bytes.Buffer
-	mu sync.RWMutex
+
+	BatchCount     int           // send an index after this many events
+	BatchBytes     int           // send an index after this many bytes
+	FlushInterval  time.Duration // send buffered events this often
+	MaxRetries     int           // retries for documents that get a 429 or 5xx
+	RetryInterval  time.Duration // first wait before a retry.  It doubles each retry.
+	DeadLetterFile string        // documents that Elastic rejects are written here
+
+	DataStreams      bool   // write to data streams using the create action
+	ILMPolicy        string // ILM policy name set in the index template
+	ManageTemplates  bool   // install the bundled component and index templates
+	TemplateName     string // name of the index template
+	TemplatePriority int    // priority of the index template over others that match the same indexes
+
+	client  *elasticsearch.Client
+	logger  *log.Entry
+	mu      sync.RWMutex
+	buffers map[string][]elasticDoc // buffered events by index
+	size    map[string]int          // buffered bytes by index
+	dlmu    sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// elasticDoc is one event waiting to be sent
+type elasticDoc struct {
+	index  string
+	event  string
+	result chan error // gets the result once the document is sent
 }
 
+var _ Sinker = (*ElasticSink)(nil)
+
 // NewElasticSink returns a new ElasticSink
 func NewElasticSink(addresses []string, proxy, username, password string) (*ElasticSink, error) {
 	es := ElasticSink{
-		Addresses:   addresses,
-		Username:    username,
-		Password:    password,
-		ProxyServer: proxy,
+		Addresses:        addresses,
+		Username:         username,
+		Password:         password,
+		ProxyServer:      proxy,
+		BatchCount:       DefaultElasticBatchCount,
+		BatchBytes:       DefaultElasticBatchBytes,
+		FlushInterval:    DefaultElasticFlushInterval,
+		MaxRetries:       DefaultElasticMaxRetries,
+		RetryInterval:    time.Second,
+		TemplateName:     DefaultElasticTemplateName,
+		TemplatePriority: DefaultElasticTemplatePriority,
+		logger:           log.WithFields(log.Fields{}),
+		buffers:          make(map[string][]elasticDoc),
+		size:             make(map[string]int),
 	}
 	client, err := eshelper.NewClient(addresses, proxy, username, password)
 	if err != nil {
 		return nil, errors.Wrap(err, "eshelper.newclient")
 	}
 	es.client = client
-	//es.buf = new(bytes.Buffer)
 	return &es, nil
 }
 
@@ -51,7 +106,7 @@ func (es *ElasticSink) Name() string {
 	return fmt.Sprintf("elastic: %s", strings.Join(es.Addresses, ", "))
 }
 
-// Open tests the Elastic Client (id is ignored)
+// Open tests the Elastic Client and starts the flush timer (id is ignored)
 func (es *ElasticSink) Open(_ context.Context, _ string) error {
 	es.mu.Lock()
 	defer es.mu.Unlock()
@@ -61,116 +116,362 @@ func (es *ElasticSink) Open(_ context.Context, _ string) error {
 		return errors.Wrap(err, "es.info")
 	}
 
-	if es.AutoCreateIndexes {
-		esIndexes := make([]string, 0)
-		if es.DefaultIndex != "" {
-			esIndexes = append(esIndexes, es.DefaultIndex)
-		}
-		// App log indexes?
-		// if es. != "" {
-		// 	esIndexes = append(esIndexes, es.AppLogIndex)
-		// }
-		for _, ix := range es.EventIndexMap {
-			esIndexes = append(esIndexes, ix)
+	if es.ManageTemplates || es.ILMPolicy != "" {
+		err = eshelper.InstallTemplates(es.client, eshelper.IndexTemplate{
+			Name:       es.TemplateName,
+			Patterns:   es.indexes(),
+			DataStream: es.DataStreams,
+			ILMPolicy:  es.ILMPolicy,
+			Priority:   es.TemplatePriority,
+		})
+		if err != nil {
+			return errors.Wrap(err, "eshelper.installtemplates")
 		}
+	}
 
-		err = eshelper.CreateIndexes(es.client, esIndexes)
+	// Elastic creates a data stream when the first document is written
+	if es.AutoCreateIndexes && !es.DataStreams {
+		err = eshelper.CreateIndexes(es.client, es.indexes())
 		if err != nil {
 			return errors.Wrap(err, "eshelper.createindexes")
 		}
 	}
+
+	if es.FlushInterval > 0 && es.done == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		es.cancel = cancel
+		es.done = make(chan struct{})
+		go es.flushLoop(ctx, es.done)
+	}
 	return nil
 }
 
-// Write the event to the buffer
-// func (es *ElasticSink) Write(name, event string) (int, error) {
-// 	var esIndex string
-// 	var ok bool
-// 	var n int
-// 	var err error
-
-// 	es.mu.Lock()
-// 	defer es.mu.Unlock()
-// 	esIndex, ok = es.EventIndexMap[name]
-// 	if !ok {
-// 		esIndex = es.DefaultIndex
-// 	}
-// 	meta := []byte(fmt.Sprintf(`{ "index" : { "_index" : "%s" } }%s`, esIndex, "\n"))
-// 	espayload := []byte(event + "\n")
-// 	es.buf.Grow(len(meta) + len(espayload))
-// 	n, err = es.buf.Write(meta)
-// 	if err != nil {
-// 		return n, errors.Wrap(err, "es.buf.write-meta")
-// 	}
-// 	es.buf.Write(espayload)
-// 	if err != nil {
-// 		return n, errors.Wrap(err, "es.buf.write-payload")
-// 	}
-// 	return 0, nil
-// }
-
-// Write the event to Elastic
-func (es *ElasticSink) Write(ctx context.Context, name, event string) (int, error) {
-	es.mu.RLock()
-	defer es.mu.RUnlock()
+// flushLoop sends buffered events every FlushInterval
+func (es *ElasticSink) flushLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(es.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := es.Flush()
+			if err != nil {
+				es.log().Error(errors.Wrap(err, "elastic.flush"))
+			}
+		}
+	}
+}
 
-	var esIndex string
-	var ok bool
-	var n int
-	var err error
+// indexes returns the distinct index names the sink writes to
+func (es *ElasticSink) indexes() []string {
+	esIndexes := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(ix string) {
+		if ix != "" && !seen[ix] {
+			seen[ix] = true
+			esIndexes = append(esIndexes, ix)
+		}
+	}
+	add(es.DefaultIndex)
+	for _, ix := range es.EventIndexMap {
+		add(ix)
+	}
+	sort.Strings(esIndexes)
+	return esIndexes
+}
 
-	esIndex, ok = es.EventIndexMap[name]
+func (es *ElasticSink) index(name string) string {
+	esIndex, ok := es.EventIndexMap[name]
 	if !ok {
 		esIndex = es.DefaultIndex
 	}
+	return esIndex
+}
 
-	meta := []byte(fmt.Sprintf(`{ "index" : { "_index" : "%s" } }%s`, esIndex, "\n"))
-	espayload := []byte(event + "\n")
-	var b bytes.Buffer
-	b.Grow(len(meta) + len(espayload))
-	n, err = b.Write(meta)
+// document adds the @timestamp field that data streams require.  It is
+// copied from the timestamp field if the event doesn't have one.
+func (es *ElasticSink) document(event string) string {
+	if !es.DataStreams || gjson.Get(event, `\@timestamp`).Exists() {
+		return event
+	}
+	ts := gjson.Get(event, "timestamp")
+	if !ts.Exists() {
+		return event
+	}
+	doc, err := sjson.SetRaw(event, `\@timestamp`, ts.Raw)
 	if err != nil {
-		return n, errors.Wrap(err, "es.buf.write-meta")
+		return event
+	}
+	return doc
+}
+
+// action is the bulk action.  Data streams only accept create.
+func (es *ElasticSink) action() string {
+	if es.DataStreams {
+		return "create"
+	}
+	return "index"
+}
+
+// Write buffers the event.  The buffer for the index is sent when it
+// reaches BatchCount events or BatchBytes.
+func (es *ElasticSink) Write(ctx context.Context, name, event string) (int, error) {
+	event = es.document(event)
+	es.mu.Lock()
+	esIndex := es.index(name)
+	es.buffers[esIndex] = append(es.buffers[esIndex], elasticDoc{index: esIndex, event: event})
+	es.size[esIndex] += len(event)
+	var docs []elasticDoc
+	if len(es.buffers[esIndex]) >= es.BatchCount || es.size[esIndex] >= es.BatchBytes {
+		docs = es.take(esIndex)
+	}
+	es.mu.Unlock()
+
+	if len(docs) > 0 {
+		_, err := Delivered(len(docs), es.send(ctx, docs))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(event), nil
+}
+
+// WriteBatch buffers the events with the events from other sources and
+// waits until they are sent.  An index is sent when it reaches BatchCount
+// events or BatchBytes and everything else is sent every FlushInterval.
+// Without a flush timer the batch is sent before WriteBatch returns.
+// Documents that Elastic rejects are written to the dead letter file and
+// count as delivered.  Without a dead letter file they are errors.
+func (es *ElasticSink) WriteBatch(ctx context.Context, events []Event) []error {
+	if len(events) == 0 {
+		return nil
+	}
+	results := make([]chan error, len(events))
+	full := make([][]elasticDoc, 0)
+	es.mu.Lock()
+	for i, e := range events {
+		results[i] = make(chan error, 1)
+		esIndex := es.index(e.Name)
+		doc := elasticDoc{index: esIndex, event: es.document(e.Payload), result: results[i]}
+		es.buffers[esIndex] = append(es.buffers[esIndex], doc)
+		es.size[esIndex] += len(doc.event)
+		if len(es.buffers[esIndex]) >= es.BatchCount || es.size[esIndex] >= es.BatchBytes {
+			full = append(full, es.take(esIndex))
+		}
+	}
+	timer := es.done != nil
+	es.mu.Unlock()
+
+	for _, docs := range full {
+		es.send(ctx, docs)
+	}
+	if !timer {
+		// the flush error is returned to each event below
+		_ = es.Flush()
+	}
+
+	errs := make([]error, len(events))
+	failed := false
+	for i, result := range results {
+		select {
+		case errs[i] = <-result:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		}
+		if errs[i] != nil {
+			failed = true
+		}
 	}
-	_, err = b.Write(espayload)
+	if !failed {
+		return nil
+	}
+	return errs
+}
+
+// Flush sends all the buffered events
+func (es *ElasticSink) Flush() error {
+	es.mu.Lock()
+	docs := make([]elasticDoc, 0)
+	for ix := range es.buffers {
+		docs = append(docs, es.take(ix)...)
+	}
+	es.mu.Unlock()
+
+	_, err := Delivered(len(docs), es.send(context.Background(), docs))
+	return err
+}
+
+// take removes the buffered events for an index.  The caller must hold the lock.
+func (es *ElasticSink) take(esIndex string) []elasticDoc {
+	docs := es.buffers[esIndex]
+	delete(es.buffers, esIndex)
+	delete(es.size, esIndex)
+	return docs
+}
+
+// send writes the documents in bulk requests of up to BatchCount
+// documents or BatchBytes.  The result of each document goes to its result
+// channel.  It returns nil if every document was handled.
+func (es *ElasticSink) send(ctx context.Context, docs []elasticDoc) []error {
+	if len(docs) == 0 {
+		return nil
+	}
+	errs := make([]error, len(docs))
+	failed := false
+	start := 0
+	for start < len(docs) {
+		end := start
+		size := 0
+		for end < len(docs) && (end == start || (end-start < es.BatchCount && size+len(docs[end].event) <= es.BatchBytes)) {
+			size += len(docs[end].event)
+			end++
+		}
+		if es.sendBulk(ctx, docs[start:end], errs[start:end]) {
+			failed = true
+		}
+		start = end
+	}
+	for i, doc := range docs {
+		if doc.result != nil {
+			doc.result <- errs[i]
+		}
+	}
+	if !failed {
+		return nil
+	}
+	return errs
+}
+
+// sendBulk sends one bulk request and retries the documents that get a
+// 429 or 5xx.  It fills in errs and returns true if any document failed.
+func (es *ElasticSink) sendBulk(ctx context.Context, docs []elasticDoc, errs []error) bool {
+	pending := make([]int, len(docs))
+	for i := range docs {
+		pending[i] = i
+	}
+	wait := es.RetryInterval
+	action := es.action()
+	for attempt := 0; ; attempt++ {
+		var body bytes.Buffer
+		for _, i := range pending {
+			fmt.Fprintf(&body, `{ "%s" : { "_index" : "%s" } }%s`, action, docs[i].index, "\n")
+			body.WriteString(docs[i].event)
+			body.WriteString("\n")
+		}
+
+		retry := make([]int, 0)
+		var lastErr error
+		br, status, err := eshelper.Bulk(ctx, es.client, body.Bytes())
+		switch {
+		case err != nil && (status == 0 || eshelper.Retryable(status)):
+			retry = pending
+			lastErr = err
+		case err != nil:
+			for _, i := range pending {
+				errs[i] = err
+			}
+			return true
+		default:
+			for j, i := range pending {
+				item, ok := br.Item(j)
+				switch {
+				case !ok:
+					retry = append(retry, i)
+					lastErr = errors.New("es.bulk: missing item in response")
+				case item.OK():
+				case eshelper.Retryable(item.Status):
+					retry = append(retry, i)
+					lastErr = item.Err()
+				default:
+					errs[i] = es.deadLetter(docs[i], item)
+				}
+			}
+		}
+
+		if len(retry) == 0 {
+			break
+		}
+		if attempt >= es.MaxRetries || ctx.Err() != nil {
+			for _, i := range retry {
+				errs[i] = errors.Wrapf(lastErr, "elastic: retries: %d", attempt)
+			}
+			break
+		}
+		es.log().Warnf("elastic: retrying %d documents in %s: %v", len(retry), wait, lastErr)
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > elasticMaxRetryInterval {
+			wait = elasticMaxRetryInterval
+		}
+		pending = retry
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// deadLetter saves a document that Elastic rejected.  Without a dead letter
+// file the rejection is returned so the event isn't confirmed.
+func (es *ElasticSink) deadLetter(doc elasticDoc, item eshelper.BulkItem) error {
+	if es.DeadLetterFile == "" {
+		return errors.Wrapf(item.Err(), "elastic: rejected: index: %s", doc.index)
+	}
+
+	var event interface{} = doc.event
+	if json.Valid([]byte(doc.event)) {
+		event = json.RawMessage(doc.event)
+	}
+	b, err := json.Marshal(struct {
+		Time   time.Time   `json:"time"`
+		Index  string      `json:"index"`
+		Status int         `json:"status"`
+		Error  string      `json:"error"`
+		Event  interface{} `json:"event"`
+	}{time.Now(), doc.index, item.Status, item.Err().Error(), event})
 	if err != nil {
-		return n, errors.Wrap(err, "es.buf.write-payload")
+		return errors.Wrap(err, "deadletter.marshal")
 	}
 
-	err = eshelper.WriteElasticBuffer(es.client, &b)
+	es.dlmu.Lock()
+	defer es.dlmu.Unlock()
+	f, err := os.OpenFile(es.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
 	if err != nil {
-		return 0, errors.Wrap(err, "eshelper.writeelasticbuffer")
+		return errors.Wrap(err, "deadletter.open")
 	}
-	return 0, nil
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "deadletter.write")
+	}
+	return errors.Wrap(f.Close(), "deadletter.close")
 }
 
-// Flush the buffer to ElasticSearch
-func (es *ElasticSink) Flush() error {
-	return nil
-	// es.mu.Lock()
-	// defer es.mu.Unlock()
-
-	// err := eshelper.WriteElasticBuffer(es.client, es.buf)
-	// if err != nil {
-	// 	return errors.Wrap(err, "eshelper.writeelasticbuffer")
-	// }
-	// es.buf.Reset()
-	// return nil
-}
-
-// func (es *ElasticSink) flush() error {
-// 	// err := eshelper.WriteElasticBuffer(es.client, es.buf)
-// 	// if err != nil {
-// 	// 	return errors.Wrap(err, "eshelper.writeelasticbuffer")
-// 	// }
-// 	// es.buf.Reset()
-// 	return nil
-// }
-
-// Close the ElasticSink
+func (es *ElasticSink) log() *log.Entry {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.logger
+}
+
+// Close stops the flush timer and sends any buffered events
 func (es *ElasticSink) Close() error {
-	return nil
-	//return es.Flush()
+	es.mu.Lock()
+	cancel, done := es.cancel, es.done
+	es.cancel, es.done = nil, nil
+	es.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return es.Flush()
 }
 
 // Clean is a noop to satisfy the interface
@@ -185,4 +486,7 @@ func (es *ElasticSink) Reopen() error {
 
 // SetLogger sets the logger for the sink
 func (es *ElasticSink) SetLogger(entry *log.Entry) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.logger = entry
 }
@@ -0,0 +1,262 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeElastic answers the info, template, and bulk APIs.  Documents
+// containing "busy" get a 429 the first time they are seen and documents
+// containing "bad" are rejected.
+type fakeElastic struct {
+	mu        sync.Mutex
+	requests  int
+	indexed   map[string]string // event -> index
+	seen      map[string]bool
+	actions   map[string]int    // bulk action -> count
+	templates map[string]string // path -> body
+}
+
+func newFakeElastic() (*fakeElastic, *httptest.Server) {
+	fe := &fakeElastic{
+		indexed:   make(map[string]string),
+		seen:      make(map[string]bool),
+		actions:   make(map[string]int),
+		templates: make(map[string]string),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fe.handle))
+	return fe, srv
+}
+
+func (fe *fakeElastic) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPut && (strings.HasPrefix(r.URL.Path, "/_component_template/") || strings.HasPrefix(r.URL.Path, "/_index_template/")) {
+		b, _ := io.ReadAll(r.Body)
+		fe.mu.Lock()
+		fe.templates[r.URL.Path] = string(b)
+		fe.mu.Unlock()
+		fmt.Fprint(w, `{"acknowledged":true}`)
+		return
+	}
+	if r.URL.Path != "/_bulk" {
+		fmt.Fprint(w, `{"version":{"number":"7.17.0","build_flavor":"default"},"tagline":"You Know, for Search"}`)
+		return
+	}
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.requests++
+	items := make([]string, 0)
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var meta map[string]struct {
+			Index string `json:"_index"`
+		}
+		_ = json.Unmarshal(scanner.Bytes(), &meta)
+		var action, index string
+		for action = range meta {
+			index = meta[action].Index
+		}
+		fe.actions[action]++
+		scanner.Scan()
+		event := scanner.Text()
+		switch {
+		case strings.Contains(event, "busy") && !fe.seen[event]:
+			fe.seen[event] = true
+			items = append(items, `{"index":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}`)
+		case strings.Contains(event, "bad"):
+			items = append(items, `{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}`)
+		default:
+			fe.indexed[event] = index
+			items = append(items, fmt.Sprintf(`{"%s":{"status":201,"result":"created"}}`, action))
+		}
+	}
+	fmt.Fprintf(w, `{"errors":true,"items":[%s]}`, strings.Join(items, ","))
+}
+
+func TestElasticBulk(t *testing.T) {
+	assert := assert.New(t)
+	fe, srv := newFakeElastic()
+	defer srv.Close()
+
+	es, err := NewElasticSink([]string{srv.URL}, "", "user", "pass")
+	assert.NoError(err)
+	es.DefaultIndex = "sqlxe"
+	es.EventIndexMap = map[string]string{"login": "logins"}
+	es.RetryInterval = time.Millisecond
+	es.DeadLetterFile = filepath.Join(t.TempDir(), "dead.json")
+	es.FlushInterval = 10 * time.Millisecond
+	assert.NoError(es.Open(context.Background(), "id"))
+
+	events := []Event{
+		{Name: "login", Payload: `{"n":1}`},
+		{Name: "error_reported", Payload: `{"n":2,"x":"busy"}`},
+		{Name: "error_reported", Payload: `{"n":3,"x":"bad"}`},
+		{Name: "error_reported", Payload: `{"n":4}`},
+	}
+	errs := es.WriteBatch(context.Background(), events)
+	assert.Nil(errs)
+	assert.NoError(es.Close())
+
+	assert.Equal(2, fe.requests, "one request plus one retry")
+	assert.Equal("logins", fe.indexed[`{"n":1}`])
+	assert.Equal("sqlxe", fe.indexed[`{"n":2,"x":"busy"}`])
+	assert.Equal("sqlxe", fe.indexed[`{"n":4}`])
+	assert.Equal(3, len(fe.indexed))
+
+	b, err := os.ReadFile(es.DeadLetterFile)
+	assert.NoError(err)
+	assert.Contains(string(b), `"event":{"n":3,"x":"bad"}`)
+	assert.Contains(string(b), "mapper_parsing_exception")
+}
+
+func TestElasticBuffer(t *testing.T) {
+	assert := assert.New(t)
+	fe, srv := newFakeElastic()
+	defer srv.Close()
+
+	es, err := NewElasticSink([]string{srv.URL}, "", "user", "pass")
+	assert.NoError(err)
+	es.DefaultIndex = "sqlxe"
+	es.BatchCount = 2
+	es.FlushInterval = 0
+	assert.NoError(es.Open(context.Background(), "id"))
+
+	for i := 0; i < 5; i++ {
+		_, err = es.Write(context.Background(), "login", fmt.Sprintf(`{"n":%d}`, i))
+		assert.NoError(err)
+	}
+	assert.Equal(2, fe.requests, "a request for every two events")
+	assert.NoError(es.Flush())
+	assert.Equal(3, fe.requests)
+	assert.Equal(5, len(fe.indexed))
+}
+
+func TestElasticWriteBatchBuffered(t *testing.T) {
+	assert := assert.New(t)
+	fe, srv := newFakeElastic()
+	defer srv.Close()
+
+	es, err := NewElasticSink([]string{srv.URL}, "", "user", "pass")
+	assert.NoError(err)
+	es.DefaultIndex = "sqlxe"
+	es.BatchCount = 3
+	es.FlushInterval = 50 * time.Millisecond
+	assert.NoError(es.Open(context.Background(), "id"))
+	defer es.Close()
+
+	// two sources share one bulk request once the index has three events
+	var wg sync.WaitGroup
+	results := make([][]error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = es.WriteBatch(context.Background(), []Event{{Name: "login", Payload: fmt.Sprintf(`{"src":%d}`, i)}})
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(es.WriteBatch(context.Background(), []Event{{Name: "login", Payload: `{"src":2}`}}))
+	wg.Wait()
+	assert.Nil(results[0])
+	assert.Nil(results[1])
+	assert.Equal(1, fe.requests)
+	assert.Equal(3, len(fe.indexed))
+
+	// without a dead letter file a rejected document isn't delivered
+	errs := es.WriteBatch(context.Background(), []Event{
+		{Name: "login", Payload: `{"n":1}`},
+		{Name: "login", Payload: `{"n":2,"x":"bad"}`},
+	})
+	if assert.Equal(2, len(errs)) {
+		assert.NoError(errs[0])
+		assert.Error(errs[1])
+		assert.Contains(errs[1].Error(), "mapper_parsing_exception")
+	}
+	n, err := Delivered(2, errs)
+	assert.Equal(1, n)
+	assert.Error(err)
+}
+
+func TestElasticDataStreams(t *testing.T) {
+	assert := assert.New(t)
+	fe, srv := newFakeElastic()
+	defer srv.Close()
+
+	es, err := NewElasticSink([]string{srv.URL}, "", "user", "pass")
+	assert.NoError(err)
+	es.DefaultIndex = "logs-sqlxe-default"
+	es.EventIndexMap = map[string]string{"login": "logs-sqllogin-default"}
+	es.DataStreams = true
+	es.ManageTemplates = true
+	es.ILMPolicy = "sqlxe-30d"
+	es.TemplatePriority = 500
+	es.AutoCreateIndexes = true
+	es.FlushInterval = 10 * time.Millisecond
+	assert.NoError(es.Open(context.Background(), "id"))
+
+	component := fe.templates["/_component_template/sqlxewriter-mappings"]
+	assert.Contains(component, `"xe_severity_value": { "type": "long" }`)
+	var it struct {
+		IndexPatterns []string  `json:"index_patterns"`
+		DataStream    *struct{} `json:"data_stream"`
+		ComposedOf    []string  `json:"composed_of"`
+		Priority      int       `json:"priority"`
+		Template      struct {
+			Settings map[string]string `json:"settings"`
+		} `json:"template"`
+	}
+	assert.NoError(json.Unmarshal([]byte(fe.templates["/_index_template/sqlxewriter"]), &it))
+	assert.Equal([]string{"logs-sqllogin-default", "logs-sqlxe-default"}, it.IndexPatterns)
+	assert.NotNil(it.DataStream)
+	assert.Equal([]string{"sqlxewriter-mappings"}, it.ComposedOf)
+	assert.Equal(500, it.Priority)
+	assert.Equal("sqlxe-30d", it.Template.Settings["index.lifecycle.name"])
+
+	events := []Event{
+		{Name: "login", Payload: `{"timestamp":"2024-01-02T03:04:05Z"}`},
+		{Name: "error_reported", Payload: `{"@timestamp":"2024-01-02T03:04:05Z","n":2}`},
+	}
+	assert.Nil(es.WriteBatch(context.Background(), events))
+	assert.NoError(es.Close())
+
+	assert.Equal(map[string]int{"create": 2}, fe.actions)
+	assert.Equal("logs-sqllogin-default", fe.indexed[`{"timestamp":"2024-01-02T03:04:05Z","@timestamp":"2024-01-02T03:04:05Z"}`])
+	assert.Equal("logs-sqlxe-default", fe.indexed[`{"@timestamp":"2024-01-02T03:04:05Z","n":2}`])
+}
+
+func TestElasticRetriesExhausted(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.URL.Path == "/_bulk" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"version":{"number":"7.17.0","build_flavor":"default"}}`)
+	}))
+	defer srv.Close()
+
+	es, err := NewElasticSink([]string{srv.URL}, "", "user", "pass")
+	assert.NoError(err)
+	es.MaxRetries = 2
+	es.RetryInterval = time.Millisecond
+	errs := es.WriteBatch(context.Background(), []Event{{Name: "login", Payload: "{}"}})
+	n, err := Delivered(1, errs)
+	assert.Equal(0, n)
+	assert.Error(err)
+}
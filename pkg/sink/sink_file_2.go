@@ -53,6 +53,11 @@ func (fs *OneFile) Write(ctx context.Context, name, event string) (int, error) {
 	return n, nil
 }
 
+// WriteBatch writes the events and syncs the file
+func (fs *OneFile) WriteBatch(ctx context.Context, events []Event) []error {
+	return WriteEach(ctx, fs, events)
+}
+
 // Close the OneFile
 func (fs *OneFile) Close() error {
 	return fs.r.Sync()
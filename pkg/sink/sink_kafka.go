@@ -0,0 +1,247 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// KafkaSink writes events to Kafka topics.  Records are batched by the
+// client and sent in the background.  Flush waits for every buffered
+// record to be acknowledged.
+type KafkaSink struct {
+	Brokers       []string
+	DefaultTopic  string
+	EventTopicMap map[string]string
+	Compression   string        // none, gzip, snappy, lz4, zstd
+	Acks          string        // all, leader, none
+	BatchBytes    int           // maximum size of a batch sent to a partition
+	Linger        time.Duration // how long to wait for a batch to fill
+
+	client *kgo.Client
+	logger *log.Entry
+
+	mu  sync.Mutex
+	err error // first produce error since the last flush
+}
+
+var _ Sinker = (*KafkaSink)(nil)
+
+// NewKafkaSink returns a new KafkaSink
+func NewKafkaSink(brokers []string, defaultTopic string) *KafkaSink {
+	return &KafkaSink{
+		Brokers:      brokers,
+		DefaultTopic: defaultTopic,
+		Compression:  "none",
+		Acks:         "all",
+		logger:       log.WithFields(log.Fields{}),
+	}
+}
+
+// Name returns the name of the sink
+func (ks *KafkaSink) Name() string {
+	return fmt.Sprintf("kafka: %s", strings.Join(ks.Brokers, ", "))
+}
+
+// Open connects to the brokers (id is ignored)
+func (ks *KafkaSink) Open(ctx context.Context, _ string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.client != nil {
+		return nil
+	}
+	opts, err := ks.options()
+	if err != nil {
+		return err
+	}
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return errors.Wrap(err, "kgo.newclient")
+	}
+	err = client.Ping(ctx)
+	if err != nil {
+		client.Close()
+		return errors.Wrap(err, "kafka.ping")
+	}
+	ks.client = client
+	return nil
+}
+
+// options converts the sink settings into client options
+func (ks *KafkaSink) options() ([]kgo.Opt, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(ks.Brokers...),
+		kgo.ClientID("sqlxewriter"),
+	}
+
+	switch strings.ToLower(ks.Compression) {
+	case "", "none":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "snappy":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	case "lz4":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case "zstd":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	default:
+		return nil, fmt.Errorf("invalid kafka compression: %s", ks.Compression)
+	}
+
+	// idempotent writes require acks from all in-sync replicas
+	switch strings.ToLower(ks.Acks) {
+	case "", "all":
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()), kgo.DisableIdempotentWrite())
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite())
+	default:
+		return nil, fmt.Errorf("invalid kafka acks: %s", ks.Acks)
+	}
+
+	if ks.BatchBytes > 0 {
+		opts = append(opts, kgo.ProducerBatchMaxBytes(int32(ks.BatchBytes)))
+	}
+	if ks.Linger > 0 {
+		opts = append(opts, kgo.ProducerLinger(ks.Linger))
+	}
+	return opts, nil
+}
+
+// topic returns the topic for an event name
+func (ks *KafkaSink) topic(name string) string {
+	topic, ok := ks.EventTopicMap[name]
+	if !ok {
+		topic = ks.DefaultTopic
+	}
+	return topic
+}
+
+// Write buffers the event to be sent to its topic
+func (ks *KafkaSink) Write(_ context.Context, name, event string) (int, error) {
+	ks.mu.Lock()
+	client := ks.client
+	err := ks.err
+	ks.mu.Unlock()
+	if client == nil {
+		return 0, errors.New("kafka: not open")
+	}
+	// stop buffering once the brokers have rejected something
+	if err != nil {
+		return 0, err
+	}
+
+	// a background context lets buffered records drain on shutdown
+	client.Produce(context.Background(), ks.record(name, event), ks.promise)
+	return len(event), nil
+}
+
+// WriteBatch produces the events and waits for the brokers to acknowledge each one
+func (ks *KafkaSink) WriteBatch(_ context.Context, events []Event) []error {
+	ks.mu.Lock()
+	client := ks.client
+	ks.mu.Unlock()
+	if client == nil {
+		return Reject(events, 0, errors.New("kafka: not open"))
+	}
+
+	errs := make([]error, len(events))
+	failed := false
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, e := range events {
+		wg.Add(1)
+		client.Produce(context.Background(), ks.record(e.Name, e.Payload), func(r *kgo.Record, err error) {
+			defer wg.Done()
+			if err != nil {
+				mu.Lock()
+				errs[i] = errors.Wrapf(err, "kafka.produce: topic: %s", r.Topic)
+				failed = true
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	if !failed {
+		return nil
+	}
+	return errs
+}
+
+func (ks *KafkaSink) record(name, event string) *kgo.Record {
+	return &kgo.Record{
+		Topic: ks.topic(name),
+		Key:   []byte(name),
+		Value: []byte(event),
+	}
+}
+
+// promise saves the first error returned for a record
+func (ks *KafkaSink) promise(r *kgo.Record, err error) {
+	if err == nil {
+		return
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.err == nil {
+		ks.err = errors.Wrapf(err, "kafka.produce: topic: %s", r.Topic)
+		ks.logger.Error(ks.err)
+	}
+}
+
+// Flush waits until every buffered record is acknowledged
+func (ks *KafkaSink) Flush() error {
+	ks.mu.Lock()
+	client := ks.client
+	ks.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	err := client.Flush(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "kafka.flush")
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	err = ks.err
+	ks.err = nil
+	return err
+}
+
+// Close flushes any buffered records and closes the client
+func (ks *KafkaSink) Close() error {
+	err := ks.Flush()
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.client != nil {
+		ks.client.Close()
+		ks.client = nil
+	}
+	return err
+}
+
+// Clean is a noop for kafka
+func (ks *KafkaSink) Clean() error {
+	return nil
+}
+
+// Reopen is a noop.  The client reconnects to brokers as needed.
+func (ks *KafkaSink) Reopen() error {
+	return nil
+}
+
+// SetLogger sets the logger for the sink
+func (ks *KafkaSink) SetLogger(entry *log.Entry) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.logger = entry
+}
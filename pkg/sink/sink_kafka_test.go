@@ -0,0 +1,87 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// consume reads n records from the topics on the fake cluster
+func consume(t *testing.T, brokers []string, n int, topics ...string) []*kgo.Record {
+	t.Helper()
+	cl, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ConsumeTopics(topics...),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	assert.NoError(t, err)
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	records := make([]*kgo.Record, 0)
+	for len(records) < n && ctx.Err() == nil {
+		fetches := cl.PollFetches(ctx)
+		records = append(records, fetches.Records()...)
+	}
+	return records
+}
+
+func TestKafkaTopicRouting(t *testing.T) {
+	assert := assert.New(t)
+	c, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "xe", "deadlocks"))
+	assert.NoError(err)
+	defer c.Close()
+
+	ks := NewKafkaSink(c.ListenAddrs(), "xe")
+	ks.EventTopicMap = map[string]string{"xml_deadlock_report": "deadlocks"}
+	ks.Compression = "gzip"
+	ks.Linger = 10 * time.Millisecond
+	ctx := context.Background()
+	assert.NoError(ks.Open(ctx, "id"))
+
+	n, err := ks.Write(ctx, "login", `{"name":"login"}`)
+	assert.NoError(err)
+	assert.Equal(16, n)
+	_, err = ks.Write(ctx, "xml_deadlock_report", `{"name":"xml_deadlock_report"}`)
+	assert.NoError(err)
+	_, err = ks.Write(ctx, "error_reported", `{"name":"error_reported"}`)
+	assert.NoError(err)
+	assert.NoError(ks.Flush())
+	assert.NoError(ks.Close())
+
+	records := consume(t, c.ListenAddrs(), 3, "xe", "deadlocks")
+	assert.Equal(3, len(records))
+	topics := make(map[string]string)
+	for _, r := range records {
+		topics[string(r.Key)] = r.Topic
+	}
+	assert.Equal("xe", topics["login"])
+	assert.Equal("deadlocks", topics["xml_deadlock_report"])
+	assert.Equal("xe", topics["error_reported"])
+}
+
+func TestKafkaOptions(t *testing.T) {
+	assert := assert.New(t)
+	ks := NewKafkaSink([]string{"localhost:9092"}, "xe")
+	_, err := ks.options()
+	assert.NoError(err)
+
+	ks.Acks = "leader"
+	ks.Compression = "zstd"
+	_, err = ks.options()
+	assert.NoError(err)
+
+	ks.Compression = "bzip"
+	_, err = ks.options()
+	assert.Error(err)
+
+	ks.Compression = "none"
+	ks.Acks = "some"
+	_, err = ks.options()
+	assert.Error(err)
+}
@@ -0,0 +1,189 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults for the OTLPSink
+const (
+	DefaultOTLPEndpoint = "http://localhost:4318/v1/logs"
+	DefaultOTLPService  = "sqlxewriter"
+	DefaultOTLPTimeout  = 30 * time.Second
+)
+
+// OTLPSink exports events as OpenTelemetry log records over OTLP/HTTP.
+// The mssql_* fields are the resource attributes and the other fields are
+// attributes of the log record.  Each batch is one export request.
+type OTLPSink struct {
+	Endpoint    string            // the full URL including /v1/logs
+	Encoding    string            // protobuf (default) or json
+	Headers     map[string]string // sent with each request such as an API key
+	ServiceName string            // the service.name resource attribute
+	Timeout     time.Duration
+
+	client *http.Client
+	logger *log.Entry
+}
+
+var _ Sinker = (*OTLPSink)(nil)
+
+// NewOTLPSink returns a new OTLPSink
+func NewOTLPSink(endpoint string) *OTLPSink {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	return &OTLPSink{
+		Endpoint:    endpoint,
+		Encoding:    "protobuf",
+		ServiceName: DefaultOTLPService,
+		Timeout:     DefaultOTLPTimeout,
+		logger:      log.WithFields(log.Fields{}),
+	}
+}
+
+// Name returns the name of the sink
+func (ot *OTLPSink) Name() string {
+	return fmt.Sprintf("otlp: %s", ot.Endpoint)
+}
+
+// Open checks the settings and creates the HTTP client (id is ignored)
+func (ot *OTLPSink) Open(_ context.Context, _ string) error {
+	switch strings.ToLower(ot.Encoding) {
+	case "", "protobuf", "json":
+	default:
+		return fmt.Errorf("invalid otlp encoding: %s", ot.Encoding)
+	}
+	ot.client = &http.Client{Timeout: ot.Timeout}
+	return nil
+}
+
+// Write exports one event
+func (ot *OTLPSink) Write(ctx context.Context, name, event string) (int, error) {
+	_, err := Delivered(1, ot.WriteBatch(ctx, []Event{{Name: name, Payload: event}}))
+	if err != nil {
+		return 0, err
+	}
+	return len(event), nil
+}
+
+// WriteBatch exports the events in one request.  The collector confirms
+// all of them or none of them.
+func (ot *OTLPSink) WriteBatch(ctx context.Context, events []Event) []error {
+	if len(events) == 0 {
+		return nil
+	}
+	if ot.client == nil {
+		return Reject(events, 0, errors.New("otlp: not open"))
+	}
+	resources, err := otlpRecords(events, ot.ServiceName)
+	if err != nil {
+		return Reject(events, 0, errors.Wrap(err, "otlp.records"))
+	}
+	var body []byte
+	contentType := "application/x-protobuf"
+	if strings.EqualFold(ot.Encoding, "json") {
+		contentType = "application/json"
+		body, err = otlpJSON(resources)
+		if err != nil {
+			return Reject(events, 0, errors.Wrap(err, "otlp.json"))
+		}
+	} else {
+		body = otlpProto(resources)
+	}
+	err = ot.post(ctx, contentType, body)
+	if err != nil {
+		return Reject(events, 0, err)
+	}
+	return nil
+}
+
+// post sends an export request.  A rejected record in a partial success
+// is logged but not retried as the OTLP specification requires.
+func (ot *OTLPSink) post(ctx context.Context, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ot.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "http.newrequest")
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range ot.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := ot.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "otlp.post")
+	}
+	defer resp.Body.Close()
+	rb, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return errors.Wrap(err, "otlp.read")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp: %s: %s", resp.Status, strings.TrimSpace(string(rb)))
+	}
+
+	var rejected int64
+	var msg string
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var r struct {
+			PartialSuccess struct {
+				RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+				ErrorMessage       string      `json:"errorMessage"`
+			} `json:"partialSuccess"`
+		}
+		if json.Unmarshal(rb, &r) == nil {
+			rejected, _ = r.PartialSuccess.RejectedLogRecords.Int64()
+			msg = r.PartialSuccess.ErrorMessage
+		}
+	} else {
+		rejected, msg = otlpPartialSuccess(rb)
+	}
+	if rejected > 0 || msg != "" {
+		ot.log().Warnf("otlp: rejected: %d: %s", rejected, msg)
+	}
+	return nil
+}
+
+func (ot *OTLPSink) log() *log.Entry {
+	if ot.logger == nil {
+		return log.WithFields(log.Fields{})
+	}
+	return ot.logger
+}
+
+// Flush does nothing.  WriteBatch waits for the collector.
+func (ot *OTLPSink) Flush() error {
+	return nil
+}
+
+// Close closes idle connections
+func (ot *OTLPSink) Close() error {
+	if ot.client != nil {
+		ot.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Clean does nothing
+func (ot *OTLPSink) Clean() error {
+	return nil
+}
+
+// Reopen does nothing
+func (ot *OTLPSink) Reopen() error {
+	return nil
+}
+
+// SetLogger sets the logger for the sink
+func (ot *OTLPSink) SetLogger(entry *log.Entry) {
+	ot.logger = entry
+}
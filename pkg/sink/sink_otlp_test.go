@@ -0,0 +1,189 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func otlpEvents() []Event {
+	ts := time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC)
+	return []Event{
+		{Name: "error_reported", Time: ts, Payload: `{"timestamp":"2026-03-09T12:00:00Z","name":"error_reported","mssql_server_name":"D40\\SQL2019","mssql_domain":"WORK","xe_severity_value":3,"xe_severity_keyword":"err","xe_description":"Login failed","error_number":18456,"duration":1.5,"tags":["a","b"],"extra":{"x":true},"nothing":null}`},
+		{Name: "login", Payload: `{"timestamp":"2026-03-09T12:00:01Z","name":"login","mssql_server_name":"D40\\SQL2019","mssql_domain":"WORK","xe_severity_value":6}`},
+		{Name: "login", Payload: `{"timestamp":"2026-03-09T12:00:02Z","name":"login","mssql_server_name":"B52","mssql_domain":"WORK","xe_severity_value":4}`},
+	}
+}
+
+func TestOTLPJSON(t *testing.T) {
+	assert := assert.New(t)
+	var got map[string]interface{}
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		b, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(b, &got)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	ot := NewOTLPSink(srv.URL + "/v1/logs")
+	ot.Encoding = "json"
+	ot.Headers = map[string]string{"api-key": "secret"}
+	assert.NoError(ot.Open(context.Background(), "id"))
+	assert.Nil(ot.WriteBatch(context.Background(), otlpEvents()))
+	assert.Equal("application/json", headers.Get("Content-Type"))
+	assert.Equal("secret", headers.Get("api-key"))
+
+	rls := got["resourceLogs"].([]interface{})
+	if !assert.Equal(2, len(rls)) {
+		return
+	}
+	rl := rls[0].(map[string]interface{})
+	attrs := rl["resource"].(map[string]interface{})["attributes"].([]interface{})
+	assert.Equal(3, len(attrs))
+	assert.Equal(map[string]interface{}{"key": "mssql_domain", "value": map[string]interface{}{"stringValue": "WORK"}}, attrs[0])
+	assert.Equal(map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "sqlxewriter"}}, attrs[2])
+
+	records := rl["scopeLogs"].([]interface{})[0].(map[string]interface{})["logRecords"].([]interface{})
+	if !assert.Equal(2, len(records)) {
+		return
+	}
+	rec := records[0].(map[string]interface{})
+	assert.Equal("1773057600000000000", rec["timeUnixNano"])
+	assert.Equal(17.0, rec["severityNumber"])
+	assert.Equal("ERROR", rec["severityText"])
+	assert.Equal(map[string]interface{}{"stringValue": "Login failed"}, rec["body"])
+	values := make(map[string]interface{})
+	for _, kv := range rec["attributes"].([]interface{}) {
+		m := kv.(map[string]interface{})
+		values[m["key"].(string)] = m["value"]
+	}
+	assert.Equal(map[string]interface{}{"intValue": "18456"}, values["error_number"])
+	assert.Equal(map[string]interface{}{"doubleValue": 1.5}, values["duration"])
+	assert.Equal(map[string]interface{}{"stringValue": "error_reported"}, values["event.name"])
+	assert.Contains(values, "tags")
+	assert.Contains(values, "extra")
+	assert.NotContains(values, "nothing")
+	assert.NotContains(values, "mssql_server_name")
+
+	rec = records[1].(map[string]interface{})
+	assert.Equal("1773057601000000000", rec["timeUnixNano"])
+	assert.Equal(9.0, rec["severityNumber"])
+	assert.Equal(map[string]interface{}{"stringValue": "login"}, rec["body"])
+}
+
+// protoFields returns the length delimited fields of a message by number
+func protoFields(b []byte) map[protowire.Number][][]byte {
+	fields := make(map[protowire.Number][][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		if typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			fields[num] = append(fields[num], v)
+			b = b[n:]
+			continue
+		}
+		b = b[protowire.ConsumeFieldValue(num, typ, b):]
+	}
+	return fields
+}
+
+func TestOTLPProtobuf(t *testing.T) {
+	assert := assert.New(t)
+	var body []byte
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+		// partial_success { rejected_log_records: 1 error_message: "bad" }
+		var ps []byte
+		ps = protowire.AppendTag(ps, 1, protowire.VarintType)
+		ps = protowire.AppendVarint(ps, 1)
+		ps = protowire.AppendTag(ps, 2, protowire.BytesType)
+		ps = protowire.AppendString(ps, "bad")
+		var resp []byte
+		resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+		resp = protowire.AppendBytes(resp, ps)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(resp)
+	}))
+	defer srv.Close()
+
+	ot := NewOTLPSink(srv.URL)
+	assert.NoError(ot.Open(context.Background(), "id"))
+	assert.Nil(ot.WriteBatch(context.Background(), otlpEvents()))
+	assert.Equal("application/x-protobuf", contentType)
+
+	rls := protoFields(body)[1]
+	if !assert.Equal(2, len(rls)) {
+		return
+	}
+	rl := protoFields(rls[0])
+	resource := protoFields(rl[1][0])
+	assert.Equal(3, len(resource[1]))
+	kv := protoFields(resource[1][0])
+	assert.Equal("mssql_domain", string(kv[1][0]))
+	assert.Equal("WORK", string(protoFields(kv[2][0])[1][0]))
+
+	scopeLogs := protoFields(rl[2][0])
+	assert.Equal("sqlxewriter", string(protoFields(scopeLogs[1][0])[1][0]))
+	records := scopeLogs[2]
+	if !assert.Equal(2, len(records)) {
+		return
+	}
+	rec := records[0]
+	num, typ, n := protowire.ConsumeTag(rec)
+	assert.Equal(protowire.Number(1), num)
+	assert.Equal(protowire.Fixed64Type, typ)
+	ts, _ := protowire.ConsumeFixed64(rec[n:])
+	assert.Equal(uint64(1773057600000000000), ts)
+	fields := protoFields(rec)
+	assert.Equal("ERROR", string(fields[3][0]))
+	assert.Equal("Login failed", string(protoFields(fields[5][0])[1][0]))
+
+	rejected, msg := otlpPartialSuccess([]byte{})
+	assert.Equal(int64(0), rejected)
+	assert.Equal("", msg)
+}
+
+func TestOTLPFailure(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ot := NewOTLPSink(srv.URL)
+	events := otlpEvents()
+	errs := ot.WriteBatch(context.Background(), events)
+	assert.Equal(len(events), len(errs))
+	assert.Contains(errs[0].Error(), "not open")
+
+	assert.NoError(ot.Open(context.Background(), "id"))
+	errs = ot.WriteBatch(context.Background(), events)
+	n, err := Delivered(len(events), errs)
+	assert.Equal(0, n)
+	assert.Error(err)
+	assert.Contains(err.Error(), "503")
+
+	ot.Encoding = "xml"
+	assert.Error(ot.Open(context.Background(), "id"))
+}
+
+func TestOTLPSeverity(t *testing.T) {
+	assert := assert.New(t)
+	for v, want := range map[int64]int{0: 21, 2: 21, 3: 17, 4: 13, 5: 10, 6: 9, 7: 5} {
+		got, _ := otlpSeverity(v)
+		assert.Equal(want, got, "severity %d", v)
+	}
+}
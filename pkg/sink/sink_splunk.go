@@ -0,0 +1,342 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// Defaults for the SplunkHECSink
+const (
+	DefaultSplunkSource           = "sqlxewriter"
+	DefaultSplunkSourceTypePrefix = "sqlxe:"
+	DefaultSplunkBatchBytes       = 1024 * 1024
+	DefaultSplunkTimeout          = 30 * time.Second
+	DefaultSplunkAckTimeout       = 60 * time.Second
+	DefaultSplunkAckInterval      = time.Second
+)
+
+// SplunkHECSink writes events to a Splunk HTTP Event Collector.  Events are
+// sent as HEC JSON envelopes in batches of BatchBytes.  With Ack set, the
+// indexer acknowledgement of each batch is checked before the batch is
+// confirmed.
+type SplunkHECSink struct {
+	URL              string // https://splunk:8088
+	Token            string
+	Source           string
+	SourceTypePrefix string // the sourcetype is the prefix and the event name
+	DefaultIndex     string // the token's default index if empty
+	EventIndexMap    map[string]string
+	BatchBytes       int
+	Timeout          time.Duration
+	Ack              bool          // check the HEC ack IDs
+	AckTimeout       time.Duration // how long to wait for the indexers
+	AckInterval      time.Duration // how often to check the acks
+
+	channel string
+	client  *http.Client
+	logger  *log.Entry
+}
+
+var _ Sinker = (*SplunkHECSink)(nil)
+
+// NewSplunkHECSink returns a new SplunkHECSink
+func NewSplunkHECSink(url, token string) *SplunkHECSink {
+	return &SplunkHECSink{
+		URL:              strings.TrimSuffix(url, "/"),
+		Token:            token,
+		Source:           DefaultSplunkSource,
+		SourceTypePrefix: DefaultSplunkSourceTypePrefix,
+		BatchBytes:       DefaultSplunkBatchBytes,
+		Timeout:          DefaultSplunkTimeout,
+		AckTimeout:       DefaultSplunkAckTimeout,
+		AckInterval:      DefaultSplunkAckInterval,
+		logger:           log.WithFields(log.Fields{}),
+	}
+}
+
+// Name returns the name of the sink
+func (hec *SplunkHECSink) Name() string {
+	return fmt.Sprintf("splunk: %s", hec.URL)
+}
+
+// Open creates the HTTP client and the channel for the acks (id is ignored)
+func (hec *SplunkHECSink) Open(_ context.Context, _ string) error {
+	if hec.URL == "" {
+		return errors.New("splunk: missing url")
+	}
+	if hec.Token == "" {
+		return errors.New("splunk: missing token")
+	}
+	channel, err := newChannel()
+	if err != nil {
+		return errors.Wrap(err, "splunk.channel")
+	}
+	hec.channel = channel
+	hec.client = &http.Client{Timeout: hec.Timeout}
+	return nil
+}
+
+// newChannel returns a random GUID to identify the client to HEC
+func newChannel() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:]), nil
+}
+
+// index returns the index for an event name
+func (hec *SplunkHECSink) index(name string) string {
+	index, ok := hec.EventIndexMap[name]
+	if !ok {
+		index = hec.DefaultIndex
+	}
+	return index
+}
+
+// splunkEnvelope is the HEC JSON for one event
+type splunkEnvelope struct {
+	Time       json.Number     `json:"time,omitempty"`
+	Host       string          `json:"host,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	SourceType string          `json:"sourcetype,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// envelope wraps an event.  The time is the event time in seconds with
+// milliseconds.  The host is the mssql_server_name.
+func (hec *SplunkHECSink) envelope(ev Event) ([]byte, error) {
+	if !gjson.Valid(ev.Payload) {
+		return nil, errors.New("splunk: invalid json")
+	}
+	ts := ev.Time
+	if ts.IsZero() {
+		ts, _ = time.Parse(time.RFC3339Nano, gjson.Get(ev.Payload, "timestamp").String())
+	}
+	env := splunkEnvelope{
+		Host:       gjson.Get(ev.Payload, "mssql_server_name").String(),
+		Source:     hec.Source,
+		SourceType: hec.SourceTypePrefix + ev.Name,
+		Index:      hec.index(ev.Name),
+		Event:      json.RawMessage(ev.Payload),
+	}
+	if !ts.IsZero() {
+		env.Time = json.Number(strconv.FormatFloat(float64(ts.UnixMilli())/1000, 'f', 3, 64))
+	}
+	return json.Marshal(env)
+}
+
+// Write sends one event
+func (hec *SplunkHECSink) Write(ctx context.Context, name, event string) (int, error) {
+	_, err := Delivered(1, hec.WriteBatch(ctx, []Event{{Name: name, Payload: event}}))
+	if err != nil {
+		return 0, err
+	}
+	return len(event), nil
+}
+
+// splunkChunk is one request to HEC
+type splunkChunk struct {
+	first int // index of the first event in the chunk
+	body  bytes.Buffer
+	ackID int64
+}
+
+// WriteBatch sends the events in requests of up to BatchBytes and waits
+// for the acks.  A failed request or ack rejects that request and every
+// event after it.  If a request fails the ones before it are still checked.
+func (hec *SplunkHECSink) WriteBatch(ctx context.Context, events []Event) []error {
+	if len(events) == 0 {
+		return nil
+	}
+	if hec.client == nil {
+		return Reject(events, 0, errors.New("splunk: not open"))
+	}
+	chunks := make([]*splunkChunk, 0)
+	var cur *splunkChunk
+	for i, ev := range events {
+		b, err := hec.envelope(ev)
+		if err != nil {
+			return Reject(events, i, errors.Wrap(err, "splunk.envelope"))
+		}
+		if cur == nil || (hec.BatchBytes > 0 && cur.body.Len() > 0 && cur.body.Len()+len(b) > hec.BatchBytes) {
+			cur = &splunkChunk{first: i}
+			chunks = append(chunks, cur)
+		}
+		cur.body.Write(b)
+	}
+
+	posted := len(chunks)
+	var postErr error
+	for i, c := range chunks {
+		ackID, err := hec.post(ctx, c.body.Bytes())
+		if err != nil {
+			posted, postErr = i, err
+			break
+		}
+		c.ackID = ackID
+	}
+	if hec.Ack && posted > 0 {
+		// the chunks before a failed one only count once they are acked
+		first, err := hec.waitAcks(ctx, chunks[:posted])
+		if err != nil {
+			return Reject(events, first, err)
+		}
+	}
+	if postErr != nil {
+		return Reject(events, chunks[posted].first, postErr)
+	}
+	return nil
+}
+
+// post sends one request and returns the ack ID
+func (hec *SplunkHECSink) post(ctx context.Context, body []byte) (int64, error) {
+	var r struct {
+		Text  string `json:"text"`
+		Code  int    `json:"code"`
+		AckID *int64 `json:"ackId"`
+	}
+	err := hec.do(ctx, "/services/collector/event", body, &r)
+	if err != nil {
+		return 0, err
+	}
+	if r.Code != 0 {
+		return 0, fmt.Errorf("splunk: code: %d: %s", r.Code, r.Text)
+	}
+	if hec.Ack && r.AckID == nil {
+		return 0, errors.New("splunk: no ackId (is indexer acknowledgement enabled on the token?)")
+	}
+	if r.AckID == nil {
+		return 0, nil
+	}
+	return *r.AckID, nil
+}
+
+// waitAcks checks the ack IDs until the indexers confirm every chunk.
+// It returns the first event of the first chunk that wasn't confirmed.
+func (hec *SplunkHECSink) waitAcks(ctx context.Context, chunks []*splunkChunk) (int, error) {
+	pending := make(map[int64]*splunkChunk)
+	for _, c := range chunks {
+		pending[c.ackID] = c
+	}
+	deadline := time.Now().Add(hec.AckTimeout)
+	for {
+		ids := make([]int64, 0, len(pending))
+		for id := range pending {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		body, err := json.Marshal(map[string][]int64{"acks": ids})
+		if err != nil {
+			return 0, errors.Wrap(err, "json.marshal")
+		}
+		var r struct {
+			Acks map[string]bool `json:"acks"`
+		}
+		err = hec.do(ctx, "/services/collector/ack", body, &r)
+		if err != nil {
+			return firstPending(pending), errors.Wrap(err, "splunk.ack")
+		}
+		for id := range pending {
+			if r.Acks[strconv.FormatInt(id, 10)] {
+				delete(pending, id)
+			}
+		}
+		if len(pending) == 0 {
+			return 0, nil
+		}
+		if time.Now().After(deadline) {
+			return firstPending(pending), fmt.Errorf("splunk: %d batches not acknowledged after %s", len(pending), hec.AckTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return firstPending(pending), ctx.Err()
+		case <-time.After(hec.AckInterval):
+		}
+	}
+}
+
+func firstPending(pending map[int64]*splunkChunk) int {
+	first := -1
+	for _, c := range pending {
+		if first < 0 || c.first < first {
+			first = c.first
+		}
+	}
+	return first
+}
+
+// do posts to HEC and decodes the response
+func (hec *SplunkHECSink) do(ctx context.Context, path string, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hec.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "http.newrequest")
+	}
+	req.Header.Set("Authorization", "Splunk "+hec.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Splunk-Request-Channel", hec.channel)
+	resp, err := hec.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "splunk.post")
+	}
+	defer resp.Body.Close()
+	rb, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return errors.Wrap(err, "splunk.read")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("splunk: %s: %s", resp.Status, strings.TrimSpace(string(rb)))
+	}
+	err = json.Unmarshal(rb, v)
+	if err != nil {
+		return errors.Wrap(err, "splunk: json.unmarshal")
+	}
+	return nil
+}
+
+// Flush does nothing.  WriteBatch waits for HEC.
+func (hec *SplunkHECSink) Flush() error {
+	return nil
+}
+
+// Close closes idle connections
+func (hec *SplunkHECSink) Close() error {
+	if hec.client != nil {
+		hec.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Clean does nothing
+func (hec *SplunkHECSink) Clean() error {
+	return nil
+}
+
+// Reopen does nothing
+func (hec *SplunkHECSink) Reopen() error {
+	return nil
+}
+
+// SetLogger sets the logger for the sink
+func (hec *SplunkHECSink) SetLogger(entry *log.Entry) {
+	hec.logger = entry
+}
@@ -0,0 +1,176 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hecServer is a fake HTTP Event Collector.  Acks are confirmed on the
+// second check unless never is set.  Requests after the first failAfter
+// fail if it is set.
+type hecServer struct {
+	mu        sync.Mutex
+	requests  [][]map[string]interface{}
+	checks    map[int64]int
+	never     bool
+	failAfter int
+	auth      string
+	channel   string
+	nextAck   int64
+}
+
+func (h *hecServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.auth = r.Header.Get("Authorization")
+	h.channel = r.Header.Get("X-Splunk-Request-Channel")
+	b, _ := io.ReadAll(r.Body)
+	switch r.URL.Path {
+	case "/services/collector/event":
+		if h.failAfter > 0 && len(h.requests) >= h.failAfter {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"text":"Server is busy","code":9}`))
+			return
+		}
+		var envs []map[string]interface{}
+		d := json.NewDecoder(bytes.NewReader(b))
+		for d.More() {
+			var env map[string]interface{}
+			_ = d.Decode(&env)
+			envs = append(envs, env)
+		}
+		h.requests = append(h.requests, envs)
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, h.nextAck)
+		h.nextAck++
+	case "/services/collector/ack":
+		var req struct{ Acks []int64 }
+		_ = json.Unmarshal(b, &req)
+		acks := make(map[string]bool)
+		for _, id := range req.Acks {
+			h.checks[id]++
+			acks[fmt.Sprint(id)] = !h.never && h.checks[id] > 1
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"acks": acks})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func splunkEvents() []Event {
+	return []Event{
+		{Name: "login", Time: time.Date(2026, 3, 9, 12, 0, 0, 250000000, time.UTC), Payload: `{"name":"login","mssql_server_name":"D40\\SQL2019","server_principal_name":"bill"}`},
+		{Name: "error_reported", Payload: `{"timestamp":"2026-03-09T12:00:01Z","name":"error_reported","mssql_server_name":"B52"}`},
+		{Name: "login", Payload: `{"name":"login","mssql_server_name":"B52"}`},
+	}
+}
+
+func TestSplunkHEC(t *testing.T) {
+	assert := assert.New(t)
+	h := &hecServer{checks: make(map[int64]int)}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	hec := NewSplunkHECSink(srv.URL+"/", "token-1")
+	hec.DefaultIndex = "sqlxe"
+	hec.EventIndexMap = map[string]string{"login": "security"}
+	hec.Ack = true
+	hec.AckInterval = time.Millisecond
+	hec.BatchBytes = 450
+	assert.NoError(hec.Open(context.Background(), "id"))
+	assert.Nil(hec.WriteBatch(context.Background(), splunkEvents()))
+
+	assert.Equal("Splunk token-1", h.auth)
+	assert.Len(h.channel, 36)
+	if !assert.Equal(2, len(h.requests)) {
+		return
+	}
+	assert.Equal(2, len(h.requests[0]))
+	assert.Equal(1, len(h.requests[1]))
+	env := h.requests[0][0]
+	assert.Equal(1773057600.25, env["time"])
+	assert.Equal(`D40\SQL2019`, env["host"])
+	assert.Equal("sqlxewriter", env["source"])
+	assert.Equal("sqlxe:login", env["sourcetype"])
+	assert.Equal("security", env["index"])
+	assert.Equal("bill", env["event"].(map[string]interface{})["server_principal_name"])
+	env = h.requests[0][1]
+	assert.Equal(1773057601.0, env["time"])
+	assert.Equal("sqlxe:error_reported", env["sourcetype"])
+	assert.Equal("sqlxe", env["index"])
+	assert.Equal(2, h.checks[0])
+	assert.Equal(2, h.checks[1])
+}
+
+func TestSplunkHECFailures(t *testing.T) {
+	assert := assert.New(t)
+	h := &hecServer{checks: make(map[int64]int), never: true}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	hec := NewSplunkHECSink(srv.URL, "token")
+	errs := hec.WriteBatch(context.Background(), splunkEvents())
+	assert.Contains(errs[0].Error(), "not open")
+
+	// the acks never arrive
+	hec.Ack = true
+	hec.AckInterval = time.Millisecond
+	hec.AckTimeout = 20 * time.Millisecond
+	assert.NoError(hec.Open(context.Background(), "id"))
+	n, err := Delivered(3, hec.WriteBatch(context.Background(), splunkEvents()))
+	assert.Equal(0, n)
+	assert.Contains(err.Error(), "not acknowledged")
+
+	// the second request fails and the first is never acked
+	hec.BatchBytes = 450
+	h.failAfter = len(h.requests) + 1
+	n, err = Delivered(3, hec.WriteBatch(context.Background(), splunkEvents()))
+	assert.Equal(0, n)
+	assert.Contains(err.Error(), "not acknowledged")
+
+	// the first is acked so only the second is rejected
+	h.never = false
+	h.failAfter = len(h.requests) + 1
+	n, err = Delivered(3, hec.WriteBatch(context.Background(), splunkEvents()))
+	assert.Equal(2, n)
+	assert.Contains(err.Error(), "Server is busy")
+	h.failAfter = 0
+
+	// no acks without ack = true
+	hec.Ack = false
+	assert.Nil(hec.WriteBatch(context.Background(), splunkEvents()))
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"text":"Invalid token","code":4}`))
+	}))
+	defer bad.Close()
+	hec = NewSplunkHECSink(bad.URL, "wrong")
+	assert.NoError(hec.Open(context.Background(), "id"))
+	n, err = Delivered(3, hec.WriteBatch(context.Background(), splunkEvents()))
+	assert.Equal(0, n)
+	assert.Contains(err.Error(), "Invalid token")
+
+	assert.Error(NewSplunkHECSink(bad.URL, "").Open(context.Background(), "id"))
+	_, err = NewSplunkHECSink(bad.URL, "token").envelope(Event{Name: "x", Payload: "{"})
+	assert.Error(err)
+}
+
+func TestSplunkChannel(t *testing.T) {
+	assert := assert.New(t)
+	a, err := newChannel()
+	assert.NoError(err)
+	b, err := newChannel()
+	assert.NoError(err)
+	assert.NotEqual(a, b)
+	assert.Regexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, a)
+}
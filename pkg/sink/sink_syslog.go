@@ -0,0 +1,334 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// Defaults for the SyslogSink
+const (
+	DefaultSyslogFacility = 16 // local0
+	DefaultSyslogAppName  = "sqlxewriter"
+	DefaultSyslogTimeout  = 30 * time.Second
+)
+
+// SyslogSink writes events as RFC 5424 syslog messages over UDP, TCP or
+// TLS.  TCP and TLS use octet-counting framing (RFC 6587 and RFC 5425).
+// xe_severity_value is the severity, mssql_server_name is the HOSTNAME
+// and the event name is the MSGID.
+type SyslogSink struct {
+	Network  string // udp, tcp or tls
+	Address  string // host:port
+	Facility int
+	AppName  string
+	Format   string    // json (default) or description for xe_description
+	Output   Formatter // formats the message instead of Format such as CEF
+	Timeout  time.Duration
+
+	CAFile   string // verify the server with these CAs instead of the system CAs
+	CertFile string // client certificate for TLS
+	KeyFile  string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	w      *bufio.Writer
+	tls    *tls.Config
+	procID string
+	logger *log.Entry
+}
+
+var _ Sinker = (*SyslogSink)(nil)
+
+// syslogFacilities are the RFC 5424 facility codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogFacility returns the code for a facility name such as local0
+func SyslogFacility(name string) (int, error) {
+	f, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("invalid syslog facility: %s", name)
+	}
+	return f, nil
+}
+
+// NewSyslogSink returns a new SyslogSink
+func NewSyslogSink(network, address string) *SyslogSink {
+	return &SyslogSink{
+		Network:  strings.ToLower(network),
+		Address:  address,
+		Facility: DefaultSyslogFacility,
+		AppName:  DefaultSyslogAppName,
+		Format:   "json",
+		Timeout:  DefaultSyslogTimeout,
+		procID:   strconv.Itoa(os.Getpid()),
+		logger:   log.WithFields(log.Fields{}),
+	}
+}
+
+// Name returns the name of the sink
+func (ss *SyslogSink) Name() string {
+	return fmt.Sprintf("syslog: %s://%s", ss.Network, ss.Address)
+}
+
+// Open checks the settings and connects (id is ignored)
+func (ss *SyslogSink) Open(_ context.Context, _ string) error {
+	switch ss.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("invalid syslog network: %s", ss.Network)
+	}
+	switch strings.ToLower(ss.Format) {
+	case "", "json", "description":
+	default:
+		return fmt.Errorf("invalid syslog format: %s", ss.Format)
+	}
+	if ss.Facility < 0 || ss.Facility > 23 {
+		return fmt.Errorf("invalid syslog facility: %d", ss.Facility)
+	}
+	if ss.Network == "tls" {
+		cfg, err := ss.tlsConfig()
+		if err != nil {
+			return err
+		}
+		ss.tls = cfg
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.connect()
+}
+
+// tlsConfig loads the CA and client certificate files
+func (ss *SyslogSink) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ss.CAFile != "" {
+		pem, err := os.ReadFile(ss.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "syslog: ca_file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("syslog: ca_file: no certificates: %s", ss.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if ss.CertFile != "" || ss.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(ss.CertFile, ss.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "syslog: cert_file")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// connect dials the server.  The caller holds the lock.
+func (ss *SyslogSink) connect() error {
+	if ss.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: ss.Timeout}
+	var conn net.Conn
+	var err error
+	if ss.Network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", ss.Address, ss.tls)
+	} else {
+		conn, err = dialer.Dial(ss.Network, ss.Address)
+	}
+	if err != nil {
+		return errors.Wrap(err, "syslog.dial")
+	}
+	ss.conn = conn
+	ss.w = bufio.NewWriter(conn)
+	return nil
+}
+
+// disconnect closes a connection after an error so the next write
+// reconnects.  The caller holds the lock.
+func (ss *SyslogSink) disconnect() {
+	if ss.conn != nil {
+		_ = ss.conn.Close()
+	}
+	ss.conn = nil
+	ss.w = nil
+}
+
+// header returns a header field of printable ASCII without spaces.  An
+// empty field is the NILVALUE.
+func header(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if s[i] > 32 && s[i] < 127 {
+			b = append(b, s[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// message formats an event as an RFC 5424 message
+func (ss *SyslogSink) message(name, event string) ([]byte, error) {
+	severity := int64(6)
+	if v := gjson.Get(event, "xe_severity_value"); v.Exists() {
+		severity = v.Int()
+	}
+	if severity < 0 || severity > 7 {
+		severity = 6
+	}
+	ts := "-"
+	if t, err := time.Parse(time.RFC3339Nano, gjson.Get(event, "timestamp").String()); err == nil {
+		ts = t.Format("2006-01-02T15:04:05.000000Z07:00")
+	}
+	msg := event
+	if ss.Output != nil {
+		var err error
+		msg, err = ss.Output.Format(name, event)
+		if err != nil {
+			return nil, err
+		}
+	} else if strings.EqualFold(ss.Format, "description") {
+		if desc := gjson.Get(event, "xe_description").String(); desc != "" {
+			msg = desc
+		}
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %s %s - %s",
+		ss.Facility*8+int(severity),
+		ts,
+		header(gjson.Get(event, "mssql_server_name").String(), 255),
+		header(ss.AppName, 48),
+		header(ss.procID, 128),
+		header(name, 32),
+		msg,
+	)), nil
+}
+
+// Write sends one message.  TCP and TLS messages are buffered until Flush.
+func (ss *SyslogSink) Write(_ context.Context, name, event string) (int, error) {
+	msg, err := ss.message(name, event)
+	if err != nil {
+		return 0, errors.Wrap(err, "syslog.message")
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	err = ss.connect()
+	if err != nil {
+		return 0, err
+	}
+	if ss.Timeout > 0 {
+		_ = ss.conn.SetWriteDeadline(time.Now().Add(ss.Timeout))
+	}
+	if ss.Network == "udp" {
+		n, err := ss.conn.Write(msg)
+		if err != nil {
+			ss.disconnect()
+			return n, errors.Wrap(err, "syslog.write")
+		}
+		return n, nil
+	}
+	n, err := fmt.Fprintf(ss.w, "%d %s", len(msg), msg)
+	if err != nil {
+		ss.disconnect()
+		return n, errors.Wrap(err, "syslog.write")
+	}
+	return n, nil
+}
+
+// WriteBatch writes the events and flushes.  An event is confirmed once
+// the write and the flush succeed.  A failed TCP or TLS write closes the
+// connection and drops the buffer so it rejects every event written since
+// the last flush.
+func (ss *SyslogSink) WriteBatch(ctx context.Context, events []Event) []error {
+	first := 0 // the first event that hasn't been flushed
+	for i, e := range events {
+		_, err := ss.Write(ctx, e.Name, e.Payload)
+		if err != nil {
+			err = errors.Wrap(err, "write")
+			if !ss.connected() {
+				return Reject(events, first, err)
+			}
+			// the event couldn't be formatted.  Send the ones before it.
+			flushErr := ss.Flush()
+			if flushErr != nil {
+				return Reject(events, first, errors.Wrap(flushErr, "flush"))
+			}
+			return Reject(events, i, err)
+		}
+		if ss.Network == "udp" {
+			first = i + 1
+		}
+	}
+	err := ss.Flush()
+	if err != nil {
+		return Reject(events, first, errors.Wrap(err, "flush"))
+	}
+	return nil
+}
+
+// connected is false after an error closed the connection
+func (ss *SyslogSink) connected() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.conn != nil
+}
+
+// Flush sends the buffered TCP and TLS messages
+func (ss *SyslogSink) Flush() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.w == nil {
+		return nil
+	}
+	if ss.Timeout > 0 {
+		_ = ss.conn.SetWriteDeadline(time.Now().Add(ss.Timeout))
+	}
+	err := ss.w.Flush()
+	if err != nil {
+		ss.disconnect()
+		return errors.Wrap(err, "syslog.flush")
+	}
+	return nil
+}
+
+// Close flushes and closes the connection
+func (ss *SyslogSink) Close() error {
+	err := ss.Flush()
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.disconnect()
+	return err
+}
+
+// Clean does nothing
+func (ss *SyslogSink) Clean() error {
+	return nil
+}
+
+// Reopen does nothing.  A write after an error reconnects.
+func (ss *SyslogSink) Reopen() error {
+	return nil
+}
+
+// SetLogger sets the logger for the sink
+func (ss *SyslogSink) SetLogger(entry *log.Entry) {
+	ss.logger = entry
+}
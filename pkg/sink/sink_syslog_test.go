@@ -0,0 +1,176 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogMessage(t *testing.T) {
+	assert := assert.New(t)
+	ss := NewSyslogSink("udp", "localhost:514")
+	ss.procID = "42"
+	event := `{"timestamp":"2024-03-01T10:11:12.1234567Z","mssql_server_name":"SQL 01","xe_severity_value":3,"xe_description":"Login failed for user 'sa'."}`
+	msg, err := ss.message("login_failed", event)
+	assert.NoError(err)
+	assert.Equal(`<131>1 2024-03-01T10:11:12.123456Z SQL01 sqlxewriter 42 login_failed - `+event, string(msg))
+
+	ss.Format = "description"
+	ss.Facility = 4
+	msg, err = ss.message("this_event_name_is_longer_than_thirty_two", `{"xe_severity_value":4,"xe_description":"Memory pressure"}`)
+	assert.NoError(err)
+	assert.Equal(`<36>1 - - sqlxewriter 42 this_event_name_is_longer_than_t - Memory pressure`, string(msg))
+
+	// no severity is informational
+	msg, err = ss.message("", `{}`)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(string(msg), "<38>1 - - sqlxewriter 42 - - "))
+
+	ss.Output = &CEFFormatter{}
+	msg, err = ss.message("login", `{"xe_severity_value":6}`)
+	assert.NoError(err)
+	assert.Equal(`<38>1 - - sqlxewriter 42 login - CEF:0|Microsoft|SQL Server||login|login|3|`, string(msg))
+}
+
+func TestSyslogUDP(t *testing.T) {
+	assert := assert.New(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	defer pc.Close()
+
+	ss := NewSyslogSink("udp", pc.LocalAddr().String())
+	ctx := context.Background()
+	assert.NoError(ss.Open(ctx, ""))
+	defer ss.Close()
+	errs := ss.WriteBatch(ctx, []Event{
+		{Name: "error_reported", Payload: `{"mssql_server_name":"D40","xe_severity_value":3}`},
+		{Name: "login", Payload: `{"mssql_server_name":"D40","xe_severity_value":6}`},
+	})
+	assert.Nil(errs)
+
+	buf := make([]byte, 2048)
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, prefix := range []string{"<131>1 - D40 sqlxewriter ", "<134>1 - D40 sqlxewriter "} {
+		n, _, err := pc.ReadFrom(buf)
+		if assert.NoError(err) {
+			assert.True(strings.HasPrefix(string(buf[:n]), prefix), string(buf[:n]))
+		}
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	assert := assert.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	defer ln.Close()
+	msgs := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			// octet-counting: MSG-LEN SP SYSLOG-MSG
+			s, err := r.ReadString(' ')
+			if err != nil {
+				close(msgs)
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				msgs <- fmt.Sprintf("bad length: %q", s)
+				return
+			}
+			b := make([]byte, n)
+			_, err = io.ReadFull(r, b)
+			if err != nil {
+				return
+			}
+			msgs <- string(b)
+		}
+	}()
+
+	ss := NewSyslogSink("tcp", ln.Addr().String())
+	ctx := context.Background()
+	assert.NoError(ss.Open(ctx, ""))
+	errs := ss.WriteBatch(ctx, []Event{
+		{Name: "error_reported", Payload: `{"xe_severity_value":4,"msg":"a b\nc"}`},
+		{Name: "login", Payload: `{"xe_severity_value":6}`},
+	})
+	assert.Nil(errs)
+	assert.NoError(ss.Close())
+
+	got := make([]string, 0)
+	for m := range msgs {
+		got = append(got, m)
+	}
+	if assert.Equal(2, len(got)) {
+		assert.True(strings.HasPrefix(got[0], "<132>1 "), got[0])
+		assert.True(strings.HasSuffix(got[0], ` error_reported - {"xe_severity_value":4,"msg":"a b\nc"}`), got[0])
+		assert.True(strings.HasPrefix(got[1], "<134>1 "), got[1])
+	}
+}
+
+// brokenConn fails every write
+type brokenConn struct {
+	net.Conn
+	closed bool
+}
+
+func (c *brokenConn) Write([]byte) (int, error)        { return 0, errors.New("connection reset") }
+func (c *brokenConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *brokenConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSyslogTCPWriteFails(t *testing.T) {
+	assert := assert.New(t)
+	ss := NewSyslogSink("tcp", "localhost:514")
+	conn := &brokenConn{}
+	ss.conn = conn
+	ss.w = bufio.NewWriter(conn)
+
+	// the first message is buffered.  The second doesn't fit so the write
+	// fails and the first is lost with the buffer.
+	errs := ss.WriteBatch(context.Background(), []Event{
+		{Name: "login", Payload: `{}`},
+		{Name: "login", Payload: `{"a":"` + strings.Repeat("x", 5000) + `"}`},
+		{Name: "login", Payload: `{}`},
+	})
+	n, err := Delivered(3, errs)
+	assert.Equal(0, n)
+	assert.Contains(err.Error(), "connection reset")
+	assert.True(conn.closed)
+}
+
+func TestSyslogOpen(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	ss := NewSyslogSink("http", "localhost:514")
+	assert.Error(ss.Open(ctx, ""))
+	ss = NewSyslogSink("udp", "localhost:514")
+	ss.Format = "xml"
+	assert.Error(ss.Open(ctx, ""))
+
+	f, err := SyslogFacility("LOCAL7")
+	assert.NoError(err)
+	assert.Equal(23, f)
+	_, err = SyslogFacility("local8")
+	assert.Error(err)
+}
@@ -0,0 +1,555 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/prom"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrSpoolFull is returned when writing an event would use more disk than allowed
+var ErrSpoolFull = errors.New("spool full")
+
+const (
+	spoolExtension   = ".seg"
+	spoolCursorFile  = "cursor"
+	spoolBatch       = 500
+	spoolIdle        = time.Second
+	spoolMaxBackoff  = time.Minute
+	defaultMaxBytes  = 1024 * 1024 * 1024
+	defaultSegBytes  = 16 * 1024 * 1024
+	spoolSegmentName = "%016d" + spoolExtension
+)
+
+// spoolRecord is one line in a segment file
+type spoolRecord struct {
+	Time  int64  `json:"t"` // unix milliseconds when the event was spooled
+	Name  string `json:"n"`
+	Event string `json:"e"`
+	end   int64  // offset after this record in the segment
+}
+
+// Spool is a write-ahead log in front of another sink.  Events are
+// appended to segment files and a background routine drains them to the
+// wrapped sink.  A slow or broken sink doesn't block polling until the
+// spool runs out of disk.
+type Spool struct {
+	Dir          string
+	MaxBytes     int64 // disk used by all segments
+	SegmentBytes int64 // size before starting a new segment
+
+	next   Sinker
+	logger *log.Entry
+
+	mu       sync.Mutex
+	wf       *os.File // current write segment
+	wseq     int64
+	wsize    int64
+	bytes    int64 // size of all segments on disk
+	depth    int64 // events not yet written to the wrapped sink
+	oldest   time.Time
+	notify   chan struct{}
+	cancel   context.CancelFunc
+	done     chan struct{}
+	nextOpen bool
+}
+
+var _ Sinker = (*Spool)(nil)
+
+// NewSpool returns a Spool that writes to next.  Segments are stored in dir.
+func NewSpool(next Sinker, dir string) *Spool {
+	return &Spool{
+		Dir:          dir,
+		MaxBytes:     defaultMaxBytes,
+		SegmentBytes: defaultSegBytes,
+		next:         next,
+		logger:       log.WithFields(log.Fields{}),
+		notify:       make(chan struct{}, 1),
+	}
+}
+
+// Name returns the name of the wrapped sink
+func (s *Spool) Name() string {
+	return fmt.Sprintf("spool: %s", s.next.Name())
+}
+
+// Open loads any segments left from a previous run and starts draining them.
+// The wrapped sink is opened by the drain routine so an outage at startup
+// doesn't stop the application.
+func (s *Spool) Open(ctx context.Context, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done != nil {
+		return nil
+	}
+
+	err := os.MkdirAll(s.Dir, 0750)
+	if err != nil {
+		return errors.Wrap(err, "spool.mkdir")
+	}
+	segs, err := s.segments()
+	if err != nil {
+		return errors.Wrap(err, "spool.segments")
+	}
+	seq, offset, err := s.readCursor()
+	if err != nil {
+		return errors.Wrap(err, "spool.readcursor")
+	}
+
+	s.bytes = 0
+	s.depth = 0
+	for _, n := range segs {
+		fi, err := os.Stat(s.segmentName(n))
+		if err != nil {
+			return errors.Wrap(err, "spool.stat")
+		}
+		s.bytes += fi.Size()
+		start := int64(0)
+		if n < seq {
+			continue
+		}
+		if n == seq {
+			start = offset
+		}
+		count, err := countRecords(s.segmentName(n), start)
+		if err != nil {
+			return errors.Wrap(err, "spool.count")
+		}
+		s.depth += count
+	}
+
+	s.wseq = 1
+	if len(segs) > 0 {
+		s.wseq = segs[len(segs)-1]
+	}
+	err = s.openSegment()
+	if err != nil {
+		return err
+	}
+	s.setMetrics()
+
+	dctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.drain(dctx)
+	return nil
+}
+
+// Write appends the event to the current segment
+func (s *Spool) Write(_ context.Context, name, event string) (int, error) {
+	b, err := json.Marshal(spoolRecord{Time: time.Now().UnixMilli(), Name: name, Event: event})
+	if err != nil {
+		return 0, errors.Wrap(err, "spool.marshal")
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wf == nil {
+		return 0, errors.New("spool: not open")
+	}
+	if s.MaxBytes > 0 && s.bytes+int64(len(b)) > s.MaxBytes {
+		return 0, errors.Wrapf(ErrSpoolFull, "%s: %d bytes", s.Dir, s.bytes)
+	}
+	if s.SegmentBytes > 0 && s.wsize >= s.SegmentBytes {
+		err = s.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.wf.Write(b)
+	s.wsize += int64(n)
+	s.bytes += int64(n)
+	if err != nil {
+		return n, errors.Wrap(err, "spool.write")
+	}
+	if s.depth == 0 {
+		s.oldest = time.Now()
+	}
+	s.depth++
+	s.setMetrics()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return n, nil
+}
+
+// WriteBatch appends the events to the spool and syncs it to disk.
+// Events are confirmed once they are on disk.
+func (s *Spool) WriteBatch(ctx context.Context, events []Event) []error {
+	return WriteEach(ctx, s, events)
+}
+
+// Flush syncs the current segment to disk.  Once this returns the events
+// are durable even if the wrapped sink hasn't received them yet.
+func (s *Spool) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wf == nil {
+		return nil
+	}
+	return errors.Wrap(s.wf.Sync(), "spool.sync")
+}
+
+// Close stops the drain routine and closes the wrapped sink.  Events
+// still in the spool are sent after the next Open.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancel = nil
+	s.done = nil
+	var err error
+	if s.wf != nil {
+		err = s.wf.Close()
+		s.wf = nil
+	}
+	if s.nextOpen {
+		s.nextOpen = false
+		nextErr := s.next.Close()
+		if nextErr != nil {
+			return errors.Wrap(nextErr, "spool.next.close")
+		}
+	}
+	return errors.Wrap(err, "spool.close")
+}
+
+// Clean cleans the wrapped sink
+func (s *Spool) Clean() error {
+	return s.next.Clean()
+}
+
+// Reopen reopens the wrapped sink
+func (s *Spool) Reopen() error {
+	return s.next.Reopen()
+}
+
+// SetLogger sets the logger for the spool and the wrapped sink
+func (s *Spool) SetLogger(entry *log.Entry) {
+	s.mu.Lock()
+	s.logger = entry
+	s.mu.Unlock()
+	s.next.SetLogger(entry)
+}
+
+// Depth returns the number of events waiting to be written to the wrapped sink
+func (s *Spool) Depth() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth
+}
+
+// drain sends spooled events to the wrapped sink until ctx is cancelled
+func (s *Spool) drain(ctx context.Context) {
+	defer close(s.done)
+	backoff := spoolIdle
+	for {
+		n, err := s.drainOnce(ctx)
+		if err != nil {
+			s.log().Error(errors.Wrapf(err, "spool: %s", s.next.Name()))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > spoolMaxBackoff {
+				backoff = spoolMaxBackoff
+			}
+			continue
+		}
+		backoff = spoolIdle
+		if n > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+		case <-time.After(spoolIdle):
+		}
+	}
+}
+
+// drainOnce writes one batch to the wrapped sink and moves the cursor past it
+func (s *Spool) drainOnce(ctx context.Context) (int, error) {
+	if !s.nextOpen {
+		err := s.next.Open(ctx, "id")
+		if err != nil {
+			return 0, errors.Wrap(err, "open")
+		}
+		s.mu.Lock()
+		s.nextOpen = true
+		s.mu.Unlock()
+	}
+
+	seq, offset, err := s.readCursor()
+	if err != nil {
+		return 0, errors.Wrap(err, "readcursor")
+	}
+	s.mu.Lock()
+	wseq := s.wseq
+	s.mu.Unlock()
+	if seq == 0 {
+		segs, err := s.segments()
+		if err != nil {
+			return 0, err
+		}
+		if len(segs) == 0 {
+			return 0, nil
+		}
+		seq = segs[0]
+	}
+
+	records, end, err := readRecords(s.segmentName(seq), offset, spoolBatch)
+	if err != nil {
+		return 0, errors.Wrap(err, "read")
+	}
+
+	// the segment is finished once the writer has moved on and we've read it all
+	if len(records) == 0 {
+		if seq >= wseq {
+			return 0, nil
+		}
+		err = s.removeSegment(seq)
+		if err != nil {
+			return 0, err
+		}
+		return 1, s.writeCursor(seq+1, 0)
+	}
+
+	events := make([]Event, len(records))
+	for i, r := range records {
+		events[i] = Event{Name: r.Name, Payload: r.Event}
+	}
+	// move past the events that were confirmed, even if later ones failed
+	delivered, writeErr := Delivered(len(events), s.next.WriteBatch(ctx, events))
+	if delivered == 0 {
+		_ = s.next.Reopen()
+		return 0, errors.Wrap(writeErr, "writebatch")
+	}
+	records = records[:delivered]
+	end = records[delivered-1].end
+	err = s.writeCursor(seq, end)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	s.depth -= int64(len(records))
+	if s.depth < 0 {
+		s.depth = 0
+	}
+	s.mu.Unlock()
+
+	// the age is from the next event waiting in this segment
+	pending, _, _ := readRecords(s.segmentName(seq), end, 1)
+
+	s.mu.Lock()
+	switch {
+	case s.depth == 0:
+		s.oldest = time.Time{}
+	case len(pending) > 0:
+		s.oldest = time.UnixMilli(pending[0].Time)
+	}
+	s.setMetrics()
+	s.mu.Unlock()
+	if writeErr != nil {
+		_ = s.next.Reopen()
+		return 0, errors.Wrap(writeErr, "writebatch")
+	}
+	return len(records), nil
+}
+
+func (s *Spool) log() *log.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logger
+}
+
+// setMetrics updates the spool gauges.  The caller must hold the lock.
+func (s *Spool) setMetrics() {
+	label := s.next.Name()
+	prom.SpoolDepth.WithLabelValues(label).Set(float64(s.depth))
+	prom.SpoolBytes.WithLabelValues(label).Set(float64(s.bytes))
+	age := 0.0
+	if !s.oldest.IsZero() {
+		age = time.Since(s.oldest).Seconds()
+	}
+	prom.SpoolAge.WithLabelValues(label).Set(age)
+}
+
+// rotate closes the write segment and starts the next one.  The caller must hold the lock.
+func (s *Spool) rotate() error {
+	err := s.wf.Close()
+	if err != nil {
+		return errors.Wrap(err, "spool.rotate")
+	}
+	s.wseq++
+	return s.openSegment()
+}
+
+// openSegment opens the write segment for append.  The caller must hold the lock.
+func (s *Spool) openSegment() error {
+	f, err := os.OpenFile(s.segmentName(s.wseq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return errors.Wrap(err, "spool.opensegment")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "spool.stat")
+	}
+	s.wf = f
+	s.wsize = fi.Size()
+	return nil
+}
+
+func (s *Spool) removeSegment(seq int64) error {
+	name := s.segmentName(seq)
+	fi, err := os.Stat(name)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "stat")
+	}
+	err = os.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove")
+	}
+	if fi != nil {
+		s.mu.Lock()
+		s.bytes -= fi.Size()
+		s.setMetrics()
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *Spool) segmentName(seq int64) string {
+	return filepath.Join(s.Dir, fmt.Sprintf(spoolSegmentName, seq))
+}
+
+// segments returns the sequence numbers of the segment files in order
+func (s *Spool) segments() ([]int64, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	segs := make([]int64, 0)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolExtension) {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(name, spoolExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, n)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return segs, nil
+}
+
+// readCursor returns the segment and offset of the next event to drain.
+// A zero segment means start at the oldest segment.
+func (s *Spool) readCursor() (int64, int64, error) {
+	b, err := os.ReadFile(filepath.Join(s.Dir, spoolCursorFile))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var seq, offset int64
+	_, err = fmt.Sscanf(string(b), "%d %d", &seq, &offset)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "invalid cursor")
+	}
+	return seq, offset, nil
+}
+
+// writeCursor saves the position by writing a new file and renaming it
+func (s *Spool) writeCursor(seq, offset int64) error {
+	name := filepath.Join(s.Dir, spoolCursorFile)
+	tmp := name + ".tmp"
+	err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", seq, offset)), 0640)
+	if err != nil {
+		return errors.Wrap(err, "writecursor")
+	}
+	return errors.Wrap(os.Rename(tmp, name), "writecursor.rename")
+}
+
+// readRecords reads up to max complete records starting at offset.
+// It returns the offset after the last complete record.  A partial
+// line at the end is still being written and is left for later.
+func readRecords(name string, offset int64, max int) ([]spoolRecord, int64, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, offset, nil
+	}
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, offset, err
+	}
+
+	records := make([]spoolRecord, 0)
+	br := bufio.NewReader(f)
+	for len(records) < max {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return records, offset, err
+		}
+		offset += int64(len(line))
+		var r spoolRecord
+		if json.Unmarshal(line, &r) != nil {
+			// skip a line damaged by a crash
+			continue
+		}
+		r.end = offset
+		records = append(records, r)
+	}
+	return records, offset, nil
+}
+
+func countRecords(name string, offset int64) (int64, error) {
+	var count int64
+	for {
+		records, end, err := readRecords(name, offset, spoolBatch)
+		if err != nil {
+			return count, err
+		}
+		count += int64(len(records))
+		if end == offset {
+			return count, nil
+		}
+		offset = end
+	}
+}
@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// memSink keeps events in memory and can be set to fail
+type memSink struct {
+	mu     sync.Mutex
+	events []string
+	fail   bool
+}
+
+func (m *memSink) Open(context.Context, string) error { return nil }
+func (m *memSink) Write(_ context.Context, name, event string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail {
+		return 0, errors.New("unavailable")
+	}
+	m.events = append(m.events, event)
+	return len(event), nil
+}
+func (m *memSink) WriteBatch(ctx context.Context, events []Event) []error {
+	return WriteEach(ctx, m, events)
+}
+func (m *memSink) Flush() error         { return nil }
+func (m *memSink) Close() error         { return nil }
+func (m *memSink) Name() string         { return "mem" }
+func (m *memSink) Clean() error         { return nil }
+func (m *memSink) Reopen() error        { return nil }
+func (m *memSink) SetLogger(*log.Entry) {}
+func (m *memSink) setFail(fail bool)    { m.mu.Lock(); m.fail = fail; m.mu.Unlock() }
+func (m *memSink) count() int           { m.mu.Lock(); defer m.mu.Unlock(); return len(m.events) }
+func (m *memSink) get(i int) string     { m.mu.Lock(); defer m.mu.Unlock(); return m.events[i] }
+
+func waitFor(f func() bool) bool {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestSpoolDrain(t *testing.T) {
+	assert := assert.New(t)
+	mem := &memSink{}
+	s := NewSpool(mem, t.TempDir())
+	s.SegmentBytes = 100 // force several segments
+	ctx := context.Background()
+	assert.NoError(s.Open(ctx, "id"))
+
+	for _, e := range []string{`{"a":1}`, `{"a":2}`, `{"a":3}`, `{"a":4}`, `{"a":5}`} {
+		_, err := s.Write(ctx, "login", e)
+		assert.NoError(err)
+	}
+	assert.NoError(s.Flush())
+	assert.True(waitFor(func() bool { return mem.count() == 5 }))
+	assert.Equal(`{"a":1}`, mem.get(0))
+	assert.Equal(`{"a":5}`, mem.get(4))
+	assert.True(waitFor(func() bool { return s.Depth() == 0 }))
+	assert.NoError(s.Close())
+
+	segs, err := s.segments()
+	assert.NoError(err)
+	assert.Equal(1, len(segs), "drained segments should be removed")
+}
+
+func TestSpoolOutage(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	mem := &memSink{fail: true}
+	s := NewSpool(mem, dir)
+	ctx := context.Background()
+	assert.NoError(s.Open(ctx, "id"))
+	_, err := s.Write(ctx, "login", `{"a":1}`)
+	assert.NoError(err)
+	_, err = s.Write(ctx, "login", `{"a":2}`)
+	assert.NoError(err)
+	assert.NoError(s.Close())
+	assert.Equal(0, mem.count())
+
+	// events survive a restart and drain once the sink is back
+	mem.setFail(false)
+	s = NewSpool(mem, dir)
+	assert.NoError(s.Open(ctx, "id"))
+	assert.Equal(int64(2), s.Depth())
+	assert.True(waitFor(func() bool { return mem.count() == 2 }))
+	assert.NoError(s.Close())
+}
+
+func TestSpoolFull(t *testing.T) {
+	assert := assert.New(t)
+	mem := &memSink{fail: true}
+	s := NewSpool(mem, t.TempDir())
+	s.MaxBytes = 64
+	ctx := context.Background()
+	assert.NoError(s.Open(ctx, "id"))
+	_, err := s.Write(ctx, "login", `{"a":1}`)
+	assert.NoError(err)
+	_, err = s.Write(ctx, "login", `{"a":"this event will not fit in the spool"}`)
+	assert.ErrorIs(err, ErrSpoolFull)
+	assert.NoError(s.Close())
+}
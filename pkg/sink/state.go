@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the connection state of a named sink.  A sink is connected
+// after it opens or writes without an error.
+type State struct {
+	Name          string     `json:"name"`
+	Connected     bool       `json:"connected"`
+	LastWrite     *time.Time `json:"last_write,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	Written       int64      `json:"written"`
+	Failed        int64      `json:"failed"`
+}
+
+var states = struct {
+	sync.Mutex
+	m map[string]*State
+}{m: make(map[string]*State)}
+
+// States returns the state of each named sink sorted by name
+func States() []State {
+	states.Lock()
+	defer states.Unlock()
+	list := make([]State, 0, len(states.m))
+	for _, st := range states.m {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// ResetStates clears the sinks so a reload starts over
+func ResetStates() {
+	states.Lock()
+	defer states.Unlock()
+	states.m = make(map[string]*State)
+}
+
+// setState updates the state of a sink and adds it if it's new
+func setState(name string, f func(st *State)) {
+	states.Lock()
+	defer states.Unlock()
+	st, ok := states.m[name]
+	if !ok {
+		st = &State{Name: name}
+		states.m[name] = st
+	}
+	f(st)
+}
+
+// failed records an error from a sink
+func (st *State) failed(err error) {
+	now := time.Now()
+	st.Connected = false
+	st.LastError = err.Error()
+	st.LastErrorTime = &now
+}
@@ -0,0 +1,114 @@
+package status
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RingWindow is how far behind the newest event a ring_buffer event is
+// still checked against the events already written.  Older events are
+// assumed to be written.  Events can reach the ring buffer out of order
+// by up to the session's MAX_DISPATCH_LATENCY.
+const RingWindow = 5 * time.Minute
+
+// Ring tracks the events read from a ring_buffer target.  A ring buffer
+// has no file name or offset so events are identified by their timestamp
+// and a hash of the event.
+type Ring struct {
+	Name   string
+	last   time.Time            // newest event written
+	hashes map[string]time.Time // events written within the window
+}
+
+// NewRing opens the state for a ring_buffer target
+func NewRing(domain, instance, id string) (Ring, error) {
+	r := Ring{hashes: make(map[string]time.Time)}
+	dir, err := stateDir()
+	if err != nil {
+		return r, err
+	}
+	r.Name = filepath.Join(dir, fileName(domain, instance, ClassRing, id))
+
+	fp, err := os.Open(r.Name)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return r, errors.Wrap(err, "os.open")
+	}
+	defer fp.Close()
+
+	reader := csv.NewReader(bufio.NewReader(fp))
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return r, errors.Wrap(err, "read")
+		}
+		if len(line) != 2 {
+			return r, errors.Errorf("len(line) expected: 2; got %d (%v)", len(line), line)
+		}
+		ts, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(line[0]))
+		if err != nil {
+			return r, errors.Errorf("error reading timestamp: got %s", line[0])
+		}
+		r.Add(ts, strings.TrimSpace(line[1]))
+	}
+	return r, nil
+}
+
+// Seen is true if the event has already been written
+func (r *Ring) Seen(ts time.Time, hash string) bool {
+	if r.last.IsZero() {
+		return false
+	}
+	if ts.Before(r.last.Add(-RingWindow)) {
+		return true
+	}
+	_, ok := r.hashes[hash]
+	return ok
+}
+
+// Add records that an event has been written
+func (r *Ring) Add(ts time.Time, hash string) {
+	r.hashes[hash] = ts
+	if ts.After(r.last) {
+		r.last = ts
+	}
+}
+
+// Save writes the events within the window to the state file
+func (r *Ring) Save() error {
+	cutoff := r.last.Add(-RingWindow)
+	lines := make([]string, 0, len(r.hashes))
+	for hash, ts := range r.hashes {
+		if ts.Before(cutoff) {
+			delete(r.hashes, hash)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s, %s\r\n", ts.UTC().Format(time.RFC3339Nano), hash))
+	}
+	sort.Strings(lines)
+
+	tmp := r.Name + ".tmp"
+	err := os.WriteFile(tmp, []byte(strings.Join(lines, "")), 0600)
+	if err != nil {
+		return errors.Wrap(err, "os.writefile")
+	}
+	err = os.Rename(tmp, r.Name)
+	if err != nil {
+		return errors.Wrap(err, "os.rename")
+	}
+	return nil
+}
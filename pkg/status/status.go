@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -27,6 +28,11 @@ var sources map[string]bool
 var instances map[string]bool
 var mux sync.Mutex
 
+// positions has the last file and offset of each state file by the
+// lower case base name of the file
+var positions = make(map[string]Position)
+var posMux sync.Mutex
+
 // ErrDup indicates a duplicate was found
 var ErrDup = errors.New("duplicate domain-instance-class-id")
 
@@ -61,6 +67,14 @@ const (
 	ClassXE = "XE"
 	// ClassAgentJobs is used for AGENT job history
 	ClassAgentJobs = "JOBS"
+	// ClassAudit is used for server audit files
+	ClassAudit = "AUDIT"
+	// ClassErrorLog is used for the error log read with xp_readerrorlog
+	ClassErrorLog = "ERRORLOG"
+	// ClassTrace is used for the default trace
+	ClassTrace = "TRACE"
+	// ClassRing is used for XE sessions read from a ring_buffer target
+	ClassRing = "RING"
 )
 
 // CheckDupe checks to see if this session has been processed already
@@ -114,25 +128,31 @@ func Reset() {
 // This also creates the state file if it doesn't exist
 func NewFile(domain, instance, class, id string) (File, error) {
 	var f File
-	var err error
+	dir, err := stateDir()
+	if err != nil {
+		return f, err
+	}
+	f.Name = filepath.Join(dir, fileName(domain, instance, class, id))
+	return f, nil
+}
 
-	// Get EXE directory
+// stateDir returns the directory for state files next to the executable.
+// It is created if it doesn't exist.
+func stateDir() (string, error) {
 	executable, err := os.Executable()
 	if err != nil {
-		return f, errors.Wrap(err, "os.executable")
+		return "", errors.Wrap(err, "os.executable")
 	}
 	exeDir := filepath.Dir(executable)
 
-	stateDir := filepath.Join(exeDir, "xestate")
-	if _, err = os.Stat(stateDir); os.IsNotExist(err) {
-		err = os.Mkdir(stateDir, 0644)
+	dir := filepath.Join(exeDir, "xestate")
+	if _, err = os.Stat(dir); os.IsNotExist(err) {
+		err = os.Mkdir(dir, 0644)
 	}
 	if err != nil {
-		return f, errors.Wrap(err, "os.mkdir")
+		return "", errors.Wrap(err, "os.mkdir")
 	}
-
-	f.Name = filepath.Join(stateDir, fileName(domain, instance, class, id))
-	return f, nil
+	return dir, nil
 }
 
 // checkNullFile checks if the state file contains only 0x0's
@@ -231,16 +251,40 @@ func (f *File) GetOffset() (fileName string, offset int64, xestatus string, err
 		return "", 0, StateReset, errors.Wrap(err, "openappend")
 	}
 
-	_, err = fp.Stat()
+	fi, err := fp.Stat()
 	if err != nil {
 		return fileName, offset, StateReset, errors.Wrap(err, "stat-2")
 	}
 
 	f.file = fp
+	f.setPosition(Position{FileName: fileName, Offset: offset, Status: xestatus, Saved: fi.ModTime()})
 
 	return fileName, offset, xestatus, nil
 }
 
+// Position is the last file and offset read or saved in a state file
+type Position struct {
+	FileName string
+	Offset   int64
+	Status   string
+	Saved    time.Time
+}
+
+// GetPosition returns the last position of a state file that this
+// process read or saved
+func GetPosition(domain, instance, class, id string) (Position, bool) {
+	posMux.Lock()
+	defer posMux.Unlock()
+	pos, ok := positions[strings.ToLower(fileName(domain, instance, class, id))]
+	return pos, ok
+}
+
+func (f *File) setPosition(pos Position) {
+	posMux.Lock()
+	defer posMux.Unlock()
+	positions[strings.ToLower(filepath.Base(f.Name))] = pos
+}
+
 // FileName returns the base file name to track state
 func fileName(domain, instance, class, id string) string {
 	var fileName string
@@ -279,12 +323,13 @@ func (f *File) Save(fileName string, offset int64, xestatus string) error {
 	if err != nil {
 		return errors.Wrap(err, "writeStatus")
 	}
+	f.setPosition(Position{FileName: fileName, Offset: offset, Status: xestatus, Saved: time.Now()})
 
 	return nil
 }
 
 func writeState(f *os.File, xeFileName string, offset int64, xestatus string) error {
-	msg := fmt.Sprintf("%s, %d, %s\r\n", xeFileName, offset, xestatus)
+	msg := fmt.Sprintf("%s, %d, %s\r\n", quoteField(xeFileName), offset, xestatus)
 	_, err := f.WriteString(msg)
 	if err != nil {
 		return errors.Wrap(err, "file.write")
@@ -296,6 +341,15 @@ func writeState(f *os.File, xeFileName string, offset int64, xestatus string) er
 	return nil
 }
 
+// quoteField quotes a file name for the CSV state file if it needs it.
+// A file in blob storage is a URL that may have a comma.
+func quoteField(s string) string {
+	if !strings.ContainsAny(s, ",\"\r\n") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
 // Done closes the file
 func (f *File) Done(xeFileName string, offset int64, xestatus string) error {
 	var err error
@@ -1,7 +1,9 @@
 package status
 
 import (
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -40,3 +42,56 @@ func TestCaseSensitive(t *testing.T) {
 		t.Error("It should exist")
 	}
 }
+
+func TestRing(t *testing.T) {
+	assert := assert.New(t)
+	r, err := NewRing("test", "test", "ring_test")
+	assert.NoError(err)
+	_ = os.Remove(r.Name)
+	r, err = NewRing("test", "test", "ring_test")
+	assert.NoError(err)
+
+	t0 := time.Date(2024, 4, 8, 16, 0, 0, 0, time.UTC)
+	assert.False(r.Seen(t0, "a"))
+	r.Add(t0, "a")
+	r.Add(t0.Add(10*time.Minute), "b")
+	r.Add(t0.Add(10*time.Minute), "c")
+	assert.NoError(r.Save())
+
+	r, err = NewRing("test", "test", "ring_test")
+	assert.NoError(err)
+	assert.True(r.Seen(t0, "x"))                     // older than the window
+	assert.True(r.Seen(t0.Add(10*time.Minute), "b")) // already written
+	assert.False(r.Seen(t0.Add(10*time.Minute), "d"))
+	assert.False(r.Seen(t0.Add(9*time.Minute), "e")) // late but within the window
+	assert.False(r.Seen(t0.Add(11*time.Minute), "b2"))
+	assert.Equal(2, len(r.hashes))
+	_ = os.Remove(r.Name)
+}
+
+func TestBlobFileName(t *testing.T) {
+	assert := assert.New(t)
+	blob := "https://acct.blob.core.windows.net/xe/logins,prod_0_133580064000000000.xel"
+	sf, err := NewFile("test", "test", ClassXE, "blob_test")
+	assert.NoError(err)
+	_ = os.Remove(sf.Name)
+	_, _, _, err = sf.GetOffset()
+	assert.NoError(err)
+	assert.NoError(sf.Save(blob, 2048, StateSuccess))
+	pos, ok := GetPosition("test", "TEST", ClassXE, "blob_test")
+	assert.True(ok)
+	assert.Equal(blob, pos.FileName)
+	assert.Equal(int64(2048), pos.Offset)
+	assert.False(pos.Saved.IsZero())
+	assert.NoError(sf.Done(blob, 4096, StateSuccess))
+
+	check, err := NewFile("test", "test", ClassXE, "blob_test")
+	assert.NoError(err)
+	fileName, offset, xestatus, err := check.GetOffset()
+	assert.NoError(err)
+	assert.Equal(blob, fileName)
+	assert.Equal(int64(4096), offset)
+	assert.Equal(StateSuccess, xestatus)
+	_ = os.Remove(sf.Name)
+	_ = os.Remove(sf.Name + ".0")
+}
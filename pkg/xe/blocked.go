@@ -0,0 +1,56 @@
+package xe
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type xmlBlockedProcessReport struct {
+	MonitorLoop int64      `xml:"monitorLoop,attr"`
+	Blocked     xmlProcess `xml:"blocked-process>process"`
+	Blocking    xmlProcess `xml:"blocking-process>process"`
+}
+
+// setBlockedProcess adds the blocked and blocking processes from a
+// blocked process report as xe_blocked_* and xe_blocking_* fields.
+// xe_monitor_loop identifies the reports from one pass of the deadlock monitor.
+func (e *Event) setBlockedProcess(i *SQLInfo, report string) error {
+	start := strings.Index(report, "<blocked-process-report")
+	if start < 0 {
+		return errors.New("blocked-process-report not found")
+	}
+	var bpr xmlBlockedProcessReport
+	err := xml.NewDecoder(strings.NewReader(report[start:])).Decode(&bpr)
+	if err != nil {
+		return errors.Wrap(err, "blocked-process-report")
+	}
+	e.Set("xe_monitor_loop", bpr.MonitorLoop)
+	e.setProcess("xe_blocked_", bpr.Blocked, i)
+	e.setProcess("xe_blocking_", bpr.Blocking, i)
+	return nil
+}
+
+// setProcess adds the fields for a process using the prefix
+func (e *Event) setProcess(prefix string, p xmlProcess, i *SQLInfo) {
+	e.Set(prefix+"spid", p.SPID)
+	set := func(key, value string) {
+		if value != "" {
+			e.Set(prefix+key, value)
+		}
+	}
+	set("status", p.Status)
+	set("login", p.Login)
+	set("host", p.Host)
+	set("app", p.App)
+	set("database", p.database(i))
+	set("isolation_level", p.IsolationLevel)
+	set("transaction_name", p.TransactionName)
+	set("lock_mode", p.LockMode)
+	set("wait_resource", strings.TrimSpace(p.WaitResource))
+	set("input_buffer", strings.TrimSpace(p.InputBuffer))
+	if p.WaitTime > 0 {
+		e.Set(prefix+"wait_time", p.WaitTime)
+	}
+}
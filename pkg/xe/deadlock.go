@@ -0,0 +1,242 @@
+package xe
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DeadlockProcess is one process from the process-list of a deadlock graph
+type DeadlockProcess struct {
+	ID              string `json:"id"`
+	SPID            int64  `json:"spid"`
+	Victim          bool   `json:"victim"`
+	Login           string `json:"login,omitempty"`
+	Host            string `json:"host,omitempty"`
+	App             string `json:"app,omitempty"`
+	Database        string `json:"database,omitempty"`
+	IsolationLevel  string `json:"isolation_level,omitempty"`
+	TransactionName string `json:"transaction_name,omitempty"`
+	LockMode        string `json:"lock_mode,omitempty"`
+	WaitResource    string `json:"wait_resource,omitempty"`
+	WaitTime        int64  `json:"wait_time"`
+	InputBuffer     string `json:"input_buffer,omitempty"`
+}
+
+// DeadlockLock is an owner or waiter of a deadlock resource
+type DeadlockLock struct {
+	Process string `json:"process"`
+	SPID    int64  `json:"spid"`
+	Mode    string `json:"mode,omitempty"`
+}
+
+// DeadlockResource is one resource from the resource-list of a deadlock graph.
+// Type is the element name such as keylock, pagelock, or objectlock.
+type DeadlockResource struct {
+	Type       string         `json:"type"`
+	ObjectName string         `json:"object_name,omitempty"`
+	IndexName  string         `json:"index_name,omitempty"`
+	DatabaseID int64          `json:"database_id,omitempty"`
+	Mode       string         `json:"mode,omitempty"`
+	Owners     []DeadlockLock `json:"owners"`
+	Waiters    []DeadlockLock `json:"waiters"`
+}
+
+type xmlDeadlockLock struct {
+	ID   string `xml:"id,attr"`
+	Mode string `xml:"mode,attr"`
+}
+
+// xmlProcess is a process node in a deadlock graph or blocked process report
+type xmlProcess struct {
+	ID              string `xml:"id,attr"`
+	SPID            int64  `xml:"spid,attr"`
+	Status          string `xml:"status,attr"`
+	Login           string `xml:"loginname,attr"`
+	Host            string `xml:"hostname,attr"`
+	App             string `xml:"clientapp,attr"`
+	DatabaseID      int64  `xml:"currentdb,attr"`
+	DatabaseName    string `xml:"currentdbname,attr"`
+	IsolationLevel  string `xml:"isolationlevel,attr"`
+	TransactionName string `xml:"transactionname,attr"`
+	LockMode        string `xml:"lockMode,attr"`
+	WaitResource    string `xml:"waitresource,attr"`
+	WaitTime        int64  `xml:"waittime,attr"`
+	InputBuffer     string `xml:"inputbuf"`
+}
+
+// database returns the database name for the process.  Older versions
+// only have the database ID.
+func (p xmlProcess) database(i *SQLInfo) string {
+	if p.DatabaseName != "" {
+		return p.DatabaseName
+	}
+	if d, ok := i.Databases[p.DatabaseID]; ok && d != nil {
+		return d.Name
+	}
+	return ""
+}
+
+type xmlDeadlock struct {
+	Victim  string `xml:"victim,attr"` // SQL Server 2008 puts a single victim here
+	Victims []struct {
+		ID string `xml:"id,attr"`
+	} `xml:"victim-list>victimProcess"`
+	Processes []xmlProcess `xml:"process-list>process"`
+	Resources struct {
+		Items []struct {
+			XMLName    xml.Name
+			ObjectName string            `xml:"objectname,attr"`
+			IndexName  string            `xml:"indexname,attr"`
+			DatabaseID int64             `xml:"dbid,attr"`
+			Mode       string            `xml:"mode,attr"`
+			Owners     []xmlDeadlockLock `xml:"owner-list>owner"`
+			Waiters    []xmlDeadlockLock `xml:"waiter-list>waiter"`
+		} `xml:",any"`
+	} `xml:"resource-list"`
+}
+
+// parseDeadlockReport reads the deadlock graph from the xml_report value.
+// It handles the <deadlock> root and the older <deadlock-list> root.
+func (i *SQLInfo) parseDeadlockReport(report string) ([]DeadlockProcess, []DeadlockResource, error) {
+	start := strings.Index(report, "<deadlock")
+	if start < 0 {
+		return nil, nil, errors.New("deadlock graph not found")
+	}
+	var dl xmlDeadlock
+	if strings.HasPrefix(report[start:], "<deadlock-list") {
+		var list struct {
+			Deadlocks []xmlDeadlock `xml:"deadlock"`
+		}
+		err := xml.NewDecoder(strings.NewReader(report[start:])).Decode(&list)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "deadlock-list")
+		}
+		if len(list.Deadlocks) > 0 {
+			dl = list.Deadlocks[0]
+		}
+	} else {
+		err := xml.NewDecoder(strings.NewReader(report[start:])).Decode(&dl)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "deadlock")
+		}
+	}
+
+	victims := make(map[string]bool)
+	if dl.Victim != "" {
+		victims[dl.Victim] = true
+	}
+	for _, v := range dl.Victims {
+		victims[v.ID] = true
+	}
+
+	spids := make(map[string]int64)
+	processes := make([]DeadlockProcess, 0, len(dl.Processes))
+	for _, p := range dl.Processes {
+		spids[p.ID] = p.SPID
+		processes = append(processes, DeadlockProcess{
+			ID:              p.ID,
+			SPID:            p.SPID,
+			Victim:          victims[p.ID],
+			Login:           p.Login,
+			Host:            p.Host,
+			App:             p.App,
+			Database:        p.database(i),
+			IsolationLevel:  p.IsolationLevel,
+			TransactionName: p.TransactionName,
+			LockMode:        p.LockMode,
+			WaitResource:    p.WaitResource,
+			WaitTime:        p.WaitTime,
+			InputBuffer:     strings.TrimSpace(p.InputBuffer),
+		})
+	}
+
+	locks := func(x []xmlDeadlockLock) []DeadlockLock {
+		l := make([]DeadlockLock, 0, len(x))
+		for _, o := range x {
+			l = append(l, DeadlockLock{Process: o.ID, SPID: spids[o.ID], Mode: o.Mode})
+		}
+		return l
+	}
+	resources := make([]DeadlockResource, 0, len(dl.Resources.Items))
+	for _, r := range dl.Resources.Items {
+		resources = append(resources, DeadlockResource{
+			Type:       r.XMLName.Local,
+			ObjectName: r.ObjectName,
+			IndexName:  r.IndexName,
+			DatabaseID: r.DatabaseID,
+			Mode:       r.Mode,
+			Owners:     locks(r.Owners),
+			Waiters:    locks(r.Waiters),
+		})
+	}
+	return processes, resources, nil
+}
+
+// setDeadlock adds the victims, processes and resources from the deadlock graph
+func (e *Event) setDeadlock(i *SQLInfo, report string) error {
+	processes, resources, err := i.parseDeadlockReport(report)
+	if err != nil {
+		return err
+	}
+	victims := make([]int64, 0)
+	for _, p := range processes {
+		if p.Victim {
+			victims = append(victims, p.SPID)
+		}
+	}
+	e.Set("xe_deadlock_victims", victims)
+	e.Set("xe_deadlock_processes", processes)
+	e.Set("xe_deadlock_resources", resources)
+	return nil
+}
+
+// getDeadlockDescription summarizes a parsed deadlock graph on one line:
+// victim 55 (sa - SSMS) in Test; survivor 57 (app - Web); keylock Test.dbo.t1 (PK_t1)
+func (e *Event) getDeadlockDescription() string {
+	processes, _ := (*e)["xe_deadlock_processes"].([]DeadlockProcess)
+	resources, _ := (*e)["xe_deadlock_resources"].([]DeadlockResource)
+	if len(processes) == 0 {
+		return "xml_deadlock_report"
+	}
+
+	parts := make([]string, 0, len(processes)+len(resources))
+	for _, victim := range []bool{true, false} {
+		for _, p := range processes {
+			if p.Victim != victim {
+				continue
+			}
+			role := "survivor"
+			if p.Victim {
+				role = "victim"
+			}
+			s := fmt.Sprintf("%s %d", role, p.SPID)
+			who := make([]string, 0, 2)
+			for _, v := range []string{p.Login, p.App} {
+				if v != "" {
+					who = append(who, v)
+				}
+			}
+			if len(who) > 0 {
+				s += fmt.Sprintf(" (%s)", strings.Join(who, " - "))
+			}
+			if p.Database != "" {
+				s += " in " + p.Database
+			}
+			parts = append(parts, s)
+		}
+	}
+	for _, r := range resources {
+		s := r.Type
+		if r.ObjectName != "" {
+			s += " " + r.ObjectName
+		}
+		if r.IndexName != "" {
+			s += fmt.Sprintf(" (%s)", r.IndexName)
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,420 @@
+package xe
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Definition declares an XE session that the tool creates and keeps in
+// sync.  It always has an event_file target.
+type Definition struct {
+	Name             string            `toml:"name"`
+	Events           []EventDefinition `toml:"event"`
+	FileName         string            `toml:"filename"`           // defaults to the session name
+	MaxFileSizeMB    int               `toml:"max_file_size_mb"`   // defaults to 10
+	MaxRolloverFiles int               `toml:"max_rollover_files"` // defaults to 20
+}
+
+// EventDefinition is one event in a session definition
+type EventDefinition struct {
+	Name      string                 `toml:"name"`      // package.event, i.e. sqlserver.error_reported
+	Actions   []string               `toml:"actions"`   // package.action, i.e. sqlserver.client_app_name
+	Predicate string                 `toml:"predicate"` // the WHERE clause without the WHERE
+	Fields    map[string]interface{} `toml:"fields"`    // customizable fields, i.e. collect_statement = 1
+}
+
+// DeployedSession is what a server has for a session
+type DeployedSession struct {
+	Definition
+	Exists     bool
+	AutoStart  bool
+	Running    bool
+	FileTarget bool
+}
+
+var (
+	objectNameRegex = regexp.MustCompile(`^\w+\.\w+$`)
+	fieldNameRegex  = regexp.MustCompile(`^\w+$`)
+)
+
+// Validate checks the names in a definition and sets the defaults
+func (d *Definition) Validate() error {
+	if d.Name == "" {
+		return errors.New("session without a name")
+	}
+	if len(d.Events) == 0 {
+		return fmt.Errorf("session %s: no events", d.Name)
+	}
+	seen := make(map[string]bool)
+	for _, e := range d.Events {
+		if !objectNameRegex.MatchString(e.Name) {
+			return fmt.Errorf("session %s: event '%s' must be package.event", d.Name, e.Name)
+		}
+		if seen[strings.ToLower(e.Name)] {
+			return fmt.Errorf("session %s: duplicate event: %s", d.Name, e.Name)
+		}
+		seen[strings.ToLower(e.Name)] = true
+		for _, a := range e.Actions {
+			if !objectNameRegex.MatchString(a) {
+				return fmt.Errorf("session %s: event %s: action '%s' must be package.action", d.Name, e.Name, a)
+			}
+		}
+		for k, v := range e.Fields {
+			if !fieldNameRegex.MatchString(k) {
+				return fmt.Errorf("session %s: event %s: invalid field: '%s'", d.Name, e.Name, k)
+			}
+			_, _, err := fieldValue(v)
+			if err != nil {
+				return errors.Wrapf(err, "session %s: event %s: field %s", d.Name, e.Name, k)
+			}
+		}
+	}
+	if d.FileName == "" {
+		d.FileName = d.Name
+	}
+	if d.MaxFileSizeMB == 0 {
+		d.MaxFileSizeMB = 10
+	}
+	if d.MaxRolloverFiles == 0 {
+		d.MaxRolloverFiles = 20
+	}
+	return nil
+}
+
+// fieldValue returns the T-SQL literal for a field value and the value as
+// it reads back from the catalog views
+func fieldValue(v interface{}) (literal string, value string, err error) {
+	switch x := v.(type) {
+	case string:
+		return "N" + quoteString(x), x, nil
+	case int64:
+		return fmt.Sprintf("(%d)", x), fmt.Sprintf("%d", x), nil
+	case int:
+		return fmt.Sprintf("(%d)", x), fmt.Sprintf("%d", x), nil
+	case bool:
+		if x {
+			return "(1)", "1", nil
+		}
+		return "(0)", "0", nil
+	}
+	return "", "", fmt.Errorf("unsupported value: %v (%T)", v, v)
+}
+
+func quoteName(s string) string {
+	return "[" + strings.ReplaceAll(s, "]", "]]") + "]"
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// normalPredicate returns a predicate without the noise SQL Server adds
+// when it stores one.  Outside string literals it drops whitespace,
+// brackets, parentheses and the N before a literal, and ignores case.
+// The predicates read back from SQL Server don't always have the N.
+// String literals are kept exactly.
+func normalPredicate(predicate string) string {
+	var sb strings.Builder
+	quoted := false
+	for i := 0; i < len(predicate); i++ {
+		c := predicate[i]
+		if c == '\'' {
+			quoted = !quoted // a doubled quote toggles twice
+			sb.WriteByte(c)
+			continue
+		}
+		if quoted {
+			sb.WriteByte(c)
+			continue
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n', '[', ']', '(', ')':
+			continue
+		}
+		if (c == 'N' || c == 'n') && i+1 < len(predicate) && predicate[i+1] == '\'' &&
+			(i == 0 || !identifier(predicate[i-1])) {
+			continue
+		}
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func identifier(c byte) bool {
+	return c == '_' || c == '@' || c == '#' || c == '$' || c == ']' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func samePredicate(a, b string) bool {
+	return normalPredicate(a) == normalPredicate(b)
+}
+
+// same reports whether a deployed event matches the declared event
+func (e EventDefinition) same(have EventDefinition) bool {
+	if !samePredicate(e.Predicate, have.Predicate) {
+		return false
+	}
+	if len(e.Actions) != len(have.Actions) || len(e.Fields) != len(have.Fields) {
+		return false
+	}
+	actions := make(map[string]bool)
+	for _, a := range have.Actions {
+		actions[strings.ToLower(a)] = true
+	}
+	for _, a := range e.Actions {
+		if !actions[strings.ToLower(a)] {
+			return false
+		}
+	}
+	for k, v := range e.Fields {
+		_, want, err := fieldValue(v)
+		if err != nil {
+			return false
+		}
+		got, ok := have.Fields[strings.ToLower(k)]
+		if !ok || !strings.EqualFold(fmt.Sprint(got), want) {
+			return false
+		}
+	}
+	return true
+}
+
+// clause is the event for an ADD EVENT
+func (e EventDefinition) clause() string {
+	var parts []string
+	if len(e.Fields) > 0 {
+		names := make([]string, 0, len(e.Fields))
+		for k := range e.Fields {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		set := make([]string, 0, len(names))
+		for _, k := range names {
+			literal, _, _ := fieldValue(e.Fields[k])
+			set = append(set, k+"="+literal)
+		}
+		parts = append(parts, "SET "+strings.Join(set, ","))
+	}
+	if len(e.Actions) > 0 {
+		parts = append(parts, "ACTION("+strings.Join(e.Actions, ",")+")")
+	}
+	if strings.TrimSpace(e.Predicate) != "" {
+		parts = append(parts, "WHERE ("+strings.TrimSpace(e.Predicate)+")")
+	}
+	if len(parts) == 0 {
+		return e.Name
+	}
+	return e.Name + "(" + strings.Join(parts, " ") + ")"
+}
+
+func (d Definition) target() string {
+	return fmt.Sprintf("package0.event_file(SET filename=N%s,max_file_size=(%d),max_rollover_files=(%d))",
+		quoteString(d.FileName), d.MaxFileSizeMB, d.MaxRolloverFiles)
+}
+
+func (d Definition) create(on string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CREATE EVENT SESSION %s %s\n", quoteName(d.Name), on)
+	for _, e := range d.Events {
+		fmt.Fprintf(&sb, "ADD EVENT %s,\n", e.clause())
+	}
+	s := strings.TrimSuffix(sb.String(), ",\n") + "\n"
+	s += "ADD TARGET " + d.target() + "\n"
+	s += "WITH (MAX_MEMORY=4096 KB,EVENT_RETENTION_MODE=ALLOW_SINGLE_EVENT_LOSS,MAX_DISPATCH_LATENCY=10 SECONDS,STARTUP_STATE=ON);"
+	return s
+}
+
+// Plan returns the statements that make the deployed session match the
+// definition.  It returns nothing if they already match.  New events are
+// added before anything is dropped.  Events that changed are dropped and
+// added again and events that aren't in the definition are dropped last.
+// The session is never dropped.  Targets other than the event_file are
+// left alone.
+func (d Definition) Plan(scope Scope, have DeployedSession) ([]string, error) {
+	on := "ON SERVER"
+	if scope == DatabaseScope {
+		on = "ON DATABASE"
+		if !strings.Contains(d.FileName, "://") {
+			return nil, fmt.Errorf("session %s: azure sql database needs a blob storage url for the filename", d.Name)
+		}
+	}
+	alter := fmt.Sprintf("ALTER EVENT SESSION %s %s", quoteName(d.Name), on)
+	start := alter + " STATE = START;"
+
+	if !have.Exists {
+		return []string{d.create(on), start}, nil
+	}
+
+	deployed := make(map[string]EventDefinition)
+	for _, e := range have.Events {
+		deployed[strings.ToLower(e.Name)] = e
+	}
+	var ddl, changed []string
+	for _, e := range d.Events {
+		h, ok := deployed[strings.ToLower(e.Name)]
+		delete(deployed, strings.ToLower(e.Name))
+		if ok && e.same(h) {
+			continue
+		}
+		add := fmt.Sprintf("%s ADD EVENT %s;", alter, e.clause())
+		if !ok {
+			ddl = append(ddl, add)
+			continue
+		}
+		// an event can't be in a session twice
+		changed = append(changed, fmt.Sprintf("%s DROP EVENT %s;", alter, h.Name), add)
+	}
+	ddl = append(ddl, changed...)
+	removed := make([]string, 0, len(deployed))
+	for k := range deployed {
+		removed = append(removed, k)
+	}
+	sort.Strings(removed)
+	for _, k := range removed {
+		ddl = append(ddl, fmt.Sprintf("%s DROP EVENT %s;", alter, deployed[k].Name))
+	}
+
+	if have.FileTarget && (!strings.EqualFold(have.FileName, d.FileName) ||
+		have.MaxFileSizeMB != d.MaxFileSizeMB ||
+		have.MaxRolloverFiles != d.MaxRolloverFiles) {
+		ddl = append(ddl, alter+" DROP TARGET package0.event_file;")
+		have.FileTarget = false
+	}
+	if !have.FileTarget {
+		ddl = append(ddl, fmt.Sprintf("%s ADD TARGET %s;", alter, d.target()))
+	}
+	if !have.AutoStart {
+		ddl = append(ddl, alter+" WITH (STARTUP_STATE=ON);")
+	}
+	if !have.Running {
+		ddl = append(ddl, start)
+	}
+	return ddl, nil
+}
+
+// GetDeployedSession reads the events, actions and event_file target of a
+// session.  Exists is false if the session isn't there.
+func GetDeployedSession(db *sql.DB, scope Scope, session string) (have DeployedSession, err error) {
+	c := scope.catalog()
+	query := fmt.Sprintf(`
+		SELECT	[session].[event_session_id],
+			[session].[startup_state],
+			CAST((CASE WHEN ([running].[create_time] IS NULL) THEN 0 ELSE 1 END) AS BIT) AS [IsRunning]
+		FROM	%s AS [session]
+		LEFT OUTER JOIN
+			%s AS [running] ON [running].[name] = [session].[name]
+		WHERE 	[session].[name] = ?`, c.sessions, c.running)
+	var id int
+	err = db.QueryRow(query, session).Scan(&id, &have.AutoStart, &have.Running)
+	if err == sql.ErrNoRows {
+		return have, nil
+	}
+	if err != nil {
+		return have, errors.Wrap(err, "db.queryrow.scan")
+	}
+	have.Exists = true
+	have.Name = session
+
+	// customizable fields for the events and targets
+	fields := make(map[int]map[string]string)
+	query = fmt.Sprintf(`SELECT [object_id], [name], CAST([value] AS NVARCHAR(4000)) FROM %s WHERE [event_session_id] = ?`, c.fields)
+	rows, err := db.Query(query, id)
+	if err != nil {
+		return have, errors.Wrap(err, "fields: db.query")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var objectID int
+		var name string
+		var value sql.NullString
+		err = rows.Scan(&objectID, &name, &value)
+		if err != nil {
+			return have, errors.Wrap(err, "fields: rows.scan")
+		}
+		if fields[objectID] == nil {
+			fields[objectID] = make(map[string]string)
+		}
+		fields[objectID][strings.ToLower(name)] = value.String
+	}
+	if err = rows.Err(); err != nil {
+		return have, errors.Wrap(err, "fields: rows.err")
+	}
+
+	events := make(map[int]int) // event_id to the index in Events
+	query = fmt.Sprintf(`SELECT [event_id], [package], [name], [predicate] FROM %s WHERE [event_session_id] = ? ORDER BY [event_id]`, c.events)
+	rows, err = db.Query(query, id)
+	if err != nil {
+		return have, errors.Wrap(err, "events: db.query")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID int
+		var pkg, name string
+		var predicate sql.NullString
+		err = rows.Scan(&eventID, &pkg, &name, &predicate)
+		if err != nil {
+			return have, errors.Wrap(err, "events: rows.scan")
+		}
+		e := EventDefinition{Name: pkg + "." + name, Predicate: predicate.String}
+		if len(fields[eventID]) > 0 {
+			e.Fields = make(map[string]interface{})
+			for k, v := range fields[eventID] {
+				e.Fields[k] = v
+			}
+		}
+		events[eventID] = len(have.Events)
+		have.Events = append(have.Events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return have, errors.Wrap(err, "events: rows.err")
+	}
+
+	query = fmt.Sprintf(`SELECT [event_id], [package], [name] FROM %s WHERE [event_session_id] = ?`, c.actions)
+	rows, err = db.Query(query, id)
+	if err != nil {
+		return have, errors.Wrap(err, "actions: db.query")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID int
+		var pkg, name string
+		err = rows.Scan(&eventID, &pkg, &name)
+		if err != nil {
+			return have, errors.Wrap(err, "actions: rows.scan")
+		}
+		i, ok := events[eventID]
+		if !ok {
+			continue
+		}
+		have.Events[i].Actions = append(have.Events[i].Actions, pkg+"."+name)
+	}
+	if err = rows.Err(); err != nil {
+		return have, errors.Wrap(err, "actions: rows.err")
+	}
+
+	query = fmt.Sprintf(`SELECT [target_id] FROM %s WHERE [event_session_id] = ? AND [name] = 'event_file'`, c.targets)
+	var targetID int
+	err = db.QueryRow(query, id).Scan(&targetID)
+	if err == sql.ErrNoRows {
+		return have, nil
+	}
+	if err != nil {
+		return have, errors.Wrap(err, "targets: db.queryrow.scan")
+	}
+	have.FileTarget = true
+	f := fields[targetID]
+	have.FileName = f["filename"]
+	// a missing size is the server default and never matches
+	have.MaxFileSizeMB, _ = strconv.Atoi(f["max_file_size"])
+	have.MaxRolloverFiles, _ = strconv.Atoi(f["max_rollover_files"])
+	return have, nil
+}
@@ -0,0 +1,203 @@
+package xe
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loginsDefinition() Definition {
+	return Definition{
+		Name: "logstash_logins",
+		Events: []EventDefinition{
+			{
+				Name:      "sqlserver.login",
+				Actions:   []string{"sqlserver.client_app_name", "sqlserver.client_hostname"},
+				Predicate: "[is_cached]=(0)",
+				Fields:    map[string]interface{}{"collect_options_text": int64(1)},
+			},
+			{
+				Name:    "sqlserver.error_reported",
+				Actions: []string{"sqlserver.client_app_name"},
+			},
+		},
+	}
+}
+
+func TestDefinitionValidate(t *testing.T) {
+	assert := assert.New(t)
+	d := loginsDefinition()
+	assert.NoError(d.Validate())
+	assert.Equal("logstash_logins", d.FileName)
+	assert.Equal(10, d.MaxFileSizeMB)
+	assert.Equal(20, d.MaxRolloverFiles)
+
+	d = loginsDefinition()
+	d.Events[0].Name = "login"
+	assert.Error(d.Validate())
+
+	d = loginsDefinition()
+	d.Events[1].Actions = []string{"sqlserver.sql_text); DROP TABLE x; --"}
+	assert.Error(d.Validate())
+
+	d = loginsDefinition()
+	d.Events[1].Name = "SQLSERVER.LOGIN"
+	assert.Error(d.Validate())
+
+	d = loginsDefinition()
+	d.Events[0].Fields["collect_options_text"] = 1.5
+	assert.Error(d.Validate())
+}
+
+func TestPlanCreate(t *testing.T) {
+	assert := assert.New(t)
+	d := loginsDefinition()
+	assert.NoError(d.Validate())
+	ddl, err := d.Plan(ServerScope, DeployedSession{})
+	assert.NoError(err)
+	assert.Equal(2, len(ddl))
+	assert.Equal(`CREATE EVENT SESSION [logstash_logins] ON SERVER
+ADD EVENT sqlserver.login(SET collect_options_text=(1) ACTION(sqlserver.client_app_name,sqlserver.client_hostname) WHERE ([is_cached]=(0))),
+ADD EVENT sqlserver.error_reported(ACTION(sqlserver.client_app_name))
+ADD TARGET package0.event_file(SET filename=N'logstash_logins',max_file_size=(10),max_rollover_files=(20))
+WITH (MAX_MEMORY=4096 KB,EVENT_RETENTION_MODE=ALLOW_SINGLE_EVENT_LOSS,MAX_DISPATCH_LATENCY=10 SECONDS,STARTUP_STATE=ON);`, ddl[0])
+	assert.Equal("ALTER EVENT SESSION [logstash_logins] ON SERVER STATE = START;", ddl[1])
+
+	// azure needs a file in blob storage
+	_, err = d.Plan(DatabaseScope, DeployedSession{})
+	assert.Error(err)
+	d.FileName = "https://acct.blob.core.windows.net/xe/logins.xel"
+	ddl, err = d.Plan(DatabaseScope, DeployedSession{})
+	assert.NoError(err)
+	assert.Contains(ddl[0], "ON DATABASE")
+}
+
+// deployed returns a session that matches the definition the way SQL
+// Server stores it
+func deployed(d Definition) DeployedSession {
+	have := DeployedSession{Exists: true, AutoStart: true, Running: true, FileTarget: true}
+	have.Name = d.Name
+	have.FileName = d.FileName
+	have.MaxFileSizeMB = d.MaxFileSizeMB
+	have.MaxRolloverFiles = d.MaxRolloverFiles
+	have.Events = []EventDefinition{
+		{
+			Name:      "sqlserver.login",
+			Actions:   []string{"sqlserver.client_hostname", "sqlserver.client_app_name"},
+			Predicate: "([is_cached]=(0))",
+			Fields:    map[string]interface{}{"collect_options_text": "1"},
+		},
+		{
+			Name:    "sqlserver.error_reported",
+			Actions: []string{"sqlserver.client_app_name"},
+		},
+	}
+	return have
+}
+
+func TestPlanNoChanges(t *testing.T) {
+	assert := assert.New(t)
+	d := loginsDefinition()
+	assert.NoError(d.Validate())
+	ddl, err := d.Plan(ServerScope, deployed(d))
+	assert.NoError(err)
+	assert.Equal(0, len(ddl))
+}
+
+func TestPlanAlter(t *testing.T) {
+	assert := assert.New(t)
+	d := loginsDefinition()
+	assert.NoError(d.Validate())
+
+	// drifted: an extra event, a missing action, small files, stopped
+	have := deployed(d)
+	have.Events[1].Actions = nil
+	have.Events = append(have.Events, EventDefinition{Name: "sqlserver.sql_batch_completed"})
+	have.MaxFileSizeMB = 5
+	have.Running = false
+	have.AutoStart = false
+
+	ddl, err := d.Plan(ServerScope, have)
+	assert.NoError(err)
+	assert.Equal([]string{
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER DROP EVENT sqlserver.error_reported;",
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER ADD EVENT sqlserver.error_reported(ACTION(sqlserver.client_app_name));",
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER DROP EVENT sqlserver.sql_batch_completed;",
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER DROP TARGET package0.event_file;",
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER ADD TARGET package0.event_file(SET filename=N'logstash_logins',max_file_size=(10),max_rollover_files=(20));",
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER WITH (STARTUP_STATE=ON);",
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER STATE = START;",
+	}, ddl)
+
+	// nothing matches so the new events are added before the old one is
+	// dropped.  The session and its other targets stay.
+	have = deployed(d)
+	have.Events = []EventDefinition{{Name: "sqlserver.rpc_completed"}}
+	ddl, err = d.Plan(ServerScope, have)
+	assert.NoError(err)
+	assert.Equal([]string{
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER ADD EVENT sqlserver.login(SET collect_options_text=(1) ACTION(sqlserver.client_app_name,sqlserver.client_hostname) WHERE ([is_cached]=(0)));",
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER ADD EVENT sqlserver.error_reported(ACTION(sqlserver.client_app_name));",
+		"ALTER EVENT SESSION [logstash_logins] ON SERVER DROP EVENT sqlserver.rpc_completed;",
+	}, ddl)
+}
+
+func TestSamePredicate(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(samePredicate("[sqlserver].[client_app_name]=N'Bad App'", "([sqlserver].[client_app_name]='Bad App')"))
+	assert.True(samePredicate("database_name = N'N''s'", "([database_name]='N''s')"))
+	assert.False(samePredicate("[client_app_name]=N'Bad App'", "[client_app_name]='Good App'"))
+	assert.False(samePredicate("[is_cached]=(0)", "[is_cached]=(1)"))
+
+	// only the text outside the literals is normalized
+	assert.True(samePredicate("[SQLServer].[Database_Name] = N'Sales DB'", "([sqlserver].[database_name]=N'Sales DB')"))
+	assert.False(samePredicate("[sqlserver].[database_name]=N'Sales DB'", "[sqlserver].[database_name]=N'salesdb'"))
+	assert.False(samePredicate("[client_app_name]=N'(App)'", "[client_app_name]=N'App'"))
+}
+
+func TestGetDeployedSession(t *testing.T) {
+	assert := assert.New(t)
+	sql.Register("deploy_standin", &azureStandin{views: map[string][][]driver.Value{
+		"server_event_sessions": {{int64(65536), true, false}},
+		"dm_xe_sessions":        nil,
+		"server_event_session_fields": {
+			{int64(1), "collect_options_text", "1"},
+			{int64(3), "filename", "logstash_logins"},
+			{int64(3), "max_file_size", "10"},
+			{int64(3), "max_rollover_files", "20"},
+		},
+		"server_event_session_events": {
+			{int64(1), "sqlserver", "login", "([is_cached]=(0))"},
+			{int64(2), "sqlserver", "error_reported", nil},
+		},
+		"server_event_session_actions": {
+			{int64(1), "sqlserver", "client_app_name"},
+			{int64(1), "sqlserver", "client_hostname"},
+			{int64(2), "sqlserver", "client_app_name"},
+		},
+		"server_event_session_targets": {{int64(3)}},
+	}})
+	db, err := sql.Open("deploy_standin", "")
+	assert.NoError(err)
+	defer db.Close()
+
+	have, err := GetDeployedSession(db, ServerScope, "logstash_logins")
+	assert.NoError(err)
+	assert.True(have.Exists)
+	assert.True(have.AutoStart)
+	assert.False(have.Running)
+	assert.True(have.FileTarget)
+	assert.Equal(2, len(have.Events))
+	assert.Equal("sqlserver.login", have.Events[0].Name)
+	assert.Equal([]string{"sqlserver.client_app_name", "sqlserver.client_hostname"}, have.Events[0].Actions)
+	assert.Equal("1", have.Events[0].Fields["collect_options_text"])
+	assert.Equal(20, have.MaxRolloverFiles)
+
+	d := loginsDefinition()
+	assert.NoError(d.Validate())
+	ddl, err := d.Plan(ServerScope, have)
+	assert.NoError(err)
+	assert.Equal([]string{"ALTER EVENT SESSION [logstash_logins] ON SERVER STATE = START;"}, ddl)
+}
@@ -0,0 +1,53 @@
+package xe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errorLogErrorLine is the first line of an error that xp_readerrorlog
+// returns on its own row.  The message follows on the next row.
+var errorLogErrorLine = regexp.MustCompile(`^Error:\s\d+,\sSeverity:\s\d+,\sState:\s\d+\.?\s*$`)
+
+// ErrorLogLine is one row from xp_readerrorlog
+type ErrorLogLine struct {
+	LogDate     time.Time // server local time
+	ProcessInfo string
+	Text        string
+}
+
+// String formats the line the way it appears in the error log file
+// and the message of an errorlog_written event
+func (l ErrorLogLine) String() string {
+	ts := l.LogDate.Format("2006-01-02 15:04:05.000")
+	return fmt.Sprintf("%s %-12s%s", ts[:len(ts)-1], l.ProcessInfo, l.Text)
+}
+
+// Continues is true if next is the message for an "Error: n, Severity: n, State: n."
+// line.  The errorlog_written event has both in one message.
+func (l ErrorLogLine) Continues(next ErrorLogLine) bool {
+	return errorLogErrorLine.MatchString(strings.TrimSpace(l.Text)) &&
+		next.LogDate.Equal(l.LogDate) &&
+		next.ProcessInfo == l.ProcessInfo
+}
+
+// NewErrorLogEvent builds an errorlog_written event from error log lines
+// read by xp_readerrorlog so older servers without the XE event produce
+// the same fields.  The local time is converted to UTC using utcOffset.
+func NewErrorLogEvent(i *SQLInfo, utcOffset time.Duration, lines ...ErrorLogLine) Event {
+	event := make(Event)
+	msgs := make([]string, 0, len(lines))
+	for _, l := range lines {
+		msgs = append(msgs, l.String())
+	}
+	event["name"] = "errorlog_written"
+	event["message"] = strings.Join(msgs, "  ")
+	if len(lines) > 0 {
+		ts := lines[0].LogDate
+		event["timestamp"] = time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), time.UTC).Add(-utcOffset)
+	}
+	event.enrich(i)
+	return event
+}
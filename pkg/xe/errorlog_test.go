@@ -0,0 +1,35 @@
+package xe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewErrorLogEvent(t *testing.T) {
+	assert := assert.New(t)
+	ts := time.Date(2020, 7, 12, 15, 29, 10, 110000000, time.UTC)
+	errLine := ErrorLogLine{LogDate: ts, ProcessInfo: "Logon", Text: "Error: 18456, Severity: 14, State: 5."}
+	msgLine := ErrorLogLine{LogDate: ts, ProcessInfo: "Logon", Text: "Login failed for user 'asdfasfd'. Reason: Could not find a login matching the name provided. [CLIENT: 192.168.7.40]"}
+	assert.Equal("2020-07-12 15:29:10.11 Logon       Error: 18456, Severity: 14, State: 5.", errLine.String())
+	assert.True(errLine.Continues(msgLine))
+	assert.False(msgLine.Continues(errLine))
+	assert.False(errLine.Continues(ErrorLogLine{LogDate: ts, ProcessInfo: "spid51", Text: "x"}))
+
+	// UTC-5
+	event := NewErrorLogEvent(&i, -5*time.Hour, errLine, msgLine)
+	assert.Equal("errorlog_written", event.Name())
+	assert.Equal(time.Date(2020, 7, 12, 20, 29, 10, 110000000, time.UTC), event.Timestamp())
+	assert.Equal("logon", event.GetString("errorlog_process"))
+	assert.Equal("Error: 18456, Severity: 14, State: 5.  Login failed for user 'asdfasfd'. Reason: Could not find a login matching the name provided. [CLIENT: 192.168.7.40]", event.GetString("errorlog_message"))
+	assert.Equal(int64(18456), event["error_number"])
+	assert.Equal(int64(14), event["severity"])
+	assert.Equal("192.168.7.40", event.GetString("xe_client_address"))
+	assert.Equal("errorlog_written", event.GetString("xe_category"))
+	assert.NotEmpty(event.GetString("login_failed"))
+
+	event = NewErrorLogEvent(&i, 0, ErrorLogLine{LogDate: ts, ProcessInfo: "Backup", Text: "BACKUP DATABASE successfully processed 27154 pages in 0.207 seconds (1024.833 MB/sec)."})
+	assert.Equal("backup", event.GetString("errorlog_process"))
+	assert.Equal("BACKUP DATABASE successfully processed 27154 pages in 0.207 seconds (1024.833 MB/sec).", event.GetString("xe_description"))
+}
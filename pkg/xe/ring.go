@@ -0,0 +1,88 @@
+package xe
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RingEvent is one event from a ring_buffer target
+type RingEvent struct {
+	Timestamp time.Time
+	XML       string
+}
+
+// GetRingBuffer returns the target_data for the ring_buffer target of a
+// running session.  It returns ErrNoFileTarget if the session has neither.
+func GetRingBuffer(db *sql.DB, scope Scope, session string) (string, error) {
+	c := scope.catalog()
+	query := fmt.Sprintf(`
+		SELECT	CAST(T.[target_data] AS NVARCHAR(MAX))
+		FROM	%s S
+		JOIN	%s T ON T.[event_session_address] = S.[address]
+		WHERE	S.[name] = ?
+		AND		T.[target_name] = 'ring_buffer'`, c.running, c.runningTargets)
+
+	var targetData sql.NullString
+	err := db.QueryRow(query, session).Scan(&targetData)
+	if err == sql.ErrNoRows {
+		return "", ErrNoFileTarget
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "db.queryrow.scan")
+	}
+	return targetData.String, nil
+}
+
+// RingBufferEvents splits the target_data from a ring_buffer into events
+// that can be passed to Parse.  They are returned oldest first.
+func RingBufferEvents(targetData string) ([]RingEvent, error) {
+	events := make([]RingEvent, 0)
+	d := xml.NewDecoder(strings.NewReader(targetData))
+	for {
+		start := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return events, errors.Wrap(err, "decoder.token")
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "event" {
+			continue
+		}
+		err = d.Skip()
+		if err != nil {
+			return events, errors.Wrap(err, "decoder.skip")
+		}
+
+		var ev RingEvent
+		for _, a := range se.Attr {
+			if a.Name.Local == "timestamp" {
+				ev.Timestamp, err = time.Parse(time.RFC3339Nano, a.Value)
+				if err != nil {
+					return events, errors.Wrap(err, "timestamp")
+				}
+			}
+		}
+		if ev.Timestamp.IsZero() {
+			return events, errors.New("event missing timestamp")
+		}
+		ev.XML = targetData[start:d.InputOffset()]
+		events = append(events, ev)
+	}
+
+	// the ring buffer has a buffer for each CPU so the events
+	// aren't always in order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events, nil
+}
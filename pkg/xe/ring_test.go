@@ -0,0 +1,38 @@
+package xe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingBufferEvents(t *testing.T) {
+	assert := assert.New(t)
+	targetData := `<RingBufferTarget truncated="0" processingTime="0" totalEventsProcessed="2" eventCount="2" droppedCount="0" memoryUsed="1024">
+<event name="error_reported" package="sqlserver" timestamp="2024-04-08T16:00:55.100Z"><data name="error_number"><value>50000</value></data><data name="message"><value>second</value></data></event>
+<event name="error_reported" package="sqlserver" timestamp="2024-04-08T16:00:53.427Z"><data name="error_number"><value>50000</value></data><data name="message"><value><![CDATA[first & <one>]]></value></data></event>
+</RingBufferTarget>`
+	events, err := RingBufferEvents(targetData)
+	assert.NoError(err)
+	assert.Equal(2, len(events))
+	if len(events) != 2 {
+		return
+	}
+	assert.Equal(int64(1712592053427), events[0].Timestamp.UnixMilli())
+	assert.Contains(events[0].XML, "first &")
+
+	event, err := Parse(&i, events[0].XML, false)
+	assert.NoError(err)
+	assert.Equal("error_reported", event.Name())
+	assert.Equal("first & <one>", event.GetString("message"))
+	event, err = Parse(&i, events[1].XML, false)
+	assert.NoError(err)
+	assert.Equal("second", event.GetString("message"))
+
+	events, err = RingBufferEvents(`<RingBufferTarget eventCount="0"></RingBufferTarget>`)
+	assert.NoError(err)
+	assert.Equal(0, len(events))
+
+	_, err = RingBufferEvents(`<RingBufferTarget><event name="x"></event></RingBufferTarget>`)
+	assert.Error(err)
+}
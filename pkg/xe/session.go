@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"fmt"
 	"path"
-	"path/filepath"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -28,9 +27,54 @@ type Session struct {
 	WildCard string
 }
 
+// Scope selects the catalog views for server or database scoped sessions
+type Scope int
+
+const (
+	// ServerScope sessions are in sys.server_event_sessions
+	ServerScope Scope = iota
+	// DatabaseScope sessions are in sys.database_event_sessions.  Azure SQL Database only has these.
+	DatabaseScope
+)
+
+// catalog holds the names of the views for a scope
+type catalog struct {
+	sessions       string
+	events         string
+	actions        string
+	targets        string
+	fields         string
+	running        string
+	runningTargets string
+}
+
+func (s Scope) catalog() catalog {
+	if s == DatabaseScope {
+		return catalog{
+			sessions:       "[sys].[database_event_sessions]",
+			events:         "[sys].[database_event_session_events]",
+			actions:        "[sys].[database_event_session_actions]",
+			targets:        "[sys].[database_event_session_targets]",
+			fields:         "[sys].[database_event_session_fields]",
+			running:        "[sys].[dm_xe_database_sessions]",
+			runningTargets: "[sys].[dm_xe_database_session_targets]",
+		}
+	}
+	return catalog{
+		sessions:       "[sys].[server_event_sessions]",
+		events:         "[sys].[server_event_session_events]",
+		actions:        "[sys].[server_event_session_actions]",
+		targets:        "[sys].[server_event_session_targets]",
+		fields:         "[sys].[server_event_session_fields]",
+		running:        "[sys].[dm_xe_sessions]",
+		runningTargets: "[sys].[dm_xe_session_targets]",
+	}
+}
+
 // GetSession returns an XE session from the database
-func GetSession(db *sql.DB, session string) (s Session, err error) {
-	query := `
+func GetSession(db *sql.DB, scope Scope, session string) (s Session, err error) {
+	c := scope.catalog()
+	query := fmt.Sprintf(`
 	
 		SELECT 
 			ses.[name],
@@ -38,33 +82,39 @@ func GetSession(db *sql.DB, session string) (s Session, err error) {
 			--sesf.[object_id],
 			-- sesf.[name],
 			CAST(sesf.[value] AS NVARCHAR(1024)) AS [value]
-		FROM sys.server_event_sessions ses 
-		JOIN sys.server_event_session_targets sest ON sest.event_session_id = ses.event_session_id
-		JOIN sys.server_event_session_fields sesf ON sesf.event_session_id = ses.event_session_id
+		FROM %s ses 
+		JOIN %s sest ON sest.event_session_id = ses.event_session_id
+		JOIN %s sesf ON sesf.event_session_id = ses.event_session_id
 												AND sesf.object_id = sest.target_id 
 		WHERE	sesf.[name] = 'filename'
 		AND		ses.[name] = ?
-	`
+	`, c.sessions, c.targets, c.fields)
 	err = db.QueryRow(query, session).Scan(&s.Name, &s.Filename)
 	if err != nil {
 		return s, errors.Wrap(err, "db.queryrow.scan")
 	}
+	s.Filename, s.WildCard = wildCard(s.Filename)
+	return s, err
+}
 
-	// if no extension, add one
-	ext := filepath.Ext(s.Filename)
+// wildCard adds the .xel extension if there isn't one and returns the
+// pattern for all the rollover files.  Azure SQL Database writes to
+// blob storage so the file name can be a URL.
+func wildCard(fileName string) (string, string) {
+	ext := path.Ext(fileName)
+	if strings.ContainsAny(ext, `\/`) {
+		ext = ""
+	}
 	if ext == "" {
-		s.Filename += ".xel"
+		fileName += ".xel"
+		ext = ".xel"
 	}
-
-	// build the wildcard
-	basePath := strings.TrimSuffix(s.Filename, path.Ext(s.Filename))
-	s.WildCard = basePath + "*" + path.Ext(s.Filename)
-
-	return s, err
+	basePath := strings.TrimSuffix(fileName, ext)
+	return fileName, basePath + "*" + ext
 }
 
 // ValidateSession confirms that a session is valid and has a file target
-func ValidateSession(db *sql.DB, session string) error {
+func ValidateSession(db *sql.DB, scope Scope, session string) error {
 
 	// if isrunning and hasfiletarget then OK
 	// if no rows (session doesn't exist), warning "ErrNotFound" -- ignore unless strict (TODO)
@@ -72,19 +122,20 @@ func ValidateSession(db *sql.DB, session string) error {
 	// if autostart not isrunning then warning "ErrNotRunning" -- error
 	// if isrunning and not hasfiletarget then warning "ErrNoFileTarget" -- error
 
-	query := `
+	c := scope.catalog()
+	query := fmt.Sprintf(`
 		SELECT	[session].[name],
 			[session].[startup_state] AS [AutoStart],
 			--[running].[create_time] AS [StartTime],
 			CAST((CASE WHEN ([running].[create_time] IS NULL) THEN 0 ELSE 1 END) AS BIT)AS [IsRunning],
 			CAST((CASE WHEN T.[event_session_address] IS NOT NULL THEN 1 ELSE 0 END) AS BIT) AS [HasFileTarget]
-		FROM	[sys].[server_event_sessions] AS [session]
+		FROM	%s AS [session]
 		LEFT OUTER JOIN 
-			[sys].[dm_xe_sessions] AS [running] ON [running].[name] = [session].[name]
+			%s AS [running] ON [running].[name] = [session].[name]
 		LEFT OUTER JOIN 
-		[sys].[dm_xe_session_targets] T ON T.[event_session_address] = [running].[address]
+		%s T ON T.[event_session_address] = [running].[address]
 						AND T.[target_name] = 'event_file'
-		WHERE 	[session].[name] = ?`
+		WHERE 	[session].[name] = ?`, c.sessions, c.running, c.runningTargets)
 
 	var name string
 	var autostart, isrunning, hasfiletarget bool
@@ -95,6 +146,9 @@ func ValidateSession(db *sql.DB, session string) error {
 	if err == sql.ErrNoRows {
 		return ErrNotFound
 	}
+	if err != nil {
+		return errors.Wrap(err, "db.queryrow.scan")
+	}
 	if autostart && !isrunning {
 		return ErrNotRunning
 	}
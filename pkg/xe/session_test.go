@@ -0,0 +1,145 @@
+package xe
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// azureStandin mimics the catalog views in Azure SQL Database.  A query
+// that reads a view Azure doesn't have fails the same way.  Otherwise it
+// returns the rows for the first view in the query.
+type azureStandin struct {
+	views map[string][][]driver.Value
+}
+
+var standinViewRegex = regexp.MustCompile(`\[sys\]\.\[(\w+)\]|sys\.(\w+)`)
+
+func (d *azureStandin) Open(string) (driver.Conn, error) { return &standinConn{d}, nil }
+
+type standinConn struct{ d *azureStandin }
+
+func (c *standinConn) Prepare(query string) (driver.Stmt, error) {
+	return &standinStmt{c.d, query}, nil
+}
+func (c *standinConn) Close() error              { return nil }
+func (c *standinConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("not supported") }
+
+type standinStmt struct {
+	d     *azureStandin
+	query string
+}
+
+func (s *standinStmt) Close() error  { return nil }
+func (s *standinStmt) NumInput() int { return -1 }
+func (s *standinStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (s *standinStmt) Query([]driver.Value) (driver.Rows, error) {
+	var first string
+	for _, m := range standinViewRegex.FindAllStringSubmatch(s.query, -1) {
+		view := strings.ToLower(m[1] + m[2])
+		if _, ok := s.d.views[view]; !ok {
+			return nil, fmt.Errorf("Invalid object name 'sys.%s'", view)
+		}
+		if first == "" {
+			first = view
+		}
+	}
+	return &standinRows{rows: s.d.views[first]}, nil
+}
+
+type standinRows struct {
+	rows [][]driver.Value
+	n    int
+}
+
+func (r *standinRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"a", "b", "c", "d"}
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (r *standinRows) Close() error { return nil }
+func (r *standinRows) Next(dest []driver.Value) error {
+	if r.n >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.n])
+	r.n++
+	return nil
+}
+
+func init() {
+	sql.Register("azure_standin", &azureStandin{views: map[string][][]driver.Value{
+		"database_event_sessions":        {{"logins", false, true, true}},
+		"database_event_session_targets": nil,
+		"database_event_session_fields":  nil,
+		"dm_xe_database_sessions":        {{"<RingBufferTarget></RingBufferTarget>"}},
+		"dm_xe_database_session_targets": nil,
+	}})
+}
+
+func TestDatabaseScopedSessions(t *testing.T) {
+	assert := assert.New(t)
+	db, err := sql.Open("azure_standin", "")
+	assert.NoError(err)
+	defer db.Close()
+
+	// the server views don't exist in Azure SQL Database
+	err = ValidateSession(db, ServerScope, "logins")
+	assert.Error(err)
+	assert.Contains(err.Error(), "server_event_sessions")
+
+	err = ValidateSession(db, DatabaseScope, "logins")
+	assert.NoError(err)
+
+	targetData, err := GetRingBuffer(db, DatabaseScope, "logins")
+	assert.NoError(err)
+	assert.Equal("<RingBufferTarget></RingBufferTarget>", targetData)
+
+	info := SQLInfo{EngineEdition: EngineAzureSQLDatabase}
+	assert.Equal(DatabaseScope, info.Scope())
+	info.EngineEdition = 3
+	assert.Equal(ServerScope, info.Scope())
+}
+
+func TestGetDatabaseScopedSession(t *testing.T) {
+	assert := assert.New(t)
+	sql.Register("azure_standin_session", &azureStandin{views: map[string][][]driver.Value{
+		"database_event_sessions":        {{"logins", "https://acct.blob.core.windows.net/xe/logins"}},
+		"database_event_session_targets": nil,
+		"database_event_session_fields":  nil,
+	}})
+	db, err := sql.Open("azure_standin_session", "")
+	assert.NoError(err)
+	defer db.Close()
+
+	s, err := GetSession(db, DatabaseScope, "logins")
+	assert.NoError(err)
+	assert.Equal("https://acct.blob.core.windows.net/xe/logins.xel", s.Filename)
+	assert.Equal("https://acct.blob.core.windows.net/xe/logins*.xel", s.WildCard)
+}
+
+func TestWildCard(t *testing.T) {
+	assert := assert.New(t)
+	type test struct{ in, file, wild string }
+	tt := []test{
+		{`D:\XE\logins.xel`, `D:\XE\logins.xel`, `D:\XE\logins*.xel`},
+		{`D:\XE\logins`, `D:\XE\logins.xel`, `D:\XE\logins*.xel`},
+		{`D:\XE.v2\logins`, `D:\XE.v2\logins.xel`, `D:\XE.v2\logins*.xel`},
+		{`/var/opt/mssql/log/system_health.xel`, `/var/opt/mssql/log/system_health.xel`, `/var/opt/mssql/log/system_health*.xel`},
+		{`https://acct.blob.core.windows.net/xe/logins.xel`, `https://acct.blob.core.windows.net/xe/logins.xel`, `https://acct.blob.core.windows.net/xe/logins*.xel`},
+	}
+	for _, tc := range tt {
+		file, wild := wildCard(tc.in)
+		assert.Equal(tc.file, file, tc.in)
+		assert.Equal(tc.wild, wild, tc.in)
+	}
+}
@@ -3,6 +3,8 @@ package xe
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/billgraziano/xelogstash/pkg/dbx"
@@ -26,11 +28,19 @@ type SQLInfo struct {
 	Fields       map[FieldTypeKey]string
 	Actions      map[string]string
 	MapValues    map[MapValueKey]string
+	MapTypes     Set[string] // types that have values in sys.dm_xe_map_values
 	Databases    map[int64]*Database
 	LoginErrors  map[int64]bool
 	LoggedErrors Set[int64]
 
-	DB *sql.DB
+	DB            *sql.DB
+	LoadedAt      time.Time // when the metadata was read
+	EngineEdition int       // SERVERPROPERTY('EngineEdition')
+
+	serverOverride string
+	domainOverride string
+	unknown        map[string]bool // databases and fields Parse didn't find since the last load
+	missing        map[string]bool // databases and fields that weren't found by the last load
 }
 
 // Database holds some basic information about a database on the server
@@ -54,13 +64,6 @@ type MapValueKey struct {
 // NewSQLInfo gets basic SQL Server info and lookup values
 func NewSQLInfo(driver, cxnstring, serverOverride, domainOverride string) (info SQLInfo, err error) {
 	//func GetSQLInfo(fqdn string, user, password string) (info SQLInfo, err error) {
-	info.Fields = make(map[FieldTypeKey]string)
-	info.Actions = make(map[string]string)
-	info.MapValues = make(map[MapValueKey]string)
-	info.AvailibilityGroups = make([]string, 0)
-	info.Listeners = make([]string, 0)
-	info.LoggedErrors = NewSet[int64]() // roughly 1300 logged errrors as of SQL 2022
-
 	db, err := dbx.Open(driver, cxnstring)
 	if err != nil {
 		return info, errors.Wrap(err, "opendb")
@@ -75,32 +78,59 @@ func NewSQLInfo(driver, cxnstring, serverOverride, domainOverride string) (info
 	}
 
 	info.DB = db
+	info.serverOverride = serverOverride
+	info.domainOverride = domainOverride
+	err = info.Load()
+	return info, err
+}
+
+// Load reads the server info and lookup values using the open connection.
+// It replaces anything loaded before.
+func (i *SQLInfo) Load() (err error) {
+	i.Fields = make(map[FieldTypeKey]string)
+	i.Actions = make(map[string]string)
+	i.MapValues = make(map[MapValueKey]string)
+	i.MapTypes = NewSet[string]()
+	i.AvailibilityGroups = make([]string, 0)
+	i.Listeners = make([]string, 0)
+	i.LoggedErrors = NewSet[int64]() // roughly 1300 logged errrors as of SQL 2022
+	db := i.DB
+
+	err = db.QueryRow("SELECT CAST(SERVERPROPERTY('EngineEdition') AS INT);").Scan(&i.EngineEdition)
+	if err != nil {
+		return errors.Wrap(err, "engineedition")
+	}
+	// DEFAULT_DOMAIN() isn't in Azure SQL Database
+	domain := "COALESCE(DEFAULT_DOMAIN(), '')"
+	if i.Scope() == DatabaseScope {
+		domain = "''"
+	}
 
 	query := `
 	SET NOCOUNT ON;
 	SELECT	@@SERVERNAME AS [ServerName]
-		,COALESCE(DEFAULT_DOMAIN(), '') AS [DomainName]
+		,` + domain + ` AS [DomainName]
 		,COALESCE(CAST(SERVERPROPERTY('MachineName') as nvarchar(128)), @@SERVERNAME) AS [Computer]
 		,CAST(COALESCE(SERVERPROPERTY('ProductLevel'), '') as nvarchar(128)) AS ProductLevel
 		,COALESCE(CAST(SERVERPROPERTY('ProductMajorVersion') as NVARCHAR(128))  + '.' + CAST(SERVERPROPERTY('ProductMinorVersion') as NVARCHAR(128)),'') AS ProductRelease
 		,COALESCE(CAST(SERVERPROPERTY('ProductVersion') AS NVARCHAR(128)), '') as [ProductVersion];
 	`
 	row := db.QueryRow(query)
-	err = row.Scan(&info.Server, &info.Domain, &info.Computer, &info.ProductLevel, &info.ProductRelease, &info.ProductVersion)
+	err = row.Scan(&i.Server, &i.Domain, &i.Computer, &i.ProductLevel, &i.ProductRelease, &i.ProductVersion)
 	if err != nil {
-		return info, errors.Wrap(err, "scan")
+		return errors.Wrap(err, "scan")
 	}
 
-	if serverOverride != "" {
-		info.Server = serverOverride
-		info.Computer = serverOverride
+	if i.serverOverride != "" {
+		i.Server = i.serverOverride
+		i.Computer = i.serverOverride
 	}
-	if domainOverride != "" {
-		info.Domain = domainOverride
+	if i.domainOverride != "" {
+		i.Domain = i.domainOverride
 	}
 
 	var v string
-	switch info.ProductRelease {
+	switch i.ProductRelease {
 	case "17.0":
 		v = "SQL Server 2025"
 	case "16.0":
@@ -122,9 +152,9 @@ func NewSQLInfo(driver, cxnstring, serverOverride, domainOverride string) (info
 	case "9.0":
 		v = "SQL Server 2005"
 	default:
-		v = fmt.Sprintf("SQL Server %s", info.ProductRelease)
+		v = fmt.Sprintf("SQL Server %s", i.ProductRelease)
 	}
-	info.Version = fmt.Sprintf("%s %s", v, info.ProductLevel)
+	i.Version = fmt.Sprintf("%s %s", v, i.ProductLevel)
 
 	var object, name, dt string
 
@@ -137,19 +167,19 @@ func NewSQLInfo(driver, cxnstring, serverOverride, domainOverride string) (info
 	query = "select name, type_name from sys.dm_xe_objects where object_type = 'action' order by type_name;"
 	rows, err := db.Query(query)
 	if err != nil {
-		return info, errors.Wrap(err, "action-query")
+		return errors.Wrap(err, "action-query")
 	}
 
 	for rows.Next() {
 		err = rows.Scan(&name, &dt)
 		if err != nil {
-			return info, errors.Wrap(err, "action-scan")
+			return errors.Wrap(err, "action-scan")
 		}
-		info.Actions[name] = dt
+		i.Actions[name] = dt
 	}
 	err = rows.Close()
 	if err != nil {
-		return info, errors.Wrap(err, "rows.close")
+		return errors.Wrap(err, "rows.close")
 	}
 
 	// Get the fields
@@ -161,59 +191,127 @@ func NewSQLInfo(driver, cxnstring, serverOverride, domainOverride string) (info
 
 	rows, err = db.Query(query)
 	if err != nil {
-		return info, errors.Wrap(err, "action-query")
+		return errors.Wrap(err, "action-query")
 	}
 
 	for rows.Next() {
 		err = rows.Scan(&object, &name, &dt)
 		if err != nil {
-			return info, errors.Wrap(err, "action-scan")
+			return errors.Wrap(err, "action-scan")
 		}
 		dtkey := FieldTypeKey{Name: name, Object: object}
-		info.Fields[dtkey] = dt
+		i.Fields[dtkey] = dt
 	}
 	err = rows.Close()
 	if err != nil {
-		return info, errors.Wrap(err, "rows.close")
+		return errors.Wrap(err, "rows.close")
 	}
 
-	err = info.getMapValues()
+	err = i.getMapValues()
 	if err != nil {
-		return info, errors.Wrap(err, "info.getmapvalues")
+		return errors.Wrap(err, "info.getmapvalues")
 	}
 
-	err = info.getDatabases()
+	err = i.getDatabases()
 	if err != nil {
-		return info, errors.Wrap(err, "info.getdatabases")
+		return errors.Wrap(err, "info.getdatabases")
 	}
 
-	err = info.getLoginErrors()
+	err = i.getLoginErrors()
 	if err != nil {
-		return info, errors.Wrap(err, "info.getloginerrors")
+		return errors.Wrap(err, "info.getloginerrors")
 	}
 
-	err = info.getLoggedErrors()
+	err = i.getLoggedErrors()
 	if err != nil {
-		return info, errors.Wrap(err, "info.getloggederrors")
+		return errors.Wrap(err, "info.getloggederrors")
 	}
 
-	availGroups, err := stringArrayFromQuery(info.DB, "IF OBJECT_ID('sys.availability_groups') IS NOT NULL SELECT [name] FROM sys.availability_groups ORDER BY [name];")
+	availGroups, err := stringArrayFromQuery(i.DB, "IF OBJECT_ID('sys.availability_groups') IS NOT NULL SELECT [name] FROM sys.availability_groups ORDER BY [name];")
 	if err != nil {
-		return info, errors.Wrap(err, "ag")
+		return errors.Wrap(err, "ag")
 	}
-	info.AvailibilityGroups = availGroups
+	i.AvailibilityGroups = availGroups
 
-	listeners, err := stringArrayFromQuery(info.DB, "IF OBJECT_ID('sys.availability_group_listeners') IS NOT NULL select [dns_name] from sys.availability_group_listeners ORDER BY [dns_name];")
+	listeners, err := stringArrayFromQuery(i.DB, "IF OBJECT_ID('sys.availability_group_listeners') IS NOT NULL select [dns_name] from sys.availability_group_listeners ORDER BY [dns_name];")
 	if err != nil {
-		return info, errors.Wrap(err, "ag")
+		return errors.Wrap(err, "ag")
+	}
+	i.Listeners = listeners
+	i.loaded()
+	return nil
+}
+
+// loaded marks the metadata as current.  Anything Parse didn't find before
+// this load and still isn't there won't force another one until the next
+// load.  Older entries are dropped so a database or field that shows up
+// later is noticed again.
+func (i *SQLInfo) loaded() {
+	i.missing = nil
+	for k := range i.unknown {
+		if i.has(k) {
+			continue
+		}
+		if i.missing == nil {
+			i.missing = make(map[string]bool)
+		}
+		i.missing[k] = true
 	}
-	info.Listeners = listeners
+	i.unknown = nil
+	i.LoadedAt = time.Now()
+}
+
+// has is true if the database or field from notice is in the metadata
+func (i *SQLInfo) has(key string) bool {
+	kind, name, _ := strings.Cut(key, ":")
+	switch kind {
+	case "database":
+		id, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			return false
+		}
+		_, ok := i.Databases[id]
+		return ok
+	case "field":
+		object, field, _ := strings.Cut(name, ".")
+		_, ok := i.Fields[FieldTypeKey{Object: object, Name: field}]
+		return ok
+	}
+	return false
+}
+
+// EngineAzureSQLDatabase is the SERVERPROPERTY('EngineEdition') for Azure SQL Database
+const EngineAzureSQLDatabase = 5
+
+// Scope returns where the event sessions are defined.  Azure SQL Database
+// only has database scoped sessions.
+func (i *SQLInfo) Scope() Scope {
+	if i.EngineEdition == EngineAzureSQLDatabase {
+		return DatabaseScope
+	}
+	return ServerScope
+}
+
+// notice records a database or field that isn't in the metadata
+func (i *SQLInfo) notice(key string) {
+	if i.missing[key] {
+		return
+	}
+	if i.unknown == nil {
+		i.unknown = make(map[string]bool)
+	}
+	i.unknown[key] = true
+}
 
-	return info, nil
+// Stale is true if Parse found a database or field that isn't in the
+// metadata.  Calling Load again may find it.
+func (i *SQLInfo) Stale() bool {
+	return len(i.unknown) > 0
 }
 
 func (i *SQLInfo) getMapValues() error {
 	i.MapValues = make(map[MapValueKey]string)
+	i.MapTypes = NewSet[string]()
 
 	query := "select [name], [map_key], [map_value] from sys.dm_xe_map_values"
 
@@ -232,6 +330,7 @@ func (i *SQLInfo) getMapValues() error {
 		mapValueKey := MapValueKey{Name: name, MapKey: mapKey}
 		//info.Fields[dtkey] = dt
 		i.MapValues[mapValueKey] = mapValue
+		i.MapTypes.Add(name)
 	}
 	err = rows.Close()
 	if err != nil {
@@ -0,0 +1,105 @@
+package xe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/logstash"
+)
+
+var traceNameRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// TraceEvent is one row from the default trace
+type TraceEvent struct {
+	EventClass      int64
+	EventName       string // from sys.trace_events
+	SubClassName    string // from sys.trace_subclass_values
+	StartTime       time.Time
+	EventSequence   int64
+	SPID            int64
+	DatabaseName    string
+	ObjectName      string
+	LoginName       string
+	HostName        string
+	ApplicationName string
+	TextData        string
+	FileName        string
+	Error           int64
+	Severity        int64
+	Duration        int64 // microseconds
+	IntegerData     int64
+}
+
+// TraceEventName converts a trace event name like "Data File Auto Grow"
+// or "Object:Created" to an event name like "data_file_auto_grow"
+func TraceEventName(name string) string {
+	return strings.Trim(traceNameRegex.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// NewTraceEvent builds an event from a default trace row.  The names of
+// the fields match the XE events where there is one.  The local time is
+// converted to UTC using utcOffset.
+func NewTraceEvent(i *SQLInfo, utcOffset time.Duration, t TraceEvent) Event {
+	event := make(Event)
+	st := t.StartTime
+	event["name"] = TraceEventName(t.EventName)
+	event["timestamp"] = time.Date(st.Year(), st.Month(), st.Day(), st.Hour(), st.Minute(), st.Second(), st.Nanosecond(), time.UTC).Add(-utcOffset)
+	event["trace_event_class"] = t.EventClass
+	event["trace_event_name"] = t.EventName
+	event["event_sequence"] = t.EventSequence
+	setIf := func(key, value string) {
+		if value != "" {
+			event[key] = value
+		}
+	}
+	setIf("trace_subclass", t.SubClassName)
+	setIf("database_name", t.DatabaseName)
+	setIf("object_name", t.ObjectName)
+	setIf("server_principal_name", t.LoginName)
+	setIf("client_hostname", t.HostName)
+	setIf("client_app_name", t.ApplicationName)
+	setIf("text_data", left(t.TextData, 8000, "..."))
+	setIf("file_name", t.FileName)
+	if t.SPID > 0 {
+		event["session_id"] = t.SPID
+	}
+	if t.Error > 0 {
+		event["error_number"] = t.Error
+	}
+	if t.Severity > 0 {
+		event["severity"] = t.Severity
+	}
+	if t.Duration > 0 {
+		event["duration"] = t.Duration
+	}
+	// file growth is in 8KB pages
+	if strings.HasSuffix(t.EventName, "Auto Grow") || strings.HasSuffix(t.EventName, "Auto Shrink") {
+		event["size_change_kb"] = t.IntegerData * 8
+	}
+	event.enrich(i)
+
+	event["xe_category"] = "default_trace"
+	switch {
+	case t.Error > 0 && t.Severity >= 11:
+		event["xe_severity_value"] = logstash.Error
+	case strings.Contains(t.EventName, "Login Failed"):
+		event["xe_severity_value"] = logstash.Warning
+	}
+	event["xe_severity_keyword"] = event["xe_severity_value"].(logstash.Severity).String()
+
+	if _, ok := event["xe_description"]; !ok {
+		desc := t.EventName
+		if t.SubClassName != "" {
+			desc += fmt.Sprintf(" (%s)", t.SubClassName)
+		}
+		for _, s := range []string{t.DatabaseName, t.ObjectName, t.FileName, t.LoginName} {
+			if s != "" {
+				desc += " " + s
+			}
+		}
+		event["xe_description"] = desc
+	}
+	return event
+}
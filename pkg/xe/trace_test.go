@@ -0,0 +1,54 @@
+package xe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/billgraziano/xelogstash/pkg/logstash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceEventName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("data_file_auto_grow", TraceEventName("Data File Auto Grow"))
+	assert.Equal("object_created", TraceEventName("Object:Created"))
+	assert.Equal("audit_login_failed", TraceEventName("Audit Login Failed"))
+}
+
+func TestNewTraceEvent(t *testing.T) {
+	assert := assert.New(t)
+	ts := time.Date(2024, 5, 1, 9, 2, 11, 0, time.UTC)
+	event := NewTraceEvent(&i, 2*time.Hour, TraceEvent{
+		EventClass:    92,
+		EventName:     "Data File Auto Grow",
+		StartTime:     ts,
+		EventSequence: 1234,
+		SPID:          55,
+		DatabaseName:  "Sales",
+		FileName:      "Sales_Data",
+		Duration:      12000,
+		IntegerData:   128,
+	})
+	assert.Equal("data_file_auto_grow", event.Name())
+	assert.Equal(time.Date(2024, 5, 1, 7, 2, 11, 0, time.UTC), event.Timestamp())
+	assert.Equal(int64(1024), event["size_change_kb"])
+	assert.Equal(int64(55), event["session_id"])
+	assert.Equal("default_trace", event["xe_category"])
+	assert.Equal(logstash.Info, event["xe_severity_value"])
+	assert.Equal("Data File Auto Grow Sales Sales_Data", event["xe_description"])
+	assert.Equal("D30", event["mssql_server_name"])
+
+	event = NewTraceEvent(&i, 0, TraceEvent{
+		EventClass: 20,
+		EventName:  "Audit Login Failed",
+		StartTime:  ts,
+		LoginName:  "sa",
+		TextData:   "Login failed for user 'sa'. Reason: Password did not match that for the login provided. [CLIENT: 10.0.0.5]",
+		Error:      18456,
+		Severity:   14,
+	})
+	assert.Equal("audit_login_failed", event.Name())
+	assert.Equal(int64(18456), event["error_number"])
+	assert.Equal(logstash.Error, event["xe_severity_value"])
+	assert.Equal(logstash.Error.String(), event["xe_severity_keyword"])
+}
@@ -60,11 +60,39 @@ func (i *SQLInfo) getDataValue(object string, x xmlData, eventData string) inter
 	dtkey := FieldTypeKey{Object: object, Name: x.Name}
 	dt, found := i.Fields[dtkey]
 	if !found {
+		i.notice("field:" + object + "." + x.Name)
 		return x.Value
 	}
+	if _, text, ok := i.getMapValue(object, x); ok {
+		return text
+	}
 	return getValue(x.Name, dt, x.Value, eventData)
 }
 
+// getMapValue decodes a field whose type in SQLInfo.Fields is an XE map
+// such as wait_types or lock_mode.  A type is a map if it has values in
+// sys.dm_xe_map_values.  It returns the numeric key and the text
+// for it.  The text comes from the event if SQL Server included it or else
+// from sys.dm_xe_map_values.
+func (i *SQLInfo) getMapValue(object string, x xmlData) (int64, string, bool) {
+	dt, found := i.Fields[FieldTypeKey{Object: object, Name: x.Name}]
+	if !found || !i.MapTypes.Contains(dt) {
+		return 0, "", false
+	}
+	key, err := strconv.ParseInt(strings.TrimSpace(x.Value), 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	if x.Text != "" {
+		return key, x.Text, true
+	}
+	text, found := i.MapValues[MapValueKey{Name: dt, MapKey: int(key)}]
+	if !found {
+		return 0, "", false
+	}
+	return key, text, true
+}
+
 // getValue takes the field name, data type, and string value and returns a native GO type.
 func getValue(name, datatype, value, eventData string) interface{} {
 	var newValue interface{}
@@ -137,11 +165,16 @@ func Parse(i *SQLInfo, eventData string, beta bool) (Event, error) {
 		// some events have their own timestamp field
 		// we use the event name underscore timestamp in this case
 		// memory_broker_ring_buffer_recorded_timestamp
+		key := d.Name
 		if d.Name == "timestamp" {
-			key := ed.Name + "_" + d.Name
-			event[key] = dataValue
-		} else {
-			event[d.Name] = dataValue
+			key = ed.Name + "_" + d.Name
+		}
+		event[key] = dataValue
+
+		// map values keep the numeric key in a "_key" field
+		// lock_mode: "X" and lock_mode_key: 5
+		if mapKey, _, ok := i.getMapValue(ed.Name, d); ok {
+			event[key+"_key"] = mapKey
 		}
 	}
 
@@ -181,6 +214,8 @@ func Parse(i *SQLInfo, eventData string, beta bool) (Event, error) {
 			return event, errors.Wrap(err, "getinnerxml")
 		}
 		event["xml_deadlock_report"] = xmldeadlockreport
+		// a graph we can't read still has the raw report
+		_ = event.setDeadlock(i, xmldeadlockreport)
 	}
 
 	if ed.Name == "blocked_process_report" {
@@ -189,9 +224,24 @@ func Parse(i *SQLInfo, eventData string, beta bool) (Event, error) {
 			return event, errors.Wrap(err, "getinnerxml")
 		}
 		event["blocked_process_report"] = blockedProcessReport
+		// a report we can't read still has the raw XML
+		_ = event.setBlockedProcess(i, blockedProcessReport)
+	}
+
+	event.enrich(i)
+	if beta {
+		// no beta features in this release
 	}
+	return event, nil
+}
+
+// enrich adds the severity, category, description and server fields
+// to an event.  It is used for every event no matter where it was read.
+func (e *Event) enrich(i *SQLInfo) {
+	event := *e
+	name := event.Name()
 
-	if ed.Name == "errorlog_written" {
+	if name == "errorlog_written" {
 		event.parseErrorLogMessage()
 	}
 
@@ -241,14 +291,10 @@ func Parse(i *SQLInfo, eventData string, beta bool) (Event, error) {
 		event["xe_category"] = category
 	}
 
-	if ed.Name == "error_reported" {
+	if name == "error_reported" {
 		event.parseErrorReported(i, desc)
 	}
 	event.SetExtraUnits() // used to be a beta feature
-	if beta {
-		// no beta features in this release
-	}
-	return event, nil
 }
 
 func (e *Event) parseErrorReported(i *SQLInfo, desc string) {
@@ -416,6 +462,7 @@ func (e *Event) setDatabaseName(i *SQLInfo) {
 	}
 	dbv, exists := i.Databases[dbid]
 	if !exists {
+		i.notice(fmt.Sprintf("database:%d", dbid))
 		return
 	}
 	ts := (*e).GetTime("timestamp")
@@ -490,15 +537,6 @@ func (e *Event) getCategory() string {
 
 // getDescription return a short human readable description of the event
 func (e *Event) getDescription() string {
-
-	/*
-		Need to decode xe map values at some point
-		select *
-		from sys.dm_xe_map_values
-		where [name] = 'wait_types'
-		and map_key in ( 66, 427)
-
-	*/
 	name := e.Name()
 	switch name {
 	case "attention":
@@ -602,7 +640,7 @@ func (e *Event) getDescription() string {
 	case "lock_deadlock_chain":
 		return e.GetString("resource_description")
 	case "xml_deadlock_report":
-		return "xml_deadlock_report"
+		return e.getDeadlockDescription()
 	case "hadr_db_partner_set_sync_state":
 		return fmt.Sprintf("%s: %s -> %s (%s)", e.GetString("database_name"), e.GetString("commit_policy"), e.GetString("commit_policy_target"), e.GetString("sync_state"))
 	case "hadr_trace_message":
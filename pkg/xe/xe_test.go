@@ -800,7 +800,238 @@ func TestBlockedProcess(t *testing.T) {
 		t.Error(err)
 	}
 	assert.Equal(t, event.GetString("xe_category"), "blocked_process_report")
+	assert.Equal(t, int64(2767730), event["xe_monitor_loop"])
+	assert.Equal(t, int64(425), event["xe_blocked_spid"])
+	assert.Equal(t, "SVCLogin", event["xe_blocked_login"])
+	assert.Equal(t, "LAE-SRV4", event["xe_blocked_host"])
+	assert.Equal(t, "TestSVC_US", event["xe_blocked_app"])
+	assert.Equal(t, int64(16912), event["xe_blocked_wait_time"])
+	assert.Equal(t, "OBJECT: 7:663673412:0", event["xe_blocked_wait_resource"])
+	assert.Equal(t, "(@P1 smallint,)insert into TXNDB.dbo.TXNTable (Attempt )", event["xe_blocked_input_buffer"])
+	assert.Equal(t, "IX", event["xe_blocked_lock_mode"])
+	assert.Equal(t, int64(315), event["xe_blocking_spid"])
+	assert.Equal(t, `PROD\batchsvc`, event["xe_blocking_login"])
+	assert.Equal(t, "running", event["xe_blocking_status"])
+	assert.Equal(t, "EXECUTE psx_NightlyTasks", event["xe_blocking_input_buffer"])
+	_, ok := event["xe_blocking_wait_time"]
+	assert.False(t, ok)
 	// t.Log(jsonBytes)
 	jsonString := string(jsonBytes)
 	t.Log("JSON String: ", jsonString)
 }
+
+func TestMapValues(t *testing.T) {
+	assert := assert.New(t)
+	info := SQLInfo{
+		Fields: map[FieldTypeKey]string{
+			{"lock_acquired", "mode"}:          "lock_mode",
+			{"lock_acquired", "resource_type"}: "lock_resource_type",
+			{"lock_acquired", "duration"}:      "uint64",
+			{"wait_info", "wait_type"}:         "wait_types",
+			{"wait_info", "signal_duration"}:   "sql_timespan",
+		},
+		MapValues: map[MapValueKey]string{
+			{"lock_mode", 5}:           "X",
+			{"lock_resource_type", 7}:  "OBJECT",
+			{"wait_types", 66}:         "PAGEIOLATCH_SH",
+			{"lock_resource_type", 99}: "unused",
+		},
+		MapTypes: NewSet[string](),
+	}
+	for k := range info.MapValues {
+		info.MapTypes.Add(k.Name)
+	}
+
+	// the native .xel reader includes the text but a ring buffer might not
+	rawXML := `<event name="lock_acquired" package="sqlserver" timestamp="2024-04-08T16:00:53.427Z">
+		<data name="mode"><type name="lock_mode" package="sqlserver"/><value>5</value></data>
+		<data name="resource_type"><type name="lock_resource_type" package="sqlserver"/><value>7</value><text><![CDATA[OBJECT]]></text></data>
+		<data name="duration"><value>42</value></data>
+	</event>`
+	event, err := Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.Equal("X", event["mode"])
+	assert.Equal(int64(5), event["mode_key"])
+	assert.Equal("OBJECT", event["resource_type"])
+	assert.Equal(int64(7), event["resource_type_key"])
+	assert.Equal(uint64(42), event["duration"])
+	_, ok := event["duration_key"]
+	assert.False(ok)
+
+	// keys that aren't in sys.dm_xe_map_values stay numeric
+	rawXML = `<event name="wait_info" package="sqlos" timestamp="2024-04-08T16:00:53.427Z">
+		<data name="wait_type"><value>427</value></data>
+	</event>`
+	event, err = Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.Equal("427", event["wait_type"])
+	_, ok = event["wait_type_key"]
+	assert.False(ok)
+
+	// a type without map values isn't a map so it gets no _key
+	rawXML = `<event name="wait_info" package="sqlos" timestamp="2024-04-08T16:00:53.427Z">
+		<data name="signal_duration"><value>12</value><text><![CDATA[12 ms]]></text></data>
+	</event>`
+	event, err = Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.Equal("12 ms", event["signal_duration"])
+	_, ok = event["signal_duration_key"]
+	assert.False(ok)
+}
+
+func TestDeadlockReport(t *testing.T) {
+	assert := assert.New(t)
+	rawXML := `<event name="xml_deadlock_report" package="sqlserver" timestamp="2024-05-01T14:02:11.123Z">
+	<data name="xml_report"><type name="xml" package="package0"/><value>
+	<deadlock>
+		<victim-list><victimProcess id="process1a2b"/></victim-list>
+		<process-list>
+			<process id="process1a2b" taskpriority="0" logused="272" waitresource="KEY: 5:72057594043170816 (8194443284a0)" waittime="2134" lockMode="U" transactionname="user_transaction" spid="55" clientapp="Microsoft SQL Server Management Studio - Query" hostname="WS01" loginname="CORP\alice" isolationlevel="read committed (2)" currentdb="5" currentdbname="Sales">
+				<executionStack><frame procname="adhoc" line="1"><![CDATA[UPDATE dbo.Orders SET Qty = 2 WHERE ID = 1]]></frame></executionStack>
+				<inputbuf>
+	UPDATE dbo.Orders SET Qty = 2 WHERE ID = 1   </inputbuf>
+			</process>
+			<process id="process3c4d" waitresource="OBJECT: 5:901578250:0" waittime="3050" lockMode="IX" spid="57" clientapp="OrderService" hostname="APP02" loginname="svc_orders" isolationlevel="serializable (4)" currentdb="5">
+				<inputbuf>EXEC dbo.ShipOrder @ID = 1</inputbuf>
+			</process>
+		</process-list>
+		<resource-list>
+			<keylock hobtid="72057594043170816" dbid="5" objectname="Sales.dbo.Orders" indexname="PK_Orders" id="lock1" mode="X" associatedObjectId="72057594043170816">
+				<owner-list><owner id="process3c4d" mode="X"/></owner-list>
+				<waiter-list><waiter id="process1a2b" mode="U" requestType="wait"/></waiter-list>
+			</keylock>
+			<objectlock lockPartition="0" objid="901578250" subresource="FULL" dbid="5" objectname="Sales.dbo.Shipments" id="lock2" mode="IX" associatedObjectId="901578250">
+				<owner-list><owner id="process1a2b" mode="IX"/></owner-list>
+				<waiter-list><waiter id="process3c4d" mode="X" requestType="wait"/></waiter-list>
+			</objectlock>
+		</resource-list>
+	</deadlock>
+	</value></data>
+	</event>`
+
+	info := i
+	info.Databases = map[int64]*Database{5: {Name: "Sales"}}
+	event, err := Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.Equal("deadlock", event.GetString("xe_category"))
+	assert.Contains(event.GetString("xml_deadlock_report"), "<deadlock>")
+	assert.Equal([]int64{55}, event["xe_deadlock_victims"])
+
+	processes, ok := event["xe_deadlock_processes"].([]DeadlockProcess)
+	assert.True(ok)
+	assert.Equal(2, len(processes))
+	assert.Equal(DeadlockProcess{
+		ID:              "process1a2b",
+		SPID:            55,
+		Victim:          true,
+		Login:           `CORP\alice`,
+		Host:            "WS01",
+		App:             "Microsoft SQL Server Management Studio - Query",
+		Database:        "Sales",
+		IsolationLevel:  "read committed (2)",
+		TransactionName: "user_transaction",
+		LockMode:        "U",
+		WaitResource:    "KEY: 5:72057594043170816 (8194443284a0)",
+		WaitTime:        2134,
+		InputBuffer:     "UPDATE dbo.Orders SET Qty = 2 WHERE ID = 1",
+	}, processes[0])
+	assert.False(processes[1].Victim)
+	assert.Equal("Sales", processes[1].Database, "database name from the database id")
+
+	resources, ok := event["xe_deadlock_resources"].([]DeadlockResource)
+	assert.True(ok)
+	assert.Equal(2, len(resources))
+	assert.Equal("keylock", resources[0].Type)
+	assert.Equal("Sales.dbo.Orders", resources[0].ObjectName)
+	assert.Equal("PK_Orders", resources[0].IndexName)
+	assert.Equal([]DeadlockLock{{Process: "process3c4d", SPID: 57, Mode: "X"}}, resources[0].Owners)
+	assert.Equal([]DeadlockLock{{Process: "process1a2b", SPID: 55, Mode: "U"}}, resources[0].Waiters)
+	assert.Equal("objectlock", resources[1].Type)
+
+	assert.Equal(`victim 55 (CORP\alice - Microsoft SQL Server Management Studio - Query) in Sales; survivor 57 (svc_orders - OrderService) in Sales; keylock Sales.dbo.Orders (PK_Orders); objectlock Sales.dbo.Shipments`,
+		event.GetString("xe_description"))
+
+	_, err = json.Marshal(event)
+	assert.NoError(err)
+}
+
+func TestDeadlockList(t *testing.T) {
+	assert := assert.New(t)
+	report := `<deadlock-list><deadlock victim="process2">
+		<process-list>
+			<process id="process1" spid="60" loginname="a"/>
+			<process id="process2" spid="61" loginname="b"/>
+		</process-list>
+		<resource-list><pagelock fileid="1" pageid="99" dbid="7" objectname="db.dbo.t" id="lock3" mode="X"/></resource-list>
+	</deadlock></deadlock-list>`
+	processes, resources, err := i.parseDeadlockReport(report)
+	assert.NoError(err)
+	assert.Equal(2, len(processes))
+	assert.False(processes[0].Victim)
+	assert.True(processes[1].Victim)
+	assert.Equal(1, len(resources))
+	assert.Equal("pagelock", resources[0].Type)
+
+	_, _, err = i.parseDeadlockReport("<nothing/>")
+	assert.Error(err)
+}
+
+func TestStaleMetadata(t *testing.T) {
+	assert := assert.New(t)
+	info := SQLInfo{
+		Fields: map[FieldTypeKey]string{
+			{"database_created", "database_id"}: "uint32",
+		},
+		Databases: map[int64]*Database{
+			5: {Name: "Sales", CreateDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	info.loaded()
+	assert.False(info.Stale())
+
+	rawXML := `<event name="database_created" package="sqlserver" timestamp="2024-04-08T16:00:53.427Z">
+		<data name="database_id"><value>5</value></data>
+	</event>`
+	event, err := Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.Equal("Sales", event["database_name"])
+	assert.False(info.Stale())
+
+	// a new database forces a reload
+	rawXML = `<event name="database_created" package="sqlserver" timestamp="2024-04-08T16:00:53.427Z">
+		<data name="database_id"><value>9</value></data>
+	</event>`
+	_, err = Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.True(info.Stale())
+
+	// but only once if the reload doesn't find it
+	info.loaded()
+	assert.False(info.Stale())
+	_, err = Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.False(info.Stale())
+
+	// so does a field we don't know
+	rawXML = `<event name="database_created" package="sqlserver" timestamp="2024-04-08T16:00:53.427Z">
+		<data name="database_name"><value>Sales</value></data>
+		<data name="new_column"><value>1</value></data>
+	</event>`
+	_, err = Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.True(info.Stale())
+
+	// the next load forgets database 9 so it's noticed again
+	info.loaded()
+	assert.False(info.missing["database:9"])
+	assert.True(info.missing["field:database_created.new_column"])
+	info.Databases[9] = &Database{Name: "New"}
+	info.loaded()
+	assert.Nil(info.missing)
+	rawXML = `<event name="database_created" package="sqlserver" timestamp="2024-04-08T16:00:53.427Z">
+		<data name="database_id"><value>10</value></data>
+	</event>`
+	_, err = Parse(&info, rawXML, false)
+	assert.NoError(err)
+	assert.True(info.Stale())
+}